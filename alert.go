@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CommandWatch = "watch"
+
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	WebhookRaw      = "raw"
+	WebhookSlack    = "slack"
+	WebhookDingTalk = "dingtalk"
+)
+
+// a rule of the watch command, a pool is matched by InstanceType and the optional Region and Zone.
+//
+// With Metric it fires when the metric of a matched pool is above Above or below Below,
+// the metrics are the sort keys core,memory,price,discount,risk,forecast,hourly,monthly.
+// With OutOfTop it fires when no matched pool is ranked within the top OutOfTop.
+type AlertRule struct {
+	Name         string   `json:"Name"`
+	InstanceType string   `json:"InstanceType"`
+	Region       string   `json:"Region"`
+	Zone         string   `json:"Zone"`
+	Metric       string   `json:"Metric"`
+	Above        *float64 `json:"Above"`
+	Below        *float64 `json:"Below"`
+	OutOfTop     int      `json:"OutOfTop"`
+}
+
+// a webhook the alerts are posted to, Format is one of raw,slack,dingtalk
+type Webhook struct {
+	URL    string `json:"URL"`
+	Format string `json:"Format"`
+}
+
+// the rule file of the watch command
+type AlertRules struct {
+	Rules    []AlertRule `json:"Rules"`
+	Webhooks []Webhook   `json:"Webhooks"`
+	// a firing alert is sent again after this long, 0 sends it only once
+	RepeatInterval string `json:"RepeatInterval"`
+}
+
+// LoadAlertRules reads the rule file in json.
+func LoadAlertRules(path string) (*AlertRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &AlertRules{}
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("invalid alert rules %s: %v", path, err)
+	}
+	return rules, rules.Validate()
+}
+
+// Validate checks every rule and webhook.
+func (ar *AlertRules) Validate() error {
+	if len(ar.Rules) == 0 {
+		return fmt.Errorf("no alert rule is given")
+	}
+	names := make(map[string]bool)
+	for index, rule := range ar.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", index)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %s is given twice", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.InstanceType == "" {
+			return fmt.Errorf("rule %s has no instance type", rule.Name)
+		}
+		switch {
+		case rule.Metric != "" && rule.OutOfTop > 0:
+			return fmt.Errorf("rule %s has both a metric and a top", rule.Name)
+		case rule.Metric != "":
+			if _, ok := sortKeys[rule.Metric]; !ok {
+				return fmt.Errorf("unknown metric %s of rule %s, use one of %s", rule.Metric, rule.Name, strings.Join(SortKeys(), ","))
+			}
+			if rule.Above == nil && rule.Below == nil {
+				return fmt.Errorf("rule %s has neither above nor below", rule.Name)
+			}
+		case rule.OutOfTop <= 0:
+			return fmt.Errorf("rule %s has neither a metric nor a top", rule.Name)
+		}
+	}
+	for _, webhook := range ar.Webhooks {
+		switch webhook.Format {
+		case WebhookRaw, WebhookSlack, WebhookDingTalk, "":
+		default:
+			return fmt.Errorf("unknown format %s of webhook, use one of raw,slack,dingtalk", webhook.Format)
+		}
+	}
+	if _, err := ar.Repeat(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Repeat is the parsed RepeatInterval.
+func (ar *AlertRules) Repeat() (time.Duration, error) {
+	if ar.RepeatInterval == "" {
+		return 0, nil
+	}
+	repeat, err := time.ParseDuration(ar.RepeatInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid repeat interval %s: %v", ar.RepeatInterval, err)
+	}
+	return repeat, nil
+}
+
+// the payload posted by a raw webhook
+type Alert struct {
+	Status         string    `json:"Status"`
+	Rule           string    `json:"Rule"`
+	RegionId       string    `json:"RegionId"`
+	ZoneId         string    `json:"ZoneId"`
+	InstanceTypeId string    `json:"InstanceTypeId"`
+	Value          float64   `json:"Value"`
+	Rank           int       `json:"Rank"`
+	Message        string    `json:"Message"`
+	Time           time.Time `json:"Time"`
+
+	// the region the alert is decided in, empty for every region
+	scope string
+	// the top of a rule is a single alert whichever pool is ranked best
+	top bool
+}
+
+// key of the alert for de-duplication, a top alert is keyed by its rule and region only
+func (a Alert) key() string {
+	if a.top {
+		return strings.Join([]string{a.Rule, a.scope}, "/")
+	}
+	return strings.Join([]string{a.Rule, a.RegionId, a.ZoneId, a.InstanceTypeId}, "/")
+}
+
+func (rule AlertRule) matches(price InstancePrice) bool {
+	return price.InstanceTypeId == rule.InstanceType &&
+		(rule.Region == "" || price.RegionId == rule.Region) &&
+		(rule.Zone == "" || price.ZoneId == rule.Zone)
+}
+
+// Evaluate returns the firing alerts of the rules over the rank.
+func (ar *AlertRules) Evaluate(prices SortedInstancePrices, now time.Time) []Alert {
+	sort.Sort(prices)
+	alerts := make([]Alert, 0)
+	for _, rule := range ar.Rules {
+		if rule.OutOfTop > 0 {
+			alerts = append(alerts, rule.evaluateTop(prices, now)...)
+			continue
+		}
+		for index, price := range prices {
+			if !rule.matches(price) {
+				continue
+			}
+			value := sortKeys[rule.Metric](price)
+			var message string
+			switch {
+			case rule.Above != nil && value > *rule.Above:
+				message = fmt.Sprintf("%s in %s %s %.4f is above %g", price.InstanceTypeId, price.ZoneId, rule.Metric, value, *rule.Above)
+			case rule.Below != nil && value < *rule.Below:
+				message = fmt.Sprintf("%s in %s %s %.4f is below %g", price.InstanceTypeId, price.ZoneId, rule.Metric, value, *rule.Below)
+			default:
+				continue
+			}
+			alerts = append(alerts, Alert{
+				Status:         AlertFiring,
+				Rule:           rule.Name,
+				RegionId:       price.RegionId,
+				ZoneId:         price.ZoneId,
+				InstanceTypeId: price.InstanceTypeId,
+				Value:          value,
+				Rank:           index + 1,
+				Message:        message,
+				Time:           now,
+				scope:          price.RegionId,
+			})
+		}
+	}
+	return alerts
+}
+
+// evaluateTop fires once for the rule when its best matched pool is not in the top.
+func (rule AlertRule) evaluateTop(prices SortedInstancePrices, now time.Time) []Alert {
+	for index, price := range prices {
+		if rule.matches(price) {
+			if index < rule.OutOfTop {
+				return nil
+			}
+			return []Alert{{
+				Status:         AlertFiring,
+				Rule:           rule.Name,
+				RegionId:       price.RegionId,
+				ZoneId:         price.ZoneId,
+				InstanceTypeId: price.InstanceTypeId,
+				Value:          price.Score,
+				Rank:           index + 1,
+				Message:        fmt.Sprintf("%s in %s dropped out of the top %d to rank %d", price.InstanceTypeId, price.ZoneId, rule.OutOfTop, index+1),
+				Time:           now,
+				scope:          rule.Region,
+				top:            true,
+			}}
+		}
+	}
+	return []Alert{{
+		Status:         AlertFiring,
+		Rule:           rule.Name,
+		RegionId:       rule.Region,
+		ZoneId:         rule.Zone,
+		InstanceTypeId: rule.InstanceType,
+		Message:        fmt.Sprintf("%s dropped out of the rank", rule.InstanceType),
+		Time:           now,
+		scope:          rule.Region,
+		top:            true,
+	}}
+}
+
+// Watcher evaluates the rules on every rank and notifies the webhooks of the changes.
+//
+// A firing alert is sent when it starts firing and again every RepeatInterval,
+// a resolved alert is sent once when it stops firing. An alert only changes when
+// its region was ranked, a region that failed to scan neither fires nor resolves it.
+type Watcher struct {
+	Rules *AlertRules
+	// every watched region, an alert of all regions is decided when all of them were ranked
+	Regions []string
+	Client  *http.Client
+	// alert key -> last firing alert and when it was sent
+	firing map[string]Alert
+	sent   map[string]time.Time
+}
+
+// Notify evaluates the rank of the ranked regions and posts the new, repeated and resolved alerts.
+func (wa *Watcher) Notify(prices SortedInstancePrices, ranked []string, now time.Time) []Alert {
+	if wa.firing == nil {
+		wa.firing = make(map[string]Alert)
+		wa.sent = make(map[string]time.Time)
+	}
+	repeat, _ := wa.Rules.Repeat()
+
+	regions := make(map[string]bool)
+	for _, region := range ranked {
+		regions[region] = true
+	}
+	decided := func(alert Alert) bool {
+		if alert.scope != "" {
+			return regions[alert.scope]
+		}
+		for _, region := range wa.Regions {
+			if !regions[region] {
+				return false
+			}
+		}
+		return true
+	}
+
+	notified := make([]Alert, 0)
+	current := make(map[string]bool)
+	for _, alert := range wa.Rules.Evaluate(prices, now) {
+		if !decided(alert) {
+			continue
+		}
+		key := alert.key()
+		current[key] = true
+		wa.firing[key] = alert
+		sent, ok := wa.sent[key]
+		if ok && (repeat <= 0 || now.Sub(sent) < repeat) {
+			continue
+		}
+		wa.sent[key] = now
+		notified = append(notified, alert)
+	}
+
+	keys := make([]string, 0)
+	for key, alert := range wa.firing {
+		if !current[key] && decided(alert) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		alert := wa.firing[key]
+		alert.Status = AlertResolved
+		alert.Message = "resolved: " + alert.Message
+		alert.Time = now
+		notified = append(notified, alert)
+		delete(wa.firing, key)
+		delete(wa.sent, key)
+	}
+
+	for _, alert := range notified {
+		fmt.Fprintf(os.Stderr, "Alert %s %s: %s\n", alert.Status, alert.Rule, alert.Message)
+		for _, webhook := range wa.Rules.Webhooks {
+			if err := wa.post(webhook, alert); err != nil {
+				log.Warnf("Failed to post alert %s to webhook,because of %v", alert.Rule, err)
+			}
+		}
+	}
+	return notified
+}
+
+// post sends the alert in the format of the webhook.
+func (wa *Watcher) post(webhook Webhook, alert Alert) error {
+	text := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(alert.Status), alert.Rule, alert.Message)
+	var payload interface{}
+	switch webhook.Format {
+	case WebhookSlack:
+		payload = map[string]string{"text": text}
+	case WebhookDingTalk:
+		payload = map[string]interface{}{"msgtype": "text", "text": map[string]string{"content": text}}
+	default:
+		payload = alert
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := wa.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(webhook.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertPool is a pool of the region ranked by its score.
+func alertPool(region, zone, instanceType string, discount float64, score float64) InstancePrice {
+	price := exprPool(instanceType, "ecs.c6", 2, 4, zone, discount/10)
+	price.RegionId = region
+	price.Score = score
+	return price
+}
+
+func alertStatuses(alerts []Alert) []string {
+	statuses := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		statuses = append(statuses, alert.Status+" "+alert.Rule+" "+alert.ZoneId)
+	}
+	return statuses
+}
+
+func TestAlertRulesValidate(t *testing.T) {
+	above := 3.0
+	cases := []struct {
+		name  string
+		rules AlertRules
+		err   string
+	}{
+		{"metric", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "ecs.c6.large", Metric: "discount", Above: &above}}}, ""},
+		{"top", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "ecs.c6.large", OutOfTop: 3}}, RepeatInterval: "1h"}, ""},
+		{"no rule", AlertRules{}, "no alert rule is given"},
+		{"no name", AlertRules{Rules: []AlertRule{{InstanceType: "ecs.c6.large", OutOfTop: 3}}}, "rule 0 has no name"},
+		{"twice", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", OutOfTop: 1}, {Name: "a", InstanceType: "y", OutOfTop: 1}}}, "rule a is given twice"},
+		{"no instance type", AlertRules{Rules: []AlertRule{{Name: "a", OutOfTop: 3}}}, "rule a has no instance type"},
+		{"metric and top", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", Metric: "core", Above: &above, OutOfTop: 3}}}, "both a metric and a top"},
+		{"unknown metric", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", Metric: "cost", Above: &above}}}, "unknown metric cost"},
+		{"no bound", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", Metric: "core"}}}, "neither above nor below"},
+		{"nothing", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x"}}}, "neither a metric nor a top"},
+		{"webhook", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", OutOfTop: 1}}, Webhooks: []Webhook{{URL: "u", Format: "teams"}}}, "unknown format teams"},
+		{"repeat", AlertRules{Rules: []AlertRule{{Name: "a", InstanceType: "x", OutOfTop: 1}}, RepeatInterval: "daily"}, "invalid repeat interval daily"},
+	}
+	for _, c := range cases {
+		err := c.rules.Validate()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestWatcherNotify(t *testing.T) {
+	above := 3.0
+	watcher := &Watcher{
+		Rules: &AlertRules{
+			Rules: []AlertRule{
+				{Name: "expensive", InstanceType: "ecs.c6.large", Metric: SortByDiscount, Above: &above},
+				{Name: "top", InstanceType: "ecs.c6.large", Region: "cn-hangzhou", OutOfTop: 1},
+			},
+			RepeatInterval: "6h",
+		},
+		Regions: []string{"cn-hangzhou"},
+	}
+	begin := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	regions := []string{"cn-hangzhou"}
+
+	steps := []struct {
+		name   string
+		after  time.Duration
+		prices SortedInstancePrices
+		ranked []string
+		want   []string
+	}{
+		{"quiet", 0, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 2, 1),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 2),
+		}, regions, []string{}},
+		{"fire", time.Hour, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 4, 3),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 2),
+		}, regions, []string{"firing expensive cn-hangzhou-h", "firing top cn-hangzhou-h"}},
+		// the best pool of the top rule moved to another zone, it is the same alert
+		{"repeat within the interval", 2 * time.Hour, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 4, 5),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.c6.large", 2, 3),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 2),
+		}, regions, []string{}},
+		// a failed region decides nothing
+		{"region not ranked", 3 * time.Hour, SortedInstancePrices{}, []string{}, []string{}},
+		{"repeat after the interval", 8 * time.Hour, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 4, 5),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.c6.large", 2, 3),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 2),
+		}, regions, []string{"firing expensive cn-hangzhou-h", "firing top cn-hangzhou-i"}},
+		{"resolve", 9 * time.Hour, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 2, 1),
+			alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 2),
+		}, regions, []string{"resolved expensive cn-hangzhou-h", "resolved top cn-hangzhou-i"}},
+		{"resolved once", 10 * time.Hour, SortedInstancePrices{
+			alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 2, 1),
+		}, regions, []string{}},
+	}
+	for _, step := range steps {
+		notified := watcher.Notify(step.prices, step.ranked, begin.Add(step.after))
+		if got := alertStatuses(notified); !equalStrings(got, step.want) {
+			t.Errorf("%s: notified %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestWatcherNotifyRegions(t *testing.T) {
+	watcher := &Watcher{
+		Rules: &AlertRules{Rules: []AlertRule{
+			{Name: "top", InstanceType: "ecs.c6.large", OutOfTop: 1},
+			{Name: "top-shanghai", InstanceType: "ecs.c6.large", Region: "cn-shanghai", OutOfTop: 1},
+		}},
+		Regions: []string{"cn-hangzhou", "cn-shanghai"},
+	}
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	// ecs.c6.large is ranked nowhere
+	notified := watcher.Notify(SortedInstancePrices{
+		alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 1),
+	}, []string{"cn-hangzhou", "cn-shanghai"}, now)
+	if got := alertStatuses(notified); !equalStrings(got, []string{"firing top ", "firing top-shanghai "}) {
+		t.Errorf("notified %v", got)
+	}
+
+	// cn-shanghai failed, the top of every region and of cn-shanghai are not decided
+	notified = watcher.Notify(SortedInstancePrices{
+		alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 2, 1),
+	}, []string{"cn-hangzhou"}, now.Add(time.Hour))
+	if len(notified) != 0 {
+		t.Errorf("notified %v without cn-shanghai", alertStatuses(notified))
+	}
+
+	notified = watcher.Notify(SortedInstancePrices{
+		alertPool("cn-shanghai", "cn-shanghai-b", "ecs.c6.large", 2, 1),
+	}, []string{"cn-hangzhou", "cn-shanghai"}, now.Add(2*time.Hour))
+	if got := alertStatuses(notified); !equalStrings(got, []string{"resolved top-shanghai ", "resolved top "}) {
+		t.Errorf("notified %v", got)
+	}
+}
+
+func TestWatcherWebhooks(t *testing.T) {
+	lock := sync.Mutex{}
+	bodies := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("%s: %v", r.URL.Path, err)
+		}
+		lock.Lock()
+		bodies[r.URL.Path] = body
+		lock.Unlock()
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	watcher := &Watcher{
+		Rules: &AlertRules{
+			Rules: []AlertRule{{Name: "top", InstanceType: "ecs.c6.large", Region: "cn-hangzhou", OutOfTop: 1}},
+			Webhooks: []Webhook{
+				{URL: server.URL + "/raw"},
+				{URL: server.URL + "/slack", Format: WebhookSlack},
+				{URL: server.URL + "/dingtalk", Format: WebhookDingTalk},
+				{URL: server.URL + "/broken"},
+			},
+		},
+		Regions: []string{"cn-hangzhou"},
+	}
+	prices := SortedInstancePrices{
+		alertPool("cn-hangzhou", "cn-hangzhou-i", "ecs.g6.large", 2, 1),
+		alertPool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 2, 2),
+	}
+	if notified := watcher.Notify(prices, []string{"cn-hangzhou"}, time.Now()); len(notified) != 1 {
+		t.Fatalf("notified %v", alertStatuses(notified))
+	}
+
+	text := "[FIRING] top: ecs.c6.large in cn-hangzhou-h dropped out of the top 1 to rank 2"
+	if raw := bodies["/raw"]; raw["Status"] != AlertFiring || raw["ZoneId"] != "cn-hangzhou-h" || raw["Rank"] != 2.0 {
+		t.Errorf("raw payload is %v", raw)
+	}
+	if slack := bodies["/slack"]; slack["text"] != text {
+		t.Errorf("slack payload is %v", slack)
+	}
+	if dingtalk := bodies["/dingtalk"]; dingtalk["msgtype"] != "text" || dingtalk["text"].(map[string]interface{})["content"] != text {
+		t.Errorf("dingtalk payload is %v", dingtalk)
+	}
+	if _, ok := bodies["/broken"]; !ok {
+		t.Errorf("the failing webhook is not posted")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	sdkErrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	WeightByPrice = "price"
+	WeightByCore  = "core"
+
+	VSwitchPlaceholder = "<vswitch-of-%s>"
+
+	// the api answers a dry run that passed its checks with this error code
+	DryRunOperation = "DryRunOperation"
+)
+
+// The ecs api to create the auto provisioning group.
+// *ecsService.Client satisfies it, so does FakeClient.
+type ProvisioningClient interface {
+	CreateAutoProvisioningGroup(request *ecsService.CreateAutoProvisioningGroupRequest) (*ecsService.CreateAutoProvisioningGroupResponse, error)
+}
+
+// options of the auto provisioning group generated from the rank
+type APGOptions struct {
+	Name                   string
+	LaunchTemplateId       string
+	LaunchTemplateVersion  string
+	TotalTargetCapacity    int
+	SpotAllocationStrategy string
+	Weight                 string
+	MaxSpotPrice           float64
+	Top                    int
+	VSwitches              map[string]string
+	// cap every pool by its recommended price limit instead of its on-demand price
+	UseBid bool
+}
+
+// one launch template override of the group
+type APGLaunchTemplateConfig struct {
+	InstanceType     string  `json:"InstanceType"`
+	ZoneId           string  `json:"ZoneId"`
+	VSwitchId        string  `json:"VSwitchId"`
+	WeightedCapacity float64 `json:"WeightedCapacity"`
+	MaxPrice         float64 `json:"MaxPrice"`
+	Priority         int     `json:"Priority"`
+}
+
+// ready to apply spec of CreateAutoProvisioningGroup
+type APGSpec struct {
+	AutoProvisioningGroupName   string                    `json:"AutoProvisioningGroupName"`
+	AutoProvisioningGroupType   string                    `json:"AutoProvisioningGroupType"`
+	LaunchTemplateId            string                    `json:"LaunchTemplateId"`
+	LaunchTemplateVersion       string                    `json:"LaunchTemplateVersion"`
+	TotalTargetCapacity         int                       `json:"TotalTargetCapacity"`
+	SpotTargetCapacity          int                       `json:"SpotTargetCapacity"`
+	PayAsYouGoTargetCapacity    int                       `json:"PayAsYouGoTargetCapacity"`
+	DefaultTargetCapacityType   string                    `json:"DefaultTargetCapacityType"`
+	SpotAllocationStrategy      string                    `json:"SpotAllocationStrategy"`
+	SpotInstancePoolsToUseCount int                       `json:"SpotInstancePoolsToUseCount"`
+	MaxSpotPrice                float64                   `json:"MaxSpotPrice"`
+	LaunchTemplateConfig        []APGLaunchTemplateConfig `json:"LaunchTemplateConfig"`
+}
+
+// BuildAPGSpec picks the top launchable pools of the rank and derives their weights from the price.
+//
+// With WeightByPrice the cheapest pool weighs 1 and every other pool weighs its
+// price relative to it, so TotalTargetCapacity is a budget in cheapest instances.
+// With WeightByCore every pool weighs its cores and the capacity is in vCPU.
+// With UseBid every pool is capped by its recommended price limit, the pools
+// recommended SpotAsPriceGo keep their on-demand price.
+func BuildAPGSpec(prices SortedInstancePrices, opts APGOptions) (*APGSpec, error) {
+	sort.Sort(prices)
+
+	top := make(SortedInstancePrices, 0, len(prices))
+	for _, price := range prices {
+		if price.Launchable() {
+			top = append(top, price)
+		}
+	}
+	if opts.Top > 0 && len(top) > opts.Top {
+		top = top[:opts.Top]
+	}
+	if len(top) == 0 {
+		return nil, fmt.Errorf("no spot instance left to build the auto provisioning group")
+	}
+
+	cheapest := math.MaxFloat64
+	maxPrice := 0.0
+	for _, price := range top {
+		cheapest = math.Min(cheapest, price.SpotPrice)
+		maxPrice = math.Max(maxPrice, poolMaxPrice(price, opts.UseBid))
+	}
+	if opts.MaxSpotPrice > 0 {
+		maxPrice = opts.MaxSpotPrice
+	}
+
+	spec := &APGSpec{
+		AutoProvisioningGroupName:   opts.Name,
+		AutoProvisioningGroupType:   "maintain",
+		LaunchTemplateId:            opts.LaunchTemplateId,
+		LaunchTemplateVersion:       opts.LaunchTemplateVersion,
+		TotalTargetCapacity:         opts.TotalTargetCapacity,
+		SpotTargetCapacity:          opts.TotalTargetCapacity,
+		PayAsYouGoTargetCapacity:    0,
+		DefaultTargetCapacityType:   "Spot",
+		SpotAllocationStrategy:      opts.SpotAllocationStrategy,
+		SpotInstancePoolsToUseCount: len(top),
+		MaxSpotPrice:                round(maxPrice, 4),
+		LaunchTemplateConfig:        make([]APGLaunchTemplateConfig, 0, len(top)),
+	}
+
+	for index, price := range top {
+		var weight float64
+		switch opts.Weight {
+		case WeightByPrice, "":
+			weight = price.SpotPrice / cheapest
+		case WeightByCore:
+			weight = float64(price.CpuCoreCount)
+		default:
+			return nil, fmt.Errorf("unknown weight %s, use one of price,core", opts.Weight)
+		}
+
+		vswitch, ok := opts.VSwitches[price.ZoneId]
+		if !ok {
+			vswitch = fmt.Sprintf(VSwitchPlaceholder, price.ZoneId)
+		}
+
+		spec.LaunchTemplateConfig = append(spec.LaunchTemplateConfig, APGLaunchTemplateConfig{
+			InstanceType:     price.InstanceTypeId,
+			ZoneId:           price.ZoneId,
+			VSwitchId:        vswitch,
+			WeightedCapacity: round(weight, 2),
+			MaxPrice:         round(math.Min(poolMaxPrice(price, opts.UseBid), maxPrice), 4),
+			Priority:         index,
+		})
+	}
+
+	return spec, nil
+}
+
+// poolMaxPrice is the on-demand price of the pool or its recommended price limit with bid.
+func poolMaxPrice(price InstancePrice, bid bool) float64 {
+	if bid && price.Bid.SpotStrategy == SpotWithPriceLimit && price.Bid.SpotPriceLimit > 0 {
+		return math.Min(price.Bid.SpotPriceLimit, price.OriginPrice)
+	}
+	return price.OriginPrice
+}
+
+// Validate reports what still blocks CreateAutoProvisioningGroup.
+func (spec *APGSpec) Validate() error {
+	problems := make([]string, 0)
+	if spec.LaunchTemplateId == "" {
+		problems = append(problems, "launch template id is empty")
+	}
+	if spec.TotalTargetCapacity <= 0 {
+		problems = append(problems, "total target capacity must be positive")
+	}
+	for _, config := range spec.LaunchTemplateConfig {
+		if strings.HasPrefix(config.VSwitchId, "<") {
+			problems = append(problems, fmt.Sprintf("vswitch of zone %s is a placeholder", config.ZoneId))
+			break
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid auto provisioning group: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Request converts the spec into the api request.
+func (spec *APGSpec) Request() *ecsService.CreateAutoProvisioningGroupRequest {
+	req := ecsService.CreateCreateAutoProvisioningGroupRequest()
+	req.AutoProvisioningGroupName = spec.AutoProvisioningGroupName
+	req.AutoProvisioningGroupType = spec.AutoProvisioningGroupType
+	req.LaunchTemplateId = spec.LaunchTemplateId
+	req.LaunchTemplateVersion = spec.LaunchTemplateVersion
+	req.TotalTargetCapacity = strconv.Itoa(spec.TotalTargetCapacity)
+	req.SpotTargetCapacity = strconv.Itoa(spec.SpotTargetCapacity)
+	req.PayAsYouGoTargetCapacity = strconv.Itoa(spec.PayAsYouGoTargetCapacity)
+	req.DefaultTargetCapacityType = spec.DefaultTargetCapacityType
+	req.SpotAllocationStrategy = spec.SpotAllocationStrategy
+	req.SpotInstancePoolsToUseCount = requests.NewInteger(spec.SpotInstancePoolsToUseCount)
+	req.MaxSpotPrice = requests.NewFloat(spec.MaxSpotPrice)
+
+	configs := make([]ecsService.CreateAutoProvisioningGroupLaunchTemplateConfig, 0, len(spec.LaunchTemplateConfig))
+	for _, config := range spec.LaunchTemplateConfig {
+		configs = append(configs, ecsService.CreateAutoProvisioningGroupLaunchTemplateConfig{
+			InstanceType:     config.InstanceType,
+			VSwitchId:        config.VSwitchId,
+			WeightedCapacity: strconv.FormatFloat(config.WeightedCapacity, 'f', -1, 64),
+			MaxPrice:         strconv.FormatFloat(config.MaxPrice, 'f', -1, 64),
+			Priority:         strconv.Itoa(config.Priority),
+		})
+	}
+	req.LaunchTemplateConfig = &configs
+	return req
+}
+
+// CreateAPG validates the spec and creates the group. With dryRun the api only checks
+// the request, the quota and the permissions and creates nothing.
+func CreateAPG(client ProvisioningClient, spec *APGSpec, dryRun bool) (string, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	req := spec.Request()
+	if dryRun {
+		// the request of the vendored sdk has no DryRun field
+		req.GetQueryParams()["DryRun"] = "true"
+	}
+	resp, err := client.CreateAutoProvisioningGroup(req)
+	if dryRun {
+		if e, ok := err.(*sdkErrors.ServerError); ok && e.ErrorCode() == DryRunOperation {
+			return "", nil
+		}
+		if err == nil {
+			return resp.AutoProvisioningGroupId, fmt.Errorf("dry run is ignored, group %s is created", resp.AutoProvisioningGroupId)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.AutoProvisioningGroupId, nil
+}
+
+// ParseVSwitches reads "zoneId=vswitchId" pairs separated by comma.
+func ParseVSwitches(value string) (map[string]string, error) {
+	vswitches := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid vswitch %q, expect zoneId=vswitchId", pair)
+		}
+		vswitches[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return vswitches, nil
+}
+
+func round(value float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(value*p) / p
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CommandBacktest = "backtest"
+
+	StrategyTop       = "top"
+	StrategyTypes     = "types"
+	StrategyPortfolio = "portfolio"
+)
+
+// instances to run in a pool until the next rebalance
+type Allocation struct {
+	Pool  InstancePrice
+	Count int
+}
+
+// Strategy chooses the pools to run from the rank at every rebalance of a backtest.
+type Strategy interface {
+	Name() string
+	Select(prices SortedInstancePrices) ([]Allocation, error)
+}
+
+// TopStrategy runs the top Pools of the rank, each with enough instances for its share of TargetCpu.
+// With Distinct only the best pool of every instanceType is taken, the rule of 10 kinds of instanceTypes.
+// Pools without stock are never taken, even when -show-unavailable keeps them in the rank.
+type TopStrategy struct {
+	Pools     int
+	Distinct  bool
+	TargetCpu int
+}
+
+func (s TopStrategy) Name() string {
+	if s.Distinct {
+		return fmt.Sprintf("%s %d", StrategyTypes, s.Pools)
+	}
+	return fmt.Sprintf("%s %d", StrategyTop, s.Pools)
+}
+
+func (s TopStrategy) Select(prices SortedInstancePrices) ([]Allocation, error) {
+	sort.Sort(prices)
+	pools := make([]InstancePrice, 0, s.Pools)
+	seen := make(map[string]bool)
+	for _, price := range prices {
+		if len(pools) == s.Pools {
+			break
+		}
+		if !price.Launchable() || s.Distinct && seen[price.InstanceTypeId] {
+			continue
+		}
+		seen[price.InstanceTypeId] = true
+		pools = append(pools, price)
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no launchable pool is ranked")
+	}
+
+	allocations := make([]Allocation, 0, len(pools))
+	for _, pool := range pools {
+		count := 1
+		if s.TargetCpu > 0 && pool.CpuCoreCount > 0 {
+			share := float64(s.TargetCpu) / float64(len(pools))
+			count = int(math.Ceil(share / float64(pool.CpuCoreCount)))
+		}
+		allocations = append(allocations, Allocation{Pool: pool, Count: count})
+	}
+	return allocations, nil
+}
+
+// PortfolioStrategy runs the portfolio of the rank.
+type PortfolioStrategy struct {
+	Options PortfolioOptions
+}
+
+func (s PortfolioStrategy) Name() string {
+	return StrategyPortfolio
+}
+
+func (s PortfolioStrategy) Select(prices SortedInstancePrices) ([]Allocation, error) {
+	p, err := OptimizePortfolio(prices, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	allocations := make([]Allocation, 0, len(p.Items))
+	for _, item := range p.Items {
+		for _, price := range prices {
+			if price.InstanceTypeId == item.InstanceTypeId && price.ZoneId == item.ZoneId {
+				allocations = append(allocations, Allocation{Pool: price, Count: item.Count})
+				break
+			}
+		}
+	}
+	return allocations, nil
+}
+
+// Backtest replays the price history of the regions through a strategy.
+//
+// At every Rebalance from the start of Window the pools are analyzed over the Lookback before it
+// by SpotPricesAnalysis of their MetaStore, ranked by Model and handed to the Strategy.
+// The chosen instances run until the next rebalance with a price limit of Bid times the spot price
+// at launch, capped by the on-demand price. Bid 0 means SpotAsPriceGo, which pays the spot price
+// up to the on-demand price. An instance is interrupted when the spot price goes above its limit
+// and stays down until the next rebalance.
+type Backtest struct {
+	MetaStores map[string]*MetaStore
+	// price history of the regions covering the lookback of the first rebalance, region -> instanceType -> prices
+	Prices    map[string]map[string][]ecsService.SpotPriceType
+	Window    PriceWindow
+	Lookback  time.Duration
+	Rebalance time.Duration
+	Model     RankModel
+	Strategy  Strategy
+	Bid       float64
+}
+
+// the result of a pool over the backtest
+type BacktestPool struct {
+	InstanceTypeId string  `json:"InstanceTypeId"`
+	RegionId       string  `json:"RegionId"`
+	ZoneId         string  `json:"ZoneId"`
+	Rebalances     int     `json:"Rebalances"`
+	InstanceHours  float64 `json:"InstanceHours"`
+	Cost           float64 `json:"Cost"`
+	Interruptions  int     `json:"Interruptions"`
+}
+
+// the result of a backtest
+type BacktestReport struct {
+	Strategy   string `json:"Strategy"`
+	Start      string `json:"Start"`
+	End        string `json:"End"`
+	Rebalances int    `json:"Rebalances"`
+	// spot cost and the cost of the same instance hours on demand
+	Cost         float64 `json:"Cost"`
+	OnDemandCost float64 `json:"OnDemandCost"`
+	// 1 - Cost / OnDemandCost
+	Savings float64 `json:"Savings"`
+	// core hours run and lost to interruptions
+	CoreHours     float64 `json:"CoreHours"`
+	LostCoreHours float64 `json:"LostCoreHours"`
+	Interruptions int     `json:"Interruptions"`
+	// pools entered or left at the rebalances
+	Churn int            `json:"Churn"`
+	Pools []BacktestPool `json:"Pools"`
+}
+
+// Run simulates every rebalance of the window.
+func (b *Backtest) Run() (*BacktestReport, error) {
+	if b.Rebalance <= 0 {
+		return nil, fmt.Errorf("rebalance interval %s is not positive", b.Rebalance)
+	}
+	if b.Lookback <= 0 {
+		return nil, fmt.Errorf("lookback %s is not positive", b.Lookback)
+	}
+
+	report := &BacktestReport{
+		Strategy: b.Strategy.Name(),
+		Start:    b.Window.StartTime(),
+		End:      b.Window.EndTime(),
+	}
+	pools := make(map[string]*BacktestPool)
+	previous := make(map[string]bool)
+
+	for t := b.Window.Start; t.Before(b.Window.End); t = t.Add(b.Rebalance) {
+		next := t.Add(b.Rebalance)
+		if next.After(b.Window.End) {
+			next = b.Window.End
+		}
+
+		prices, err := b.rank(PriceWindow{Start: t.Add(-b.Lookback), End: t})
+		if err != nil {
+			return nil, fmt.Errorf("failed to rank the pools at %s: %v", t.Format(TimeLayout), err)
+		}
+		allocations, err := b.Strategy.Select(prices)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select pools at %s: %v", t.Format(TimeLayout), err)
+		}
+		report.Rebalances++
+
+		current := make(map[string]bool)
+		for _, allocation := range allocations {
+			pool := allocation.Pool
+			key := strings.Join([]string{pool.RegionId, pool.ZoneId, pool.InstanceTypeId}, "/")
+			current[key] = true
+			if pools[key] == nil {
+				pools[key] = &BacktestPool{InstanceTypeId: pool.InstanceTypeId, RegionId: pool.RegionId, ZoneId: pool.ZoneId}
+			}
+			result := pools[key]
+			result.Rebalances++
+
+			bid := pool.OriginPrice
+			if b.Bid > 0 {
+				bid = math.Min(b.Bid*pool.SpotPrice, pool.OriginPrice)
+			}
+			hours, cost, interrupted := b.simulate(pool, bid, t, next)
+			count := float64(allocation.Count)
+			result.InstanceHours += count * hours
+			result.Cost += count * cost
+			report.Cost += count * cost
+			report.OnDemandCost += count * hours * pool.OriginPrice
+			report.CoreHours += count * hours * float64(pool.CpuCoreCount)
+			if interrupted {
+				result.Interruptions += allocation.Count
+				report.Interruptions += allocation.Count
+				report.LostCoreHours += count * (next.Sub(t).Hours() - hours) * float64(pool.CpuCoreCount)
+			}
+		}
+
+		if report.Rebalances > 1 {
+			for key := range current {
+				if !previous[key] {
+					report.Churn++
+				}
+			}
+			for key := range previous {
+				if !current[key] {
+					report.Churn++
+				}
+			}
+		}
+		previous = current
+	}
+
+	if report.OnDemandCost > 0 {
+		report.Savings = 1 - report.Cost/report.OnDemandCost
+	}
+	report.Pools = make([]BacktestPool, 0, len(pools))
+	for _, pool := range pools {
+		report.Pools = append(report.Pools, *pool)
+	}
+	sort.Slice(report.Pools, func(i, j int) bool {
+		x, y := report.Pools[i], report.Pools[j]
+		if x.Cost != y.Cost {
+			return x.Cost > y.Cost
+		}
+		return x.InstanceTypeId+x.ZoneId < y.InstanceTypeId+y.ZoneId
+	})
+	return report, nil
+}
+
+// rank analyzes the pools of every region over the window and scores them together.
+func (b *Backtest) rank(window PriceWindow) (SortedInstancePrices, error) {
+	regions := make([]string, 0, len(b.MetaStores))
+	for region := range b.MetaStores {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	prices := make(SortedInstancePrices, 0)
+	for _, region := range regions {
+		metastore := b.MetaStores[region]
+		historyPrices := make(map[string][]ecsService.SpotPriceType)
+		for instanceType, recorded := range b.Prices[region] {
+			if sliced := slicePrices(recorded, window); len(sliced) > 0 {
+				historyPrices[instanceType] = sliced
+			}
+		}
+		metastore.AnalysisOptions.Window = window
+		analyzed, err := metastore.SpotPricesAnalysis(historyPrices)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, analyzed...)
+	}
+	if err := b.Model.Score(prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// simulate runs an instance of the pool from start to end with the price limit, it returns the hours it ran,
+// what they cost and whether the spot price went above the limit.
+func (b *Backtest) simulate(pool InstancePrice, bid float64, start, end time.Time) (float64, float64, bool) {
+	zonePrices := make([]ecsService.SpotPriceType, 0)
+	for _, price := range b.Prices[pool.RegionId][pool.InstanceTypeId] {
+		if price.ZoneId == pool.ZoneId {
+			zonePrices = append(zonePrices, price)
+		}
+	}
+	points := parsePricePoints(slicePrices(zonePrices, PriceWindow{Start: start, End: end}))
+
+	hours, cost := 0.0, 0.0
+	for i, point := range points {
+		if point.price > bid {
+			return hours, cost, true
+		}
+		next := end
+		if i+1 < len(points) {
+			next = points[i+1].time
+		}
+		segment := next.Sub(point.time).Hours()
+		hours += segment
+		cost += segment * point.price
+	}
+	return hours, cost, false
+}
+
+// Write renders the report as a table or json.
+func (r *BacktestReport) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputTable, "":
+		fmt.Fprintf(w, "Strategy %s from %s to %s, %d rebalances\n", r.Strategy, r.Start, r.End, r.Rebalances)
+		fmt.Fprintf(w, "  cost %.4f  on-demand %.4f  savings %.1f%%\n", r.Cost, r.OnDemandCost, 100*r.Savings)
+		fmt.Fprintf(w, "  core hours %.1f  lost %.1f  interruptions %d  churn %d\n\n", r.CoreHours, r.LostCoreHours, r.Interruptions, r.Churn)
+		fmt.Fprintf(w, "%30s %20s %12s %15s %12s %15s\n", "InstanceTypeId", "ZoneId", "Rebalances", "InstanceHours", "Cost", "Interruptions")
+		for _, pool := range r.Pools {
+			fmt.Fprintf(w, "%30s %20s %12d %15.1f %12.4f %15d\n", pool.InstanceTypeId, pool.ZoneId, pool.Rebalances, pool.InstanceHours, pool.Cost, pool.Interruptions)
+		}
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	}
+	return fmt.Errorf("unknown output format %s of backtest, use one of table,json", format)
+}
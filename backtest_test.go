@@ -0,0 +1,195 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopStrategySelect(t *testing.T) {
+	pool := func(instanceType, zone string, stock string, score float64) InstancePrice {
+		price := exprPool(instanceType, "ecs.c6", 4, 8, zone, score*4)
+		price.Stock = stock
+		price.Score = score
+		return price
+	}
+	// the cheapest pool has no stock
+	soldOut := pool("ecs.g6.xlarge", "cn-hangzhou-b", StockSoldOut, 0.01)
+	prices := SortedInstancePrices{
+		pool("ecs.c6.xlarge", "cn-hangzhou-h", StockWithStock, 0.02),
+		pool("ecs.c6.xlarge", "cn-hangzhou-i", StockWithStock, 0.021),
+		soldOut,
+		pool("ecs.r6.xlarge", "cn-hangzhou-h", StockWithStock, 0.03),
+	}
+
+	cases := []struct {
+		name     string
+		strategy TopStrategy
+		want     []string
+		err      string
+	}{
+		{"top", TopStrategy{Pools: 2}, []string{"ecs.c6.xlarge cn-hangzhou-h 1", "ecs.c6.xlarge cn-hangzhou-i 1"}, ""},
+		{"types", TopStrategy{Pools: 2, Distinct: true}, []string{"ecs.c6.xlarge cn-hangzhou-h 1", "ecs.r6.xlarge cn-hangzhou-h 1"}, ""},
+		// 10 cores over 2 pools of 4 cores each
+		{"target cpu", TopStrategy{Pools: 2, TargetCpu: 10}, []string{"ecs.c6.xlarge cn-hangzhou-h 2", "ecs.c6.xlarge cn-hangzhou-i 2"}, ""},
+		{"more pools than ranked", TopStrategy{Pools: 10}, []string{"ecs.c6.xlarge cn-hangzhou-h 1", "ecs.c6.xlarge cn-hangzhou-i 1", "ecs.r6.xlarge cn-hangzhou-h 1"}, ""},
+	}
+	for _, c := range cases {
+		allocations, err := c.strategy.Select(prices)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := make([]string, 0, len(allocations))
+		for _, allocation := range allocations {
+			got = append(got, strings.Join([]string{allocation.Pool.InstanceTypeId, allocation.Pool.ZoneId, strconv.Itoa(allocation.Count)}, " "))
+		}
+		if !equalStrings(got, c.want) {
+			t.Errorf("%s: selected %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if _, err := (TopStrategy{Pools: 1}).Select(SortedInstancePrices{soldOut}); err == nil || !strings.Contains(err.Error(), "no launchable pool") {
+		t.Errorf("error of a rank without stock is %v", err)
+	}
+}
+
+// backtestPrices is the history of an instanceType in cn-hangzhou-h, a price from every hour after 2026-10-11T00:00:00Z.
+func backtestPrices(instanceType string, origin float64, prices map[int]float64) []ecsService.SpotPriceType {
+	start := time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC)
+	history := make([]ecsService.SpotPriceType, 0, len(prices))
+	for hour, price := range prices {
+		history = append(history, ecsService.SpotPriceType{
+			ZoneId:       "cn-hangzhou-h",
+			InstanceType: instanceType,
+			Timestamp:    start.Add(time.Duration(hour) * time.Hour).Format(TimeLayout),
+			SpotPrice:    price,
+			OriginPrice:  origin,
+		})
+	}
+	return history
+}
+
+// newSyntheticBacktest runs the cheaper of ecs.c6.large and ecs.g6.large per core every day from 2026-10-12 to 2026-10-15.
+//
+// ecs.c6.large costs 0.04 until it spikes to 0.2 at 2026-10-13T12:00:00Z, and settles at 0.07 at 18:00,
+// ecs.g6.large costs 0.06 all along, so the third day runs ecs.g6.large.
+func newSyntheticBacktest(t *testing.T, bid float64) *Backtest {
+	t.Helper()
+	ms := NewMetaStore(nil)
+	ms.Region = "cn-hangzhou"
+	for _, instanceType := range []ecsService.InstanceType{
+		{InstanceTypeId: "ecs.c6.large", InstanceTypeFamily: "ecs.c6", CpuCoreCount: 2, MemorySize: 4},
+		{InstanceTypeId: "ecs.g6.large", InstanceTypeFamily: "ecs.g6", CpuCoreCount: 2, MemorySize: 8},
+	} {
+		ms.InstanceFamilyCache[instanceType.InstanceTypeId] = instanceType
+		ms.ZoneStocks[instanceType.InstanceTypeId] = map[string]string{"cn-hangzhou-h": StockWithStock}
+	}
+
+	window, err := NewPriceWindow("2026-10-12T00:00:00Z", "2026-10-15T00:00:00Z", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Backtest{
+		MetaStores: map[string]*MetaStore{"cn-hangzhou": ms},
+		Prices: map[string]map[string][]ecsService.SpotPriceType{"cn-hangzhou": {
+			"ecs.c6.large": backtestPrices("ecs.c6.large", 0.39, map[int]float64{0: 0.04, 60: 0.2, 66: 0.07}),
+			"ecs.g6.large": backtestPrices("ecs.g6.large", 0.5, map[int]float64{0: 0.06}),
+		}},
+		Window:    window,
+		Lookback:  24 * time.Hour,
+		Rebalance: 24 * time.Hour,
+		Model:     RankModel{Key: SortByCore},
+		Strategy:  TopStrategy{Pools: 1},
+		Bid:       bid,
+	}
+}
+
+func TestBacktestRun(t *testing.T) {
+	cases := []struct {
+		name string
+		bid  float64
+		want BacktestReport
+		// cost of ecs.c6.large and ecs.g6.large
+		costs map[string]float64
+	}{
+		// the bid of 0.048 is interrupted by the spike 12 hours into the second day
+		{"price limit", 1.2, BacktestReport{
+			Rebalances:    3,
+			Cost:          24*0.04 + 12*0.04 + 24*0.06,
+			OnDemandCost:  36*0.39 + 24*0.5,
+			CoreHours:     (36 + 24) * 2,
+			LostCoreHours: 12 * 2,
+			Interruptions: 1,
+			Churn:         2,
+		}, map[string]float64{"ecs.c6.large": 36 * 0.04, "ecs.g6.large": 24 * 0.06}},
+		// SpotAsPriceGo pays the spike up to the on-demand price
+		{"as price go", 0, BacktestReport{
+			Rebalances:   3,
+			Cost:         24*0.04 + 12*0.04 + 6*0.2 + 6*0.07 + 24*0.06,
+			OnDemandCost: 48*0.39 + 24*0.5,
+			CoreHours:    (48 + 24) * 2,
+			Churn:        2,
+		}, map[string]float64{"ecs.c6.large": 24*0.04 + 12*0.04 + 6*0.2 + 6*0.07, "ecs.g6.large": 24 * 0.06}},
+	}
+	for _, c := range cases {
+		report, err := newSyntheticBacktest(t, c.bid).Run()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if report.Strategy != "top 1" || report.Start != "2026-10-12T00:00:00Z" || report.End != "2026-10-15T00:00:00Z" {
+			t.Errorf("%s: report of %s from %s to %s", c.name, report.Strategy, report.Start, report.End)
+		}
+		if report.Rebalances != c.want.Rebalances || report.Interruptions != c.want.Interruptions || report.Churn != c.want.Churn {
+			t.Errorf("%s: %d rebalances, %d interruptions, churn %d, want %d, %d, %d", c.name,
+				report.Rebalances, report.Interruptions, report.Churn, c.want.Rebalances, c.want.Interruptions, c.want.Churn)
+		}
+		if !closeTo(report.Cost, c.want.Cost) || !closeTo(report.OnDemandCost, c.want.OnDemandCost) {
+			t.Errorf("%s: cost %g on demand %g, want %g and %g", c.name, report.Cost, report.OnDemandCost, c.want.Cost, c.want.OnDemandCost)
+		}
+		if !closeTo(report.Savings, 1-c.want.Cost/c.want.OnDemandCost) {
+			t.Errorf("%s: savings %g", c.name, report.Savings)
+		}
+		if !closeTo(report.CoreHours, c.want.CoreHours) || !closeTo(report.LostCoreHours, c.want.LostCoreHours) {
+			t.Errorf("%s: %g core hours and %g lost, want %g and %g", c.name, report.CoreHours, report.LostCoreHours, c.want.CoreHours, c.want.LostCoreHours)
+		}
+		if len(report.Pools) != len(c.costs) {
+			t.Fatalf("%s: %d pools in the report", c.name, len(report.Pools))
+		}
+		for _, pool := range report.Pools {
+			if !closeTo(pool.Cost, c.costs[pool.InstanceTypeId]) {
+				t.Errorf("%s: cost of %s is %g, want %g", c.name, pool.InstanceTypeId, pool.Cost, c.costs[pool.InstanceTypeId])
+			}
+		}
+		// the most expensive pool first
+		if report.Pools[0].Cost < report.Pools[1].Cost {
+			t.Errorf("%s: pools are not ordered by cost", c.name)
+		}
+	}
+}
+
+func TestBacktestRunErrors(t *testing.T) {
+	backtest := newSyntheticBacktest(t, 1.2)
+	backtest.Rebalance = 0
+	if _, err := backtest.Run(); err == nil || !strings.Contains(err.Error(), "rebalance interval 0s is not positive") {
+		t.Errorf("error of no rebalance is %v", err)
+	}
+
+	backtest = newSyntheticBacktest(t, 1.2)
+	backtest.Model = RankModel{Key: "nosuchkey"}
+	if _, err := backtest.Run(); err == nil || !strings.Contains(err.Error(), "failed to rank the pools at 2026-10-12T00:00:00Z") {
+		t.Errorf("error of a bad model is %v", err)
+	}
+
+	// nothing can be launched
+	backtest = newSyntheticBacktest(t, 1.2)
+	for instanceType := range backtest.MetaStores["cn-hangzhou"].ZoneStocks {
+		backtest.MetaStores["cn-hangzhou"].ZoneStocks[instanceType]["cn-hangzhou-h"] = StockSoldOut
+	}
+	backtest.MetaStores["cn-hangzhou"].ShowUnavailable = true
+	if _, err := backtest.Run(); err == nil || !strings.Contains(err.Error(), "no launchable pool is ranked") {
+		t.Errorf("error of a rank without stock is %v", err)
+	}
+}
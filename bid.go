@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	CommandBid = "bid"
+
+	SpotWithPriceLimit = "SpotWithPriceLimit"
+	SpotAsPriceGo      = "SpotAsPriceGo"
+)
+
+// options of the recommended price limit of every pool
+type BidOptions struct {
+	// fraction of the window the spot price has to stay at or below the limit
+	Coverage float64
+	// SpotAsPriceGo is recommended when the limit reaches this fraction of the on-demand price
+	GoRatio float64
+}
+
+// the recommended SpotPriceLimit of a pool and the spot strategy to use it with
+type BidAdvice struct {
+	SpotPriceLimit float64 `json:"SpotPriceLimit"`
+	// times the spot price went above the limit in the window
+	Breaches     int    `json:"Breaches"`
+	SpotStrategy string `json:"SpotStrategy"`
+}
+
+// Validate checks the coverage and the ratio.
+func (opts BidOptions) Validate() error {
+	if opts.Coverage <= 0 || opts.Coverage > 1 {
+		return fmt.Errorf("bid coverage %g is not within (0,1]", opts.Coverage)
+	}
+	if opts.GoRatio <= 0 {
+		return fmt.Errorf("bid go ratio %g is not positive", opts.GoRatio)
+	}
+	return nil
+}
+
+// RecommendBid returns the lowest price limit the spot price stayed at or below for
+// Coverage of the window, as a step function like CalculatePriceStats, at least the current price
+// and at most the on-demand price, which is the most a spot instance is charged.
+// The limit is rounded up to the precision of the prices, so it can be passed as
+// SpotPriceLimit of RunInstances or MaxSpotPrice of an auto provisioning group.
+//
+// A limit close to the on-demand price saves little over SpotAsPriceGo, which pays
+// the spot price up to the on-demand price and is never released for its price,
+// so SpotAsPriceGo is recommended once the limit reaches GoRatio of the on-demand price.
+func RecommendBid(prices []ecsService.SpotPriceType, window PriceWindow, opts BidOptions) BidAdvice {
+	advice := BidAdvice{}
+	points := parsePricePoints(prices)
+	if len(points) == 0 {
+		return advice
+	}
+
+	end := window.End
+	if end.IsZero() || end.Before(points[len(points)-1].time) {
+		end = points[len(points)-1].time
+	}
+
+	// seconds every price held, equal weights if the history has no duration
+	type held struct {
+		price   float64
+		seconds float64
+	}
+	durations := make([]held, 0, len(points))
+	total := 0.0
+	for i, point := range points {
+		next := end
+		if i+1 < len(points) {
+			next = points[i+1].time
+		}
+		durations = append(durations, held{price: point.price, seconds: next.Sub(point.time).Seconds()})
+		total += durations[i].seconds
+	}
+	if total <= 0 {
+		for i := range durations {
+			durations[i].seconds = 1
+		}
+		total = float64(len(durations))
+	}
+	sort.SliceStable(durations, func(i, j int) bool {
+		return durations[i].price < durations[j].price
+	})
+
+	covered := 0.0
+	for _, d := range durations {
+		covered += d.seconds
+		advice.SpotPriceLimit = d.price
+		// tolerate the rounding of the sum
+		if covered >= opts.Coverage*total-1e-9 {
+			break
+		}
+	}
+	// an instance is not launched below the current price
+	advice.SpotPriceLimit = math.Max(advice.SpotPriceLimit, points[len(points)-1].price)
+	origin := points[len(points)-1].origin
+	if origin > 0 {
+		advice.SpotPriceLimit = math.Min(advice.SpotPriceLimit, origin)
+	}
+	advice.SpotPriceLimit = math.Ceil(advice.SpotPriceLimit*10000-1e-6) / 10000
+
+	for i, point := range points {
+		if point.price > advice.SpotPriceLimit && (i == 0 || points[i-1].price <= advice.SpotPriceLimit) {
+			advice.Breaches++
+		}
+	}
+
+	advice.SpotStrategy = SpotWithPriceLimit
+	if origin > 0 && advice.SpotPriceLimit >= opts.GoRatio*origin {
+		advice.SpotStrategy = SpotAsPriceGo
+	}
+	return advice
+}
+
+// PrintBids renders the recommended price limit of the top pools next to their prices, as a table or json.
+func PrintBids(w io.Writer, prices SortedInstancePrices, limit int, format string) error {
+	prices = TopPrices(prices, limit)
+
+	switch format {
+	case OutputTable, "":
+		fmt.Fprintf(w, "%30s %20s %12s %12s %15s %10s %20s\n", "InstanceTypeId", "ZoneId", "SpotPrice", "OriginPrice", "SpotPriceLimit", "Breaches", "SpotStrategy")
+		for _, price := range prices {
+			fmt.Fprintf(w, "%30s %20s %12.4f %12.4f %15.4f %10d %20s\n", price.InstanceTypeId, price.ZoneId, price.SpotPrice, price.OriginPrice, price.Bid.SpotPriceLimit, price.Bid.Breaches, price.Bid.SpotStrategy)
+		}
+		return nil
+	case OutputJSON:
+		records := make([]PriceRecord, 0, len(prices))
+		for _, price := range prices {
+			records = append(records, NewPriceRecord(price))
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	return fmt.Errorf("unknown output format %s of bid, use one of table,json", format)
+}
+
+// DefaultBidOptions covers 95% of the window.
+func DefaultBidOptions() BidOptions {
+	return BidOptions{
+		Coverage: 0.95,
+		GoRatio:  0.8,
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecommendBid(t *testing.T) {
+	flat := func(hour int) float64 { return 0.05 }
+	// 3 hours of 100 at 0.2
+	spike := func(hour int) float64 {
+		if hour >= 50 && hour < 53 {
+			return 0.2
+		}
+		return 0.05
+	}
+	// 3 hours of 100 above the on-demand price
+	storm := func(hour int) float64 {
+		if hour >= 50 && hour < 53 {
+			return 0.6
+		}
+		return 0.05
+	}
+	current := func(hour int) float64 {
+		if hour == 99 {
+			return 0.1
+		}
+		return 0.05
+	}
+	unrounded := func(hour int) float64 { return 0.04321 }
+
+	cases := []struct {
+		name     string
+		price    func(hour int) float64
+		coverage float64
+		want     BidAdvice
+	}{
+		{"flat", flat, 0.95, BidAdvice{SpotPriceLimit: 0.05, SpotStrategy: SpotWithPriceLimit}},
+		{"spike above the quantile", spike, 0.95, BidAdvice{SpotPriceLimit: 0.05, Breaches: 1, SpotStrategy: SpotWithPriceLimit}},
+		{"spike within the quantile", spike, 0.99, BidAdvice{SpotPriceLimit: 0.2, SpotStrategy: SpotWithPriceLimit}},
+		{"on-demand cap", storm, 0.99, BidAdvice{SpotPriceLimit: 0.5, Breaches: 1, SpotStrategy: SpotAsPriceGo}},
+		{"current price", current, 0.95, BidAdvice{SpotPriceLimit: 0.1, SpotStrategy: SpotWithPriceLimit}},
+		{"rounded up", unrounded, 0.95, BidAdvice{SpotPriceLimit: 0.0433, SpotStrategy: SpotWithPriceLimit}},
+	}
+	for _, c := range cases {
+		prices, window := hourlySeries(100, c.price)
+		for i := range prices {
+			prices[i].OriginPrice = 0.5
+		}
+		got := RecommendBid(prices, window, BidOptions{Coverage: c.coverage, GoRatio: 0.8})
+		if !closeTo(got.SpotPriceLimit, c.want.SpotPriceLimit) || got.Breaches != c.want.Breaches || got.SpotStrategy != c.want.SpotStrategy {
+			t.Errorf("%s: advice %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRecommendBidShortHistory(t *testing.T) {
+	opts := DefaultBidOptions()
+	if got := RecommendBid(nil, PriceWindow{Start: forecastStart, End: forecastStart}, opts); got != (BidAdvice{}) {
+		t.Errorf("advice of an empty window is %+v", got)
+	}
+
+	// a single point holds no time, so it is the limit
+	prices, window := hourlySeries(1, func(hour int) float64 { return 0.05 })
+	prices[0].OriginPrice = 0.05
+	got := RecommendBid(prices, window, opts)
+	if !closeTo(got.SpotPriceLimit, 0.05) || got.Breaches != 0 || got.SpotStrategy != SpotAsPriceGo {
+		t.Errorf("advice of a single point is %+v", got)
+	}
+}
+
+func TestBidOptionsValidate(t *testing.T) {
+	cases := []struct {
+		opts BidOptions
+		err  string
+	}{
+		{DefaultBidOptions(), ""},
+		{BidOptions{Coverage: 1, GoRatio: 1.2}, ""},
+		{BidOptions{Coverage: 0, GoRatio: 0.8}, "bid coverage 0 is not within (0,1]"},
+		{BidOptions{Coverage: 1.5, GoRatio: 0.8}, "bid coverage 1.5 is not within (0,1]"},
+		{BidOptions{Coverage: 0.95, GoRatio: 0}, "bid go ratio 0 is not positive"},
+	}
+	for _, c := range cases {
+		err := c.opts.Validate()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("validate %+v: %v, want %q", c.opts, err, c.err)
+		}
+	}
+}
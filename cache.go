@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	RegionsCacheFile       = "regions.json"
+	InstanceTypesCacheFile = "instance_types.json"
+	StockCacheFile         = "available_resource.json"
+	PricesCacheDir         = "prices"
+)
+
+// how long every kind of data stays fresh in the cache
+type CacheTTL struct {
+	InstanceTypes time.Duration
+	Stock         time.Duration
+	Prices        time.Duration
+}
+
+// a cached response of the static apis
+type cacheEntry struct {
+	FetchedAt time.Time
+	Response  json.RawMessage
+}
+
+// cached price history of one instanceType in all zones, covering [From, To]
+type priceCacheEntry struct {
+	FetchedAt time.Time
+	From      time.Time
+	To        time.Time
+	Prices    []ecsService.SpotPriceType
+}
+
+// CachingClient keeps the responses under <dir>/<region> as json files.
+//
+// Instance types and regions live for TTL.InstanceTypes and stock for TTL.Stock.
+// Price history is kept per instanceType, a request only fetches the part of its
+// window that is newer than the cache, and nothing at all when the cache was
+// refreshed within TTL.Prices. A cached history is served as a single page.
+type CachingClient struct {
+	EcsClient
+	Dir string
+	TTL CacheTTL
+}
+
+func (cc *CachingClient) DescribeRegions(request *ecsService.DescribeRegionsRequest) (*ecsService.DescribeRegionsResponse, error) {
+	resp := ecsService.CreateDescribeRegionsResponse()
+	// regions are not bound to the region of the client
+	path := filepath.Join(filepath.Dir(cc.Dir), RegionsCacheFile)
+	if cc.load(path, cc.TTL.InstanceTypes, resp) {
+		return resp, nil
+	}
+	resp, err := cc.EcsClient.DescribeRegions(request)
+	if err == nil {
+		cc.save(path, resp)
+	}
+	return resp, err
+}
+
+func (cc *CachingClient) DescribeInstanceTypes(request *ecsService.DescribeInstanceTypesRequest) (*ecsService.DescribeInstanceTypesResponse, error) {
+	resp := ecsService.CreateDescribeInstanceTypesResponse()
+	path := filepath.Join(cc.Dir, InstanceTypesCacheFile)
+	if cc.load(path, cc.TTL.InstanceTypes, resp) {
+		return resp, nil
+	}
+	resp, err := cc.EcsClient.DescribeInstanceTypes(request)
+	if err == nil {
+		cc.save(path, resp)
+	}
+	return resp, err
+}
+
+func (cc *CachingClient) DescribeAvailableResource(request *ecsService.DescribeAvailableResourceRequest) (*ecsService.DescribeAvailableResourceResponse, error) {
+	resp := ecsService.CreateDescribeAvailableResourceResponse()
+	path := filepath.Join(cc.Dir, StockCacheFile)
+	if cc.load(path, cc.TTL.Stock, resp) {
+		return resp, nil
+	}
+	resp, err := cc.EcsClient.DescribeAvailableResource(request)
+	if err == nil {
+		cc.save(path, resp)
+	}
+	return resp, err
+}
+
+func (cc *CachingClient) DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error) {
+	start, err1 := time.Parse(time.RFC3339, request.StartTime)
+	end, err2 := time.Parse(time.RFC3339, request.EndTime)
+	if err1 != nil || err2 != nil {
+		return cc.EcsClient.DescribeSpotPriceHistory(request)
+	}
+
+	resp := ecsService.CreateDescribeSpotPriceHistoryResponse()
+	if offset := string(request.Offset); offset != "" && offset != "0" {
+		// the cached history was served in full as the first page
+		return resp, nil
+	}
+
+	path := filepath.Join(cc.Dir, PricesCacheDir, request.InstanceType+".json")
+	entry := priceCacheEntry{}
+	data, err := ioutil.ReadFile(path)
+	cached := err == nil && json.Unmarshal(data, &entry) == nil && !entry.From.After(start)
+
+	switch {
+	case cached && !entry.To.Before(end):
+		// the whole window is history that can not change any more
+	case cached && end.Sub(entry.To) <= cc.TTL.Prices && time.Since(entry.FetchedAt) <= cc.TTL.Prices:
+		// fresh enough, the missing minutes are within the ttl
+	default:
+		from := start
+		if cached {
+			from = entry.To
+		} else {
+			entry = priceCacheEntry{From: start}
+		}
+		prices, err := cc.fetchPrices(request, from, end)
+		if err != nil {
+			return resp, err
+		}
+		entry.Prices = mergePrices(entry.Prices, prices)
+		entry.To = end
+		entry.FetchedAt = time.Now()
+		cc.save(path, entry)
+	}
+
+	for _, price := range entry.Prices {
+		if inRange(price.Timestamp, request.StartTime, request.EndTime) {
+			resp.SpotPrices.SpotPriceType = append(resp.SpotPrices.SpotPriceType, price)
+		}
+	}
+	return resp, nil
+}
+
+// CreateAutoProvisioningGroup is never cached, it goes to the wrapped client.
+func (cc *CachingClient) CreateAutoProvisioningGroup(request *ecsService.CreateAutoProvisioningGroupRequest) (*ecsService.CreateAutoProvisioningGroupResponse, error) {
+	client, ok := cc.EcsClient.(ProvisioningClient)
+	if !ok {
+		return nil, fmt.Errorf("client of region %s can not create auto provisioning group", filepath.Base(cc.Dir))
+	}
+	return client.CreateAutoProvisioningGroup(request)
+}
+
+// DescribePrice is never cached, it goes to the wrapped client.
+func (cc *CachingClient) DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error) {
+	client, ok := cc.EcsClient.(PricingClient)
+	if !ok {
+		return nil, fmt.Errorf("client of region %s can not describe price", filepath.Base(cc.Dir))
+	}
+	return client.DescribePrice(request)
+}
+
+// fetchPrices walks every page of [from, end] of the wrapped client.
+func (cc *CachingClient) fetchPrices(request *ecsService.DescribeSpotPriceHistoryRequest, from, end time.Time) ([]ecsService.SpotPriceType, error) {
+	prices := make([]ecsService.SpotPriceType, 0)
+	offset, pages := 0, 0
+	for {
+		req := ecsService.CreateDescribeSpotPriceHistoryRequest()
+		req.RegionId = request.RegionId
+		req.NetworkType = request.NetworkType
+		req.InstanceType = request.InstanceType
+		req.IoOptimized = request.IoOptimized
+		req.StartTime = from.UTC().Format(TimeLayout)
+		req.EndTime = end.UTC().Format(TimeLayout)
+		req.Offset = requests.NewInteger(offset)
+
+		resp, err := cc.EcsClient.DescribeSpotPriceHistory(req)
+		if err != nil {
+			return nil, err
+		}
+		// an empty page of the window does not end the history, like in FetchSpotPrices
+		prices = append(prices, resp.SpotPrices.SpotPriceType...)
+		pages++
+		if resp.NextOffset <= offset || pages >= MaxSpotPricePages {
+			return prices, nil
+		}
+		offset = resp.NextOffset
+	}
+}
+
+// mergePrices drops the points seen twice in a zone and sorts by time.
+func mergePrices(cached, fetched []ecsService.SpotPriceType) []ecsService.SpotPriceType {
+	seen := make(map[string]bool)
+	merged := make([]ecsService.SpotPriceType, 0, len(cached)+len(fetched))
+	for _, prices := range [][]ecsService.SpotPriceType{cached, fetched} {
+		for _, price := range prices {
+			key := price.ZoneId + "/" + price.Timestamp
+			if !seen[key] {
+				seen[key] = true
+				merged = append(merged, price)
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp < merged[j].Timestamp
+	})
+	return merged
+}
+
+// load reports whether a fresh entry was read into resp.
+func (cc *CachingClient) load(path string, ttl time.Duration, resp interface{}) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	entry := cacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil || time.Since(entry.FetchedAt) > ttl {
+		return false
+	}
+	return json.Unmarshal(entry.Response, resp) == nil
+}
+
+// save writes through a temporary file, a failed write only costs a cache miss.
+func (cc *CachingClient) save(path string, value interface{}) {
+	var data []byte
+	var err error
+	if entry, ok := value.(priceCacheEntry); ok {
+		data, err = json.Marshal(entry)
+	} else {
+		var response []byte
+		if response, err = json.Marshal(value); err == nil {
+			data, err = json.Marshal(cacheEntry{FetchedAt: time.Now(), Response: response})
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// NewCachingClient caches the responses of the region under dir.
+func NewCachingClient(client EcsClient, dir, region string, ttl CacheTTL) *CachingClient {
+	return &CachingClient{EcsClient: client, Dir: filepath.Join(dir, region), TTL: ttl}
+}
+
+// DefaultCacheDir is under the cache directory of the user.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "spot-instance-advisor")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingClient counts the calls that reach the replayed client.
+type countingClient struct {
+	*FakeClient
+	lock  sync.Mutex
+	calls map[string]int
+	// StartTime of every price history request
+	starts []string
+}
+
+func newCountingClient(region string) *countingClient {
+	return &countingClient{FakeClient: &FakeClient{Root: "testdata", Region: region}, calls: make(map[string]int)}
+}
+
+func (cc *countingClient) count(api string) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.calls[api]++
+}
+
+func (cc *countingClient) DescribeRegions(request *ecsService.DescribeRegionsRequest) (*ecsService.DescribeRegionsResponse, error) {
+	cc.count("DescribeRegions")
+	return cc.FakeClient.DescribeRegions(request)
+}
+
+func (cc *countingClient) DescribeInstanceTypes(request *ecsService.DescribeInstanceTypesRequest) (*ecsService.DescribeInstanceTypesResponse, error) {
+	cc.count("DescribeInstanceTypes")
+	return cc.FakeClient.DescribeInstanceTypes(request)
+}
+
+func (cc *countingClient) DescribeAvailableResource(request *ecsService.DescribeAvailableResourceRequest) (*ecsService.DescribeAvailableResourceResponse, error) {
+	cc.count("DescribeAvailableResource")
+	return cc.FakeClient.DescribeAvailableResource(request)
+}
+
+func (cc *countingClient) DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error) {
+	cc.count("DescribeSpotPriceHistory")
+	cc.lock.Lock()
+	cc.starts = append(cc.starts, request.StartTime)
+	cc.lock.Unlock()
+	return cc.FakeClient.DescribeSpotPriceHistory(request)
+}
+
+func (cc *countingClient) reset() {
+	cc.calls = make(map[string]int)
+	cc.starts = nil
+}
+
+func priceHistoryRequest(instanceType string, start, end string) *ecsService.DescribeSpotPriceHistoryRequest {
+	request := ecsService.CreateDescribeSpotPriceHistoryRequest()
+	request.RegionId = "cn-hangzhou"
+	request.NetworkType = "vpc"
+	request.InstanceType = instanceType
+	request.StartTime = start
+	request.EndTime = end
+	return request
+}
+
+func TestCachingClientStatic(t *testing.T) {
+	fake := newCountingClient("cn-hangzhou")
+	client := NewCachingClient(fake, t.TempDir(), "cn-hangzhou", CacheTTL{InstanceTypes: time.Hour, Stock: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.DescribeInstanceTypes(ecsService.CreateDescribeInstanceTypesRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.InstanceTypes.InstanceType) == 0 {
+			t.Fatalf("call %d returned no instanceType", i)
+		}
+		if _, err := client.DescribeAvailableResource(ecsService.CreateDescribeAvailableResourceRequest()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.DescribeRegions(ecsService.CreateDescribeRegionsRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second round is served from the cache
+	for _, api := range []string{"DescribeInstanceTypes", "DescribeAvailableResource", "DescribeRegions"} {
+		if fake.calls[api] != 1 {
+			t.Errorf("%s is called %d times, want 1", api, fake.calls[api])
+		}
+	}
+
+	// an entry older than the ttl is fetched again, once
+	path := filepath.Join(client.Dir, InstanceTypesCacheFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := cacheEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.FetchedAt = time.Now().Add(-2 * time.Hour)
+	if data, err = json.Marshal(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake.reset()
+	for i := 0; i < 2; i++ {
+		if _, err := client.DescribeInstanceTypes(ecsService.CreateDescribeInstanceTypesRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.calls["DescribeInstanceTypes"] != 1 {
+		t.Errorf("expired DescribeInstanceTypes is called %d times, want 1", fake.calls["DescribeInstanceTypes"])
+	}
+}
+
+func TestCachingClientPrices(t *testing.T) {
+	fake := newCountingClient("cn-hangzhou")
+	client := NewCachingClient(fake, t.TempDir(), "cn-hangzhou", CacheTTL{Prices: time.Hour})
+	describe := func(start, end string) []ecsService.SpotPriceType {
+		t.Helper()
+		resp, err := client.DescribeSpotPriceHistory(priceHistoryRequest("ecs.c6.large", start, end))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a cached history is a single page
+		if resp.NextOffset != 0 {
+			t.Errorf("next offset of the cached history is %d", resp.NextOffset)
+		}
+		return resp.SpotPrices.SpotPriceType
+	}
+
+	// the first window walks both pages of the api
+	prices := describe("2026-10-11T00:00:00Z", "2026-10-15T00:00:00Z")
+	if fake.calls["DescribeSpotPriceHistory"] != 2 {
+		t.Errorf("a miss made %d calls, want 2 pages", fake.calls["DescribeSpotPriceHistory"])
+	}
+	first := len(prices)
+	if first == 0 {
+		t.Fatal("no price in the first window")
+	}
+
+	// the same window is history and a hit
+	fake.reset()
+	if prices := describe("2026-10-11T00:00:00Z", "2026-10-15T00:00:00Z"); len(prices) != first {
+		t.Errorf("hit returned %d prices, want %d", len(prices), first)
+	}
+	if fake.calls["DescribeSpotPriceHistory"] != 0 {
+		t.Errorf("a hit made %d calls", fake.calls["DescribeSpotPriceHistory"])
+	}
+
+	// a later window only fetches the gap after the cache
+	fake.reset()
+	prices = describe("2026-10-11T00:00:00Z", "2026-10-18T00:00:00Z")
+	if len(fake.starts) == 0 {
+		t.Fatal("the gap is not fetched")
+	}
+	for _, start := range fake.starts {
+		if start != "2026-10-15T00:00:00Z" {
+			t.Errorf("gap is fetched from %s, want 2026-10-15T00:00:00Z", start)
+		}
+	}
+	if len(prices) != 32 {
+		t.Errorf("merged %d prices, want all 32 of the history", len(prices))
+	}
+	seen := make(map[string]bool)
+	for i, price := range prices {
+		key := price.ZoneId + "/" + price.Timestamp
+		if seen[key] {
+			t.Errorf("%s is merged twice", key)
+		}
+		seen[key] = true
+		if i > 0 && price.Timestamp < prices[i-1].Timestamp {
+			t.Errorf("%s is not sorted after %s", price.Timestamp, prices[i-1].Timestamp)
+		}
+	}
+
+	// a window ending within the ttl of a fresh cache makes no call
+	fake.reset()
+	describe("2026-10-12T00:00:00Z", "2026-10-18T00:30:00Z")
+	if fake.calls["DescribeSpotPriceHistory"] != 0 {
+		t.Errorf("a fresh cache made %d calls", fake.calls["DescribeSpotPriceHistory"])
+	}
+
+	// an earlier start than the cache is a miss of the whole window
+	fake.reset()
+	describe("2026-10-10T00:00:00Z", "2026-10-18T00:00:00Z")
+	if len(fake.starts) == 0 || fake.starts[0] != "2026-10-10T00:00:00Z" {
+		t.Errorf("window before the cache is fetched from %v", fake.starts)
+	}
+}
+
+func TestCachingClientLaterPage(t *testing.T) {
+	fake := newCountingClient("cn-hangzhou")
+	client := NewCachingClient(fake, t.TempDir(), "cn-hangzhou", CacheTTL{Prices: time.Hour})
+	request := priceHistoryRequest("ecs.c6.large", "2026-10-11T00:00:00Z", "2026-10-18T00:00:00Z")
+	request.Offset = requests.NewInteger(16)
+	resp, err := client.DescribeSpotPriceHistory(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.SpotPrices.SpotPriceType) != 0 || len(fake.starts) != 0 {
+		t.Errorf("a later page returned %d prices with %d calls", len(resp.SpotPrices.SpotPriceType), len(fake.starts))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// an instanceType of the types command
+type TypeRecord struct {
+	InstanceTypeId     string   `json:"InstanceTypeId"`
+	RegionId           string   `json:"RegionId"`
+	InstanceTypeFamily string   `json:"InstanceTypeFamily"`
+	CpuCoreCount       int      `json:"CpuCoreCount"`
+	MemorySize         float64  `json:"MemorySize"`
+	GPUAmount          int      `json:"GPUAmount"`
+	GPUSpec            string   `json:"GPUSpec"`
+	Zones              []string `json:"Zones"`
+}
+
+// ListTypes returns the instanceTypes of the metastore matching the filter, ordered by family, cores and memory.
+func (ms *MetaStore) ListTypes(filter InstanceFilter) []TypeRecord {
+	records := make([]TypeRecord, 0)
+	for _, instanceTypeId := range ms.FilterInstances(filter) {
+		meta := ms.InstanceFamilyCache[instanceTypeId]
+		zones := make([]string, 0, len(ms.ZoneStocks[instanceTypeId]))
+		for zoneId := range ms.ZoneStocks[instanceTypeId] {
+			zones = append(zones, zoneId)
+		}
+		sort.Strings(zones)
+		records = append(records, TypeRecord{
+			InstanceTypeId:     instanceTypeId,
+			RegionId:           ms.Region,
+			InstanceTypeFamily: meta.InstanceTypeFamily,
+			CpuCoreCount:       meta.CpuCoreCount,
+			MemorySize:         meta.MemorySize,
+			GPUAmount:          meta.GPUAmount,
+			GPUSpec:            meta.GPUSpec,
+			Zones:              zones,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.InstanceTypeFamily != b.InstanceTypeFamily {
+			return a.InstanceTypeFamily < b.InstanceTypeFamily
+		}
+		if a.CpuCoreCount != b.CpuCoreCount {
+			return a.CpuCoreCount < b.CpuCoreCount
+		}
+		if a.MemorySize != b.MemorySize {
+			return a.MemorySize < b.MemorySize
+		}
+		return a.InstanceTypeId < b.InstanceTypeId
+	})
+	return records
+}
+
+// WriteTypes renders the instanceTypes as a table or json.
+func WriteTypes(w io.Writer, records []TypeRecord, format string) error {
+	switch format {
+	case OutputTable, "":
+		fmt.Fprintf(w, "%30s %15s %20s %8s %10s %8s  %s\n", "InstanceTypeId", "RegionId", "Family", "Cores", "Memory", "GPUs", "Zones")
+		for _, r := range records {
+			fmt.Fprintf(w, "%30s %15s %20s %8d %10.1f %8d  %s\n", r.InstanceTypeId, r.RegionId, r.InstanceTypeFamily, r.CpuCoreCount, r.MemorySize, r.GPUAmount, strings.Join(r.Zones, ","))
+		}
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	return fmt.Errorf("unknown output format %s of types, use one of table,json", format)
+}
+
+// a zone of the zones command with the stock of the pools of the filtered instanceTypes
+type ZoneRecord struct {
+	RegionId string `json:"RegionId"`
+	ZoneId   string `json:"ZoneId"`
+	// pools offered in the zone and how many of them can be launched
+	InstanceTypes int `json:"InstanceTypes"`
+	Launchable    int `json:"Launchable"`
+	// stock -> pools
+	Stocks map[string]int `json:"Stocks"`
+}
+
+// ListZones returns the zones offering any of the instanceTypes matching the filter.
+func (ms *MetaStore) ListZones(filter InstanceFilter) []ZoneRecord {
+	zones := make(map[string]*ZoneRecord)
+	for _, instanceTypeId := range ms.FilterInstances(filter) {
+		for zoneId, stock := range ms.ZoneStocks[instanceTypeId] {
+			zone, ok := zones[zoneId]
+			if !ok {
+				zone = &ZoneRecord{RegionId: ms.Region, ZoneId: zoneId, Stocks: make(map[string]int)}
+				zones[zoneId] = zone
+			}
+			zone.InstanceTypes++
+			if IsLaunchable(stock) {
+				zone.Launchable++
+			}
+			zone.Stocks[stock]++
+		}
+	}
+
+	records := make([]ZoneRecord, 0, len(zones))
+	for _, zone := range zones {
+		records = append(records, *zone)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ZoneId < records[j].ZoneId
+	})
+	return records
+}
+
+// WriteZones renders the zones as a table or json.
+func WriteZones(w io.Writer, records []ZoneRecord, format string) error {
+	switch format {
+	case OutputTable, "":
+		fmt.Fprintf(w, "%20s %15s %15s %15s %15s %15s\n", "ZoneId", "InstanceTypes", StockWithStock, StockClosedWithStock, StockWithoutStock, StockSoldOut)
+		for _, r := range records {
+			fmt.Fprintf(w, "%20s %15d %15d %15d %15d %15d\n", r.ZoneId, r.InstanceTypes, r.Stocks[StockWithStock], r.Stocks[StockClosedWithStock], r.Stocks[StockWithoutStock], r.Stocks[StockSoldOut])
+		}
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	return fmt.Errorf("unknown output format %s of zones, use one of table,json", format)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	sdkErrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	InstanceTypesFile     = "DescribeInstanceTypes.json"
+	AvailableResourceFile = "DescribeAvailableResource.json"
+	SpotPriceHistoryDir   = "DescribeSpotPriceHistory"
+	PriceDir              = "DescribePrice"
+)
+
+// The subset of ecs api the advisor depends on.
+// *ecsService.Client satisfies it, so does FakeClient.
+type EcsClient interface {
+	DescribeRegions(request *ecsService.DescribeRegionsRequest) (*ecsService.DescribeRegionsResponse, error)
+	DescribeInstanceTypes(request *ecsService.DescribeInstanceTypesRequest) (*ecsService.DescribeInstanceTypesResponse, error)
+	DescribeAvailableResource(request *ecsService.DescribeAvailableResourceRequest) (*ecsService.DescribeAvailableResourceResponse, error)
+	DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error)
+}
+
+// FakeClient replays the recorded json responses under <root>/<region>.
+//
+// The layout is the one RecordingClient writes:
+//
+//	DescribeInstanceTypes.json
+//	DescribeAvailableResource.json
+//	DescribeSpotPriceHistory/<instanceType>.json
+//	DescribeSpotPriceHistory/<instanceType>_<offset>.json (pages after the first)
+//	DescribePrice/<instanceType>.json
+//
+// Recorded spot prices outside StartTime and EndTime of the request are dropped.
+// Every directory under the root is a region.
+type FakeClient struct {
+	Root   string
+	Region string
+}
+
+// DescribeRegions lists the recorded regions.
+func (fc *FakeClient) DescribeRegions(request *ecsService.DescribeRegionsRequest) (*ecsService.DescribeRegionsResponse, error) {
+	resp := ecsService.CreateDescribeRegionsResponse()
+	files, err := ioutil.ReadDir(fc.Root)
+	if err != nil {
+		return resp, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			resp.Regions.Region = append(resp.Regions.Region, ecsService.Region{RegionId: file.Name(), Status: "available"})
+		}
+	}
+	return resp, nil
+}
+
+func (fc *FakeClient) DescribeInstanceTypes(request *ecsService.DescribeInstanceTypesRequest) (*ecsService.DescribeInstanceTypesResponse, error) {
+	resp := ecsService.CreateDescribeInstanceTypesResponse()
+	err := fc.load(InstanceTypesFile, resp)
+	return resp, err
+}
+
+func (fc *FakeClient) DescribeAvailableResource(request *ecsService.DescribeAvailableResourceRequest) (*ecsService.DescribeAvailableResourceResponse, error) {
+	resp := ecsService.CreateDescribeAvailableResourceResponse()
+	err := fc.load(AvailableResourceFile, resp)
+	return resp, err
+}
+
+func (fc *FakeClient) DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error) {
+	resp := ecsService.CreateDescribeSpotPriceHistoryResponse()
+	err := fc.load(spotPriceHistoryFile(request), resp)
+	if err != nil {
+		return resp, err
+	}
+
+	prices := make([]ecsService.SpotPriceType, 0, len(resp.SpotPrices.SpotPriceType))
+	for _, price := range resp.SpotPrices.SpotPriceType {
+		if inRange(price.Timestamp, request.StartTime, request.EndTime) {
+			prices = append(prices, price)
+		}
+	}
+	resp.SpotPrices.SpotPriceType = prices
+	return resp, nil
+}
+
+// DescribePrice replays the price of the instanceType whatever the rest of the request.
+func (fc *FakeClient) DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error) {
+	resp := ecsService.CreateDescribePriceResponse()
+	err := fc.load(filepath.Join(PriceDir, request.InstanceType+".json"), resp)
+	return resp, err
+}
+
+// CreateAutoProvisioningGroup creates nothing, it returns a fixed group id or passes the dry run like the api.
+func (fc *FakeClient) CreateAutoProvisioningGroup(request *ecsService.CreateAutoProvisioningGroupRequest) (*ecsService.CreateAutoProvisioningGroupResponse, error) {
+	if request.GetQueryParams()["DryRun"] == "true" {
+		return nil, sdkErrors.NewServerError(http.StatusBadRequest, `{"Code":"DryRunOperation","Message":"Request validation has been passed with DryRun flag set."}`, "")
+	}
+	resp := ecsService.CreateCreateAutoProvisioningGroupResponse()
+	resp.AutoProvisioningGroupId = "apg-replay"
+	return resp, nil
+}
+
+func (fc *FakeClient) load(name string, resp interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(fc.Root, fc.Region, name))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("Failed to parse recorded response %s,because of %v", name, err)
+	}
+	return nil
+}
+
+// RecordingClient forwards to the live client and saves every successful
+// response in the layout FakeClient reads.
+type RecordingClient struct {
+	*ecsService.Client
+	Dir string
+}
+
+func (rc *RecordingClient) DescribeInstanceTypes(request *ecsService.DescribeInstanceTypesRequest) (*ecsService.DescribeInstanceTypesResponse, error) {
+	resp, err := rc.Client.DescribeInstanceTypes(request)
+	if err == nil {
+		err = rc.save(InstanceTypesFile, resp.GetHttpContentBytes())
+	}
+	return resp, err
+}
+
+func (rc *RecordingClient) DescribeAvailableResource(request *ecsService.DescribeAvailableResourceRequest) (*ecsService.DescribeAvailableResourceResponse, error) {
+	resp, err := rc.Client.DescribeAvailableResource(request)
+	if err == nil {
+		err = rc.save(AvailableResourceFile, resp.GetHttpContentBytes())
+	}
+	return resp, err
+}
+
+func (rc *RecordingClient) DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error) {
+	resp, err := rc.Client.DescribeSpotPriceHistory(request)
+	if err == nil {
+		err = rc.save(spotPriceHistoryFile(request), resp.GetHttpContentBytes())
+	}
+	return resp, err
+}
+
+func (rc *RecordingClient) DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error) {
+	resp, err := rc.Client.DescribePrice(request)
+	if err == nil {
+		err = rc.save(filepath.Join(PriceDir, request.InstanceType+".json"), resp.GetHttpContentBytes())
+	}
+	return resp, err
+}
+
+func (rc *RecordingClient) save(name string, data []byte) error {
+	path := filepath.Join(rc.Dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func spotPriceHistoryFile(request *ecsService.DescribeSpotPriceHistoryRequest) string {
+	name := request.InstanceType
+	if offset := string(request.Offset); offset != "" && offset != "0" {
+		name += "_" + offset
+	}
+	return filepath.Join(SpotPriceHistoryDir, name+".json")
+}
+
+// inRange compares the api timestamps, an empty bound is open.
+func inRange(timestamp, start, end string) bool {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return false
+	}
+	if s, err := time.Parse(time.RFC3339, start); err == nil && t.Before(s) {
+		return false
+	}
+	if e, err := time.Parse(time.RFC3339, end); err == nil && t.After(e) {
+		return false
+	}
+	return true
+}
+
+// NewFakeClient replays the responses recorded for the region.
+func NewFakeClient(dir, region string) *FakeClient {
+	return &FakeClient{Root: dir, Region: region}
+}
+
+// NewRecordingClient records the responses of the region into dir.
+func NewRecordingClient(client *ecsService.Client, dir, region string) *RecordingClient {
+	return &RecordingClient{Client: client, Dir: filepath.Join(dir, region)}
+}
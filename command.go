@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	CommandRank      = "rank"
+	CommandHistory   = "history"
+	CommandTypes     = "types"
+	CommandZones     = "zones"
+	CommandPortfolio = "portfolio"
+	CommandExportAPG = "export-apg"
+	CommandHelp      = "help"
+)
+
+// Command is an operation of the advisor with its own flag set.
+type Command struct {
+	Name string
+	// the positional arguments after the flags, empty when the command takes none
+	Args  string
+	Short string
+	Help  string
+	// the flag groups of the command
+	Flags []func(fs *flag.FlagSet)
+	Run   func(args []string)
+}
+
+// Commands of the advisor in the order of the usage.
+func Commands() []*Command {
+	rankOutput := outputFlags(OutputTable, OutputJSON, OutputCSV, OutputYAML)
+	tableOutput := outputFlags(OutputTable, OutputJSON)
+	return []*Command{
+		{
+			Name:  CommandRank,
+			Short: "Rank the spot pools by price and risk",
+			Help:  "Rank the pools of the filtered instanceTypes in the regions by the rank model.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, rankOutput},
+			Run:   runRank,
+		},
+		{
+			Name:  CommandHistory,
+			Args:  "<instanceType>...",
+			Short: "Print the spot price history of instanceTypes",
+			Help:  "Print the spot price history of the instanceTypes in every zone of the regions over the window,\nwith a sparkline, the min, max and time weighted avg price and every price change.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, windowFlags, forecastFlags, historyFlags, tableOutput},
+			Run:   runHistory,
+		},
+		{
+			Name:  CommandBid,
+			Short: "Recommend a spot price limit for every pool",
+			Help: "Recommend for every ranked pool the lowest SpotPriceLimit that kept the spot price at or below it for -bid-coverage of the window,\n" +
+				"or SpotAsPriceGo when that limit saves little below the on-demand price.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, tableOutput},
+			Run:   runBid,
+		},
+		{
+			Name:  CommandTypes,
+			Short: "List the instanceTypes matching the filter",
+			Help:  "List the instanceTypes of the regions matching the filter with the zones they are offered in.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, tableOutput},
+			Run:   runTypes,
+		},
+		{
+			Name:  CommandZones,
+			Short: "List the zones and the stock of their pools",
+			Help:  "List the zones of the regions with the stock of the pools of the filtered instanceTypes.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, tableOutput},
+			Run:   runZones,
+		},
+		{
+			Name:  CommandPortfolio,
+			Short: "Plan a mix of pools for a target capacity",
+			Help:  "Mix the ranked pools to cover -target-cpu and -target-mem at a low hourly cost.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, portfolioFlags, tableOutput},
+			Run:   runPortfolio,
+		},
+		{
+			Name:  CommandExportAPG,
+			Short: "Print or create an auto provisioning group",
+			Help:  "Build the spec of an auto provisioning group from the top ranked pools of one region, create it with -apg-apply.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, apgFlags},
+			Run:   runExportAPG,
+		},
+		{
+			Name:  CommandBacktest,
+			Short: "Replay the price history through a strategy",
+			Help: "Select the pools with the strategy every -rebalance over the window, each time from the rank of the -lookback before,\n" +
+				"and report the cost, the savings over on-demand, the churn of the pools and the interruptions by the price limit.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, portfolioFlags, backtestFlags, tableOutput},
+			Run:   runBacktest,
+		},
+		{
+			Name:  CommandServe,
+			Short: "Serve the rank over http",
+			Help:  "Keep the regions warm in the background and serve the rank and the prometheus metrics over http.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, serveFlags},
+			Run:   runServer,
+		},
+		{
+			Name:  CommandRecord,
+			Short: "Record the price history into a store",
+			Help:  "Poll the prices of the filtered instanceTypes every -interval and append them to -store.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, daemonFlags},
+			Run:   runRecorder,
+		},
+		{
+			Name:  CommandWatch,
+			Short: "Watch the rank and post alerts",
+			Help:  "Rank again every -interval and post the alerts of -rules to their webhooks.",
+			Flags: []func(fs *flag.FlagSet){configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, daemonFlags, watchFlags},
+			Run:   runWatcher,
+		},
+		{
+			Name:  CommandHelp,
+			Args:  "[command]",
+			Short: "Print the help of a command",
+			Help:  "Print the commands, or the flags of the command.",
+			Run:   runHelp,
+		},
+	}
+}
+
+// FindCommand returns nil when there is no command of the name.
+func FindCommand(name string) *Command {
+	for _, command := range Commands() {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// FlagSet registers the flags of the command on its own set, known holds every flag.
+func (c *Command) FlagSet() (fs *flag.FlagSet, known *flag.FlagSet) {
+	known = knownFlags()
+
+	fs = flag.NewFlagSet(c.Name, flag.ExitOnError)
+	for _, group := range c.Flags {
+		group(fs)
+	}
+	fs.Usage = func() {
+		c.PrintUsage(fs.Output(), fs)
+	}
+	return fs, known
+}
+
+// Execute parses the flags of the command and runs it with the positional arguments.
+func (c *Command) Execute(args []string) {
+	fs, known := c.FlagSet()
+	parseFlags(fs, known, args)
+	if c.Args == "" && fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "Command %s takes no argument but %s is given\n", c.Name, fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+	c.Run(fs.Args())
+}
+
+// PrintUsage writes the synopsis, the help and the flags of the command.
+func (c *Command) PrintUsage(w io.Writer, fs *flag.FlagSet) {
+	synopsis := []string{os.Args[0], c.Name}
+	if len(c.Flags) > 0 {
+		synopsis = append(synopsis, "[flags]")
+	}
+	if c.Args != "" {
+		synopsis = append(synopsis, c.Args)
+	}
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", strings.Join(synopsis, " "), c.Help)
+	if len(c.Flags) > 0 {
+		fmt.Fprintf(w, "\nFlags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+// PrintCommands writes the usage of the advisor and its commands.
+func PrintCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, command := range Commands() {
+		fmt.Fprintf(w, "  %-12s %s\n", command.Name, command.Short)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command, without a command the flags below rank the pools.\n", os.Args[0])
+}
+
+func runHelp(args []string) {
+	if len(args) == 0 {
+		PrintCommands(os.Stdout)
+		return
+	}
+	command := FindCommand(args[0])
+	if command == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", args[0])
+		PrintCommands(os.Stderr)
+		os.Exit(2)
+	}
+	fs, _ := command.FlagSet()
+	command.PrintUsage(os.Stdout, fs)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ConfigProfilesKey = "profiles"
+	ENVConfigPrefix   = "SPOT_ADVISOR_"
+)
+
+// the flags whose value may be written as a map of "key=value" pairs in the config file
+var configMapFlags = map[string]bool{
+	"sort-weights":  true,
+	"apg-vswitches": true,
+}
+
+// Config is a run of the advisor written down in a yaml or json file.
+//
+// Its keys are the names of the flags. The keys under profiles.<name> override the
+// top level keys when the profile is chosen. Lists are joined by comma and the maps of
+// sort-weights and apg-vswitches are written as key=value pairs.
+//
+//	region: [cn-hangzhou, cn-shanghai]
+//	output: json
+//	profiles:
+//	  batch-cpu:
+//	    family: ecs.c6,ecs.c7
+//	    mincpu: 8
+//	    sort: composite
+//	    sort-weights: {core: 0.7, risk: 0.3}
+type Config struct {
+	Path     string
+	Values   map[string]string
+	Profiles map[string]map[string]string
+}
+
+// LoadConfig reads the file, json when it ends with .json or starts with {, the yaml subset of parseYAML otherwise.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if filepath.Ext(path) == ".json" || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %v", path, err)
+		}
+	} else if document, err = parseYAML(string(data)); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+
+	root, ok := document.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config %s: expect a map of settings", path)
+	}
+
+	config := &Config{Path: path, Profiles: make(map[string]map[string]string)}
+	profiles := root[ConfigProfilesKey]
+	delete(root, ConfigProfilesKey)
+	if config.Values, err = configValues(root, ""); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+
+	if profiles != nil {
+		named, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid config %s: %s is not a map of profiles", path, ConfigProfilesKey)
+		}
+		for name, settings := range named {
+			values, ok := settings.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid config %s: profile %s is not a map of settings", path, name)
+			}
+			if config.Profiles[name], err = configValues(values, ConfigProfilesKey+"."+name+"."); err != nil {
+				return nil, fmt.Errorf("invalid config %s: %v", path, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// configValues flattens the values of the settings into flag values.
+func configValues(settings map[string]interface{}, prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if !configMapFlags[key] {
+				return nil, fmt.Errorf("%s%s can not be a map", prefix, key)
+			}
+			pairs := make([]string, 0, len(v))
+			for name, item := range v {
+				s, ok := configScalar(item)
+				if !ok {
+					return nil, fmt.Errorf("%s%s.%s has to be a single value", prefix, key, name)
+				}
+				pairs = append(pairs, name+"="+s)
+			}
+			sort.Strings(pairs)
+			values[key] = strings.Join(pairs, ",")
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for index, item := range v {
+				s, ok := configScalar(item)
+				if !ok {
+					return nil, fmt.Errorf("%s%s[%d] has to be a single value", prefix, key, index)
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			s, ok := configScalar(value)
+			if !ok {
+				return nil, fmt.Errorf("%s%s has an unsupported value", prefix, key)
+			}
+			values[key] = s
+		}
+	}
+	return values, nil
+}
+
+func configScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", true
+	}
+	return "", false
+}
+
+// Apply sets every flag that is not given on the command line, from the environment
+// variable SPOT_ADVISOR_<NAME> first and from the config file and its profile next.
+// Keys that are no flag of known are rejected, so are the config flags themselves,
+// the keys of flags known but not in the set are left for the other commands.
+func (c *Config) Apply(fs *flag.FlagSet, known *flag.FlagSet, profile string, skip ...string) error {
+	values := make(map[string]string)
+	if c != nil {
+		for key, value := range c.Values {
+			values[key] = value
+		}
+		if profile != "" {
+			settings, ok := c.Profiles[profile]
+			if !ok {
+				return fmt.Errorf("profile %s is not found in %s", profile, c.Path)
+			}
+			for key, value := range settings {
+				values[key] = value
+			}
+		}
+		for key := range values {
+			if known.Lookup(key) == nil || containsExact(skip, key) {
+				return fmt.Errorf("unknown key %s in %s", key, c.Path)
+			}
+		}
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || containsExact(skip, f.Name) {
+			return
+		}
+		if value, ok := os.LookupEnv(ConfigEnvName(f.Name)); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q of %s: %v", value, ConfigEnvName(f.Name), e)
+			}
+			return
+		}
+		if value, ok := values[f.Name]; ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q of %s in %s: %v", value, f.Name, c.Path, e)
+			}
+		}
+	})
+	return err
+}
+
+// ConfigEnvName is the environment variable of the flag, sort-weights reads SPOT_ADVISOR_SORT_WEIGHTS.
+func ConfigEnvName(name string) string {
+	return ENVConfigPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// a line of the yaml document without its comment
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// parseYAML reads the subset of yaml a config needs: nested maps by indentation,
+// lists of "- item" lines, inline [a, b] lists, inline {a: 1} maps, quoted and
+// plain scalars and # comments. Duplicated keys and tabs in the indentation are errors.
+func parseYAML(document string) (interface{}, error) {
+	lines := make([]yamlLine, 0)
+	for index, raw := range strings.Split(document, "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \r")
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in the indentation", index+1)
+		}
+		lines = append(lines, yamlLine{number: index + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	value, rest, err := parseYAMLBlock(lines, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected indentation", rest[0].number)
+	}
+	return value, nil
+}
+
+// parseYAMLBlock reads the lines of the indent as a map or a list and returns the lines after it.
+func parseYAMLBlock(lines []yamlLine, indent int) (interface{}, []yamlLine, error) {
+	if strings.HasPrefix(lines[0].text, "- ") || lines[0].text == "-" {
+		list := make([]interface{}, 0)
+		for len(lines) > 0 && lines[0].indent == indent {
+			line := lines[0]
+			if !strings.HasPrefix(line.text, "- ") && line.text != "-" {
+				return nil, nil, fmt.Errorf("line %d: expected a list item", line.number)
+			}
+			value, err := parseYAMLValue(strings.TrimSpace(strings.TrimPrefix(line.text, "-")), line.number)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, value)
+			lines = lines[1:]
+		}
+		return list, lines, nil
+	}
+
+	result := make(map[string]interface{})
+	for len(lines) > 0 && lines[0].indent == indent {
+		line := lines[0]
+		lines = lines[1:]
+		colon := yamlKeyEnd(line.text)
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected key: value", line.number)
+		}
+		key, err := unquoteYAML(strings.TrimSpace(line.text[:colon]), line.number)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := result[key]; ok {
+			return nil, nil, fmt.Errorf("line %d: key %s is given twice", line.number, key)
+		}
+
+		rest := strings.TrimSpace(line.text[colon+1:])
+		if rest != "" {
+			if result[key], err = parseYAMLValue(rest, line.number); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if len(lines) > 0 && lines[0].indent > indent {
+			if result[key], lines, err = parseYAMLBlock(lines, lines[0].indent); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		result[key] = nil
+	}
+	if len(lines) > 0 && lines[0].indent > indent {
+		return nil, nil, fmt.Errorf("line %d: unexpected indentation", lines[0].number)
+	}
+	return result, lines, nil
+}
+
+// parseYAMLValue reads a scalar, an inline list or an inline map.
+func parseYAMLValue(text string, number int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated list", number)
+		}
+		list := make([]interface{}, 0)
+		for _, item := range splitYAMLInline(text[1 : len(text)-1]) {
+			value, err := unquoteYAML(item, number)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("line %d: unterminated map", number)
+		}
+		result := make(map[string]interface{})
+		for _, item := range splitYAMLInline(text[1 : len(text)-1]) {
+			colon := yamlKeyEnd(item)
+			if colon < 0 {
+				return nil, fmt.Errorf("line %d: expected key: value in %s", number, item)
+			}
+			key, err := unquoteYAML(strings.TrimSpace(item[:colon]), number)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := result[key]; ok {
+				return nil, fmt.Errorf("line %d: key %s is given twice", number, key)
+			}
+			if result[key], err = unquoteYAML(strings.TrimSpace(item[colon+1:]), number); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return unquoteYAML(text, number)
+}
+
+// unquoteYAML strips the quotes of a scalar, ~ and null are empty.
+func unquoteYAML(text string, number int) (string, error) {
+	switch {
+	case text == "~" || text == "null":
+		return "", nil
+	case strings.HasPrefix(text, `"`):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return "", fmt.Errorf("line %d: invalid string %s", number, text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("line %d: invalid string %s", number, text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	return text, nil
+}
+
+// yamlKeyEnd is the index of the colon after the key, -1 if there is none.
+func yamlKeyEnd(text string) int {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case opensQuote(text, i):
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// splitYAMLInline splits the items of an inline list or map at the commas outside quotes.
+func splitYAMLInline(text string) []string {
+	items := make([]string, 0)
+	quote := byte(0)
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i == len(text) || quote == 0 && text[i] == ',' {
+			if item := strings.TrimSpace(text[start:i]); item != "" {
+				items = append(items, item)
+			}
+			start = i + 1
+			continue
+		}
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case opensQuote(text, i):
+			quote = c
+		}
+	}
+	return items
+}
+
+// stripYAMLComment drops a # comment that starts the line or follows a space outside quotes.
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case opensQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// opensQuote reports whether a quote at i starts a quoted scalar, the quote in it's does not.
+func opensQuote(text string, i int) bool {
+	if text[i] != '"' && text[i] != '\'' {
+		return false
+	}
+	return i == 0 || strings.IndexByte(" \t:[{,-", text[i-1]) >= 0
+}
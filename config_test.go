@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	cases := []struct {
+		name     string
+		document string
+		want     interface{}
+		err      string
+	}{
+		{"empty", "# nothing\n---\n", map[string]interface{}{}, ""},
+		{"scalars", "region: cn-hangzhou\nmincpu: 8\nresolution: ~\n",
+			map[string]interface{}{"region": "cn-hangzhou", "mincpu": "8", "resolution": ""}, ""},
+		{"nested map", "profiles:\n  batch:\n    mincpu: 8\n  web:\n    maxcpu: 4\n",
+			map[string]interface{}{"profiles": map[string]interface{}{
+				"batch": map[string]interface{}{"mincpu": "8"},
+				"web":   map[string]interface{}{"maxcpu": "4"},
+			}}, ""},
+		{"block list", "region:\n  - cn-hangzhou\n  - 'cn-shanghai'\n",
+			map[string]interface{}{"region": []interface{}{"cn-hangzhou", "cn-shanghai"}}, ""},
+		{"inline list", "region: [cn-hangzhou, \"cn-shanghai\", ]\n",
+			map[string]interface{}{"region": []interface{}{"cn-hangzhou", "cn-shanghai"}}, ""},
+		{"inline map", "sort-weights: {core: 0.7, 'risk': \"0.3\"}\n",
+			map[string]interface{}{"sort-weights": map[string]interface{}{"core": "0.7", "risk": "0.3"}}, ""},
+		{"comments", "# a run\nregion: cn-hangzhou # the region\n  # indented comment\noutput: json\n",
+			map[string]interface{}{"region": "cn-hangzhou", "output": "json"}, ""},
+		{"hash in quotes", "where: \"family == 'ecs.c6' # not a comment\"\nname: 'a#b'\n",
+			map[string]interface{}{"where": "family == 'ecs.c6' # not a comment", "name": "a#b"}, ""},
+		{"hash in a word", "name: a#b\n", map[string]interface{}{"name": "a#b"}, ""},
+		{"colon in quotes", "end: \"2026-10-18T00:00:00Z\"\n\"a: b\": c\n",
+			map[string]interface{}{"end": "2026-10-18T00:00:00Z", "a: b": "c"}, ""},
+		{"colon in a word", "end: 2026-10-18T00:00:00Z\n",
+			map[string]interface{}{"end": "2026-10-18T00:00:00Z"}, ""},
+		{"colon in an inline map", "apg-vswitches: {cn-hangzhou-h: \"vsw:1\"}\n",
+			map[string]interface{}{"apg-vswitches": map[string]interface{}{"cn-hangzhou-h": "vsw:1"}}, ""},
+		{"escaped single quote", "name: 'it''s'\n", map[string]interface{}{"name": "it's"}, ""},
+		{"quote in a word", "name: it's\n", map[string]interface{}{"name": "it's"}, ""},
+
+		{"duplicate key", "region: a\nregion: b\n", nil, "line 2: key region is given twice"},
+		{"duplicate nested key", "profiles:\n  a:\n    x: 1\n    x: 2\n", nil, "line 4: key x is given twice"},
+		{"duplicate inline key", "w: {core: 1, core: 2}\n", nil, "line 1: key core is given twice"},
+		{"tab indentation", "profiles:\n\tbatch: 1\n", nil, "line 2: tabs are not allowed"},
+		{"tab after spaces", "profiles:\n  \tbatch: 1\n", nil, "line 2: tabs are not allowed"},
+		{"no colon", "region\n", nil, "line 1: expected key: value"},
+		{"unexpected indentation", "region: a\n  output: json\n", nil, "line 2: unexpected indentation"},
+		{"list item in a map", "region:\n  - a\n  b: c\n", nil, "line 3: expected a list item"},
+		{"unterminated list", "region: [a, b\n", nil, "line 1: unterminated list"},
+		{"unterminated map", "w: {core: 1\n", nil, "line 1: unterminated map"},
+		{"unterminated string", "name: \"abc\n", nil, "line 1: invalid string"},
+	}
+	for _, c := range cases {
+		got, err := parseYAML(c.document)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+// writeConfig writes the document to a file of the name in a temporary directory.
+func writeConfig(t *testing.T, name string, document string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		file     string
+		document string
+		values   map[string]string
+		profiles map[string]map[string]string
+		err      string
+	}{
+		{"yaml", "advisor.yaml", `
+region: [cn-hangzhou, cn-shanghai]
+output: json # the format
+profiles:
+  batch-cpu:
+    family: [ecs.c6, ecs.c7]
+    sort-weights: {risk: 0.3, core: 0.7}
+`,
+			map[string]string{"region": "cn-hangzhou,cn-shanghai", "output": "json"},
+			map[string]map[string]string{"batch-cpu": {"family": "ecs.c6,ecs.c7", "sort-weights": "core=0.7,risk=0.3"}},
+			""},
+		{"empty profile", "advisor.yaml", "profiles:\n  empty:\n", nil, nil, "profile empty is not a map of settings"},
+		{"block lists", "advisor.yml", `
+region:
+  - cn-hangzhou
+apg-vswitches:
+  cn-hangzhou-h: vsw-1
+`,
+			map[string]string{"region": "cn-hangzhou", "apg-vswitches": "cn-hangzhou-h=vsw-1"},
+			map[string]map[string]string{},
+			""},
+		{"json", "advisor.json", `{"region": ["cn-hangzhou"], "mincpu": 8, "json": true, "profiles": {"a": {"limit": 5}}}`,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "8", "json": "true"},
+			map[string]map[string]string{"a": {"limit": "5"}},
+			""},
+		{"json without extension", "advisor", `{"mincpu": 2.5}`,
+			map[string]string{"mincpu": "2.5"}, map[string]map[string]string{}, ""},
+		{"not a map", "advisor.yaml", "- a\n- b\n", nil, nil, "expect a map of settings"},
+		{"map of a plain flag", "advisor.yaml", "region: {a: b}\n", nil, nil, "region can not be a map"},
+		{"map in a profile", "advisor.yaml", "profiles:\n  a:\n    family: {x: y}\n", nil, nil, "profiles.a.family can not be a map"},
+		{"nested list", "advisor.json", `{"region": [["a"]]}`, nil, nil, "region[0] has to be a single value"},
+		{"profiles not a map", "advisor.yaml", "profiles: [a, b]\n", nil, nil, "profiles is not a map of profiles"},
+		{"duplicate key", "advisor.yaml", "mincpu: 2\nmincpu: 4\n", nil, nil, "key mincpu is given twice"},
+	}
+	for _, c := range cases {
+		config, err := LoadConfig(writeConfig(t, c.file, c.document))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(config.Values, c.values) {
+			t.Errorf("%s: values are %v, want %v", c.name, config.Values, c.values)
+		}
+		if !reflect.DeepEqual(config.Profiles, c.profiles) {
+			t.Errorf("%s: profiles are %v, want %v", c.name, config.Profiles, c.profiles)
+		}
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	config := &Config{
+		Path:   "advisor.yaml",
+		Values: map[string]string{"region": "cn-hangzhou", "mincpu": "2", "output": "table"},
+		Profiles: map[string]map[string]string{
+			"batch":   {"mincpu": "8"},
+			"serving": {"listen": ":8080"},
+			"unknown": {"nosuchflag": "1"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		profile string
+		args    []string
+		env     map[string]string
+		skip    []string
+		want    map[string]string
+		err     string
+	}{
+		{"file", "", nil, nil, nil,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "2", "output": "table"}, ""},
+		{"profile over file", "batch", nil, nil, nil,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "8", "output": "table"}, ""},
+		{"env over profile", "batch", nil, map[string]string{"mincpu": "16"}, nil,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "16", "output": "table"}, ""},
+		{"flag over env", "batch", []string{"-mincpu", "32"}, map[string]string{"mincpu": "16", "output": "json"}, nil,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "32", "output": "json"}, ""},
+		{"env over file", "", nil, map[string]string{"region": "cn-beijing"}, nil,
+			map[string]string{"region": "cn-beijing", "mincpu": "2", "output": "table"}, ""},
+		// listen is a flag of another command
+		{"key of another command", "serving", nil, nil, nil,
+			map[string]string{"region": "cn-hangzhou", "mincpu": "2", "output": "table"}, ""},
+		{"unknown profile", "nosuchprofile", nil, nil, nil, nil, "profile nosuchprofile is not found in advisor.yaml"},
+		{"unknown key", "unknown", nil, nil, nil, nil, "unknown key nosuchflag in advisor.yaml"},
+		{"skipped key", "", nil, nil, []string{"output"}, nil, "unknown key output in advisor.yaml"},
+		{"invalid env", "", nil, map[string]string{"mincpu": "many"}, nil, nil, "invalid value \"many\" of SPOT_ADVISOR_MINCPU"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for name, value := range c.env {
+				t.Setenv(ConfigEnvName(name), value)
+			}
+
+			newFlags := func() *flag.FlagSet {
+				fs := flag.NewFlagSet("advisor", flag.ContinueOnError)
+				fs.String("region", "", "")
+				fs.Int("mincpu", 1, "")
+				fs.String("output", "", "")
+				return fs
+			}
+			fs := newFlags()
+			known := newFlags()
+			known.String("listen", "", "")
+			if err := fs.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := config.Apply(fs, known, c.profile, c.skip...)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("error is %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range c.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigEnvName(t *testing.T) {
+	if name := ConfigEnvName("sort-weights"); name != "SPOT_ADVISOR_SORT_WEIGHTS" {
+		t.Errorf("env name is %s", name)
+	}
+}
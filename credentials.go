@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials/provider"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ENVSecurityToken = "ALIBABA_CLOUD_SECURITY_TOKEN"
+	ENVProfile       = "ALIBABA_CLOUD_PROFILE"
+	ENVRoleArn       = "ALIBABA_CLOUD_ROLE_ARN"
+
+	EcsMetadataURL     = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+	DefaultSessionName = "spot-instance-advisor"
+)
+
+// where the credential of the ecs client comes from
+type CredentialOptions struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	// profile of the aliyun cli config, empty means its current profile
+	Profile    string
+	ConfigPath string
+	// assume this role with the resolved access key through sts
+	RoleArn         string
+	RoleSessionName string
+	// timeout of probing the ecs metadata service
+	MetadataTimeout time.Duration
+	// the ram role listing of the metadata service, EcsMetadataURL when empty
+	MetadataURL string
+}
+
+// a credential and where it was found
+type ResolvedCredential struct {
+	auth.Credential
+	Source      string
+	AccessKeyId string
+}
+
+// a link of the chain, it returns nil without error when it has nothing to offer
+type credentialSource func(opts CredentialOptions) (*ResolvedCredential, error)
+
+// ResolveCredential walks the chain: environment variables, the aliyun cli profile,
+// the command line flags and finally the ram role of the ecs instance, so the flags
+// never wait for the metadata service to time out off ecs.
+// An access key found on the way is exchanged for the RoleArn through sts when it is set.
+func ResolveCredential(opts CredentialOptions) (*ResolvedCredential, error) {
+	if opts.RoleArn == "" {
+		opts.RoleArn = os.Getenv(ENVRoleArn)
+	}
+	if opts.RoleSessionName == "" {
+		opts.RoleSessionName = DefaultSessionName
+	}
+
+	for _, source := range []credentialSource{envCredential, profileCredential, flagCredential, ecsRamRoleCredential} {
+		resolved, err := source(opts)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == nil {
+			continue
+		}
+		if opts.RoleArn != "" {
+			if ak, ok := resolved.Credential.(*credentials.AccessKeyCredential); ok {
+				resolved.Credential = credentials.NewRamRoleArnCredential(ak.AccessKeyId, ak.AccessKeySecret, opts.RoleArn, opts.RoleSessionName, 3600)
+				resolved.Source += " assuming " + opts.RoleArn
+			}
+		}
+		return resolved, nil
+	}
+	return nil, fmt.Errorf("no credential found in environment variables, aliyun cli profile, flags or ecs ram role")
+}
+
+// String names the source and masks the accessKeyId, the secret is never printed.
+func (rc *ResolvedCredential) String() string {
+	if rc.AccessKeyId == "" {
+		return rc.Source
+	}
+	return fmt.Sprintf("%s (accessKeyId %s)", rc.Source, MaskSecret(rc.AccessKeyId))
+}
+
+func envCredential(opts CredentialOptions) (*ResolvedCredential, error) {
+	id, secret := os.Getenv(provider.ENVAccessKeyID), os.Getenv(provider.ENVAccessKeySecret)
+	if id == "" || secret == "" {
+		return nil, nil
+	}
+	resolved := &ResolvedCredential{Source: "environment variables", AccessKeyId: id}
+	if token := os.Getenv(ENVSecurityToken); token != "" {
+		resolved.Credential = credentials.NewStsTokenCredential(id, secret, token)
+	} else {
+		resolved.Credential = credentials.NewAccessKeyCredential(id, secret)
+	}
+	return resolved, nil
+}
+
+// profile of ~/.aliyun/config.json written by the aliyun cli
+type cliProfile struct {
+	Name            string `json:"name"`
+	Mode            string `json:"mode"`
+	AccessKeyId     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	StsToken        string `json:"sts_token"`
+	RamRoleName     string `json:"ram_role_name"`
+	RamRoleArn      string `json:"ram_role_arn"`
+	RamSessionName  string `json:"ram_session_name"`
+}
+
+type cliConfig struct {
+	Current  string       `json:"current"`
+	Profiles []cliProfile `json:"profiles"`
+}
+
+func profileCredential(opts CredentialOptions) (*ResolvedCredential, error) {
+	path := opts.ConfigPath
+	if path == "" {
+		path = filepath.Join(provider.GetHomePath(), ".aliyun", "config.json")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && opts.ConfigPath == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := cliConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid aliyun cli config %s: %v", path, err)
+	}
+
+	name := opts.Profile
+	if name == "" {
+		name = os.Getenv(ENVProfile)
+	}
+	if name == "" {
+		name = config.Current
+	}
+	if name == "" {
+		name = "default"
+	}
+
+	for _, profile := range config.Profiles {
+		if profile.Name != name {
+			continue
+		}
+		resolved := &ResolvedCredential{Source: fmt.Sprintf("aliyun cli profile %s", name), AccessKeyId: profile.AccessKeyId}
+		switch profile.Mode {
+		case "AK", "":
+			resolved.Credential = credentials.NewAccessKeyCredential(profile.AccessKeyId, profile.AccessKeySecret)
+		case "StsToken":
+			resolved.Credential = credentials.NewStsTokenCredential(profile.AccessKeyId, profile.AccessKeySecret, profile.StsToken)
+		case "RamRoleArn":
+			session := profile.RamSessionName
+			if session == "" {
+				session = DefaultSessionName
+			}
+			resolved.Credential = credentials.NewRamRoleArnCredential(profile.AccessKeyId, profile.AccessKeySecret, profile.RamRoleArn, session, 3600)
+			resolved.Source += " assuming " + profile.RamRoleArn
+		case "EcsRamRole":
+			resolved.Credential = credentials.NewEcsRamRoleCredential(profile.RamRoleName)
+			resolved.Source += " with ecs ram role " + profile.RamRoleName
+			resolved.AccessKeyId = ""
+		default:
+			return nil, fmt.Errorf("unsupported mode %s of aliyun cli profile %s", profile.Mode, name)
+		}
+		return resolved, nil
+	}
+
+	// an explicitly chosen profile has to exist
+	if opts.Profile != "" {
+		return nil, fmt.Errorf("profile %s is not found in %s", name, path)
+	}
+	return nil, nil
+}
+
+// ecsRamRoleCredential uses the role named by ALIBABA_CLOUD_ECS_METADATA,
+// or the role attached to the instance when the metadata service answers.
+func ecsRamRoleCredential(opts CredentialOptions) (*ResolvedCredential, error) {
+	roleName := os.Getenv(provider.ENVEcsMetadata)
+	if roleName == "" && opts.MetadataTimeout > 0 {
+		url := opts.MetadataURL
+		if url == "" {
+			url = EcsMetadataURL
+		}
+		client := &http.Client{Timeout: opts.MetadataTimeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, nil
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return nil, nil
+		}
+		roleName = strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0])
+	}
+	if roleName == "" {
+		return nil, nil
+	}
+	return &ResolvedCredential{
+		Credential: credentials.NewEcsRamRoleCredential(roleName),
+		Source:     "ecs ram role " + roleName,
+	}, nil
+}
+
+func flagCredential(opts CredentialOptions) (*ResolvedCredential, error) {
+	if opts.AccessKeyId == "" || opts.AccessKeySecret == "" {
+		return nil, nil
+	}
+	return &ResolvedCredential{
+		Credential:  credentials.NewAccessKeyCredential(opts.AccessKeyId, opts.AccessKeySecret),
+		Source:      "command line flags",
+		AccessKeyId: opts.AccessKeyId,
+	}, nil
+}
+
+// MaskSecret keeps the first and last 3 characters.
+func MaskSecret(value string) string {
+	if len(value) <= 6 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:3] + strings.Repeat("*", len(value)-6) + value[len(value)-3:]
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials/provider"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const credentialsCliConfig = `{
+  "current": "dev",
+  "profiles": [
+    {"name": "dev", "mode": "AK", "access_key_id": "LTAIdevdevdev", "access_key_secret": "dev-secret"},
+    {"name": "sts", "mode": "StsToken", "access_key_id": "STS.stsstssts", "access_key_secret": "sts-secret", "sts_token": "token"},
+    {"name": "role", "mode": "RamRoleArn", "access_key_id": "LTAIroleroler", "access_key_secret": "role-secret", "ram_role_arn": "acs:ram::1:role/cli"},
+    {"name": "instance", "mode": "EcsRamRole", "ram_role_name": "cli-role"},
+    {"name": "broken", "mode": "ChainableRamRoleArn"}
+  ]
+}`
+
+// metadataServer answers the role listing of the ecs metadata service with role and counts the probes.
+func metadataServer(t *testing.T, role string) (*httptest.Server, *int) {
+	t.Helper()
+	probes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		if role == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s\n", role)
+	}))
+	t.Cleanup(server.Close)
+	return server, &probes
+}
+
+func TestResolveCredential(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(configPath, []byte(credentialsCliConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	flags := CredentialOptions{AccessKeyId: "LTAIflagflagf", AccessKeySecret: "flag-secret"}
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		// read the profiles of credentialsCliConfig
+		config bool
+		opts   CredentialOptions
+		role   string
+		source string
+		keyId  string
+		probes int
+		typeOf interface{}
+		err    string
+	}{
+		{"env over everything", map[string]string{provider.ENVAccessKeyID: "LTAIenvenvenv", provider.ENVAccessKeySecret: "env-secret"},
+			true, flags, "instance-role", "environment variables", "LTAIenvenvenv", 0, &credentials.AccessKeyCredential{}, ""},
+		{"env with token", map[string]string{provider.ENVAccessKeyID: "STS.envenvenv", provider.ENVAccessKeySecret: "env-secret", ENVSecurityToken: "token"},
+			false, CredentialOptions{}, "", "environment variables", "STS.envenvenv", 0, &credentials.StsTokenCredential{}, ""},
+		{"current profile over flags", nil, true, flags, "instance-role",
+			"aliyun cli profile dev", "LTAIdevdevdev", 0, &credentials.AccessKeyCredential{}, ""},
+		{"profile of env", map[string]string{ENVProfile: "sts"}, true, CredentialOptions{}, "",
+			"aliyun cli profile sts", "STS.stsstssts", 0, &credentials.StsTokenCredential{}, ""},
+		{"profile of flag over env", map[string]string{ENVProfile: "sts"}, true, CredentialOptions{Profile: "role"}, "",
+			"aliyun cli profile role assuming acs:ram::1:role/cli", "LTAIroleroler", 0, &credentials.RamRoleArnCredential{}, ""},
+		{"profile of ecs ram role", nil, true, CredentialOptions{Profile: "instance"}, "",
+			"aliyun cli profile instance with ecs ram role cli-role", "", 0, &credentials.EcsRamRoleCredential{}, ""},
+		{"missing profile", nil, true, CredentialOptions{Profile: "nosuchprofile"}, "", "", "", 0, nil, "profile nosuchprofile is not found"},
+		{"unsupported mode", nil, true, CredentialOptions{Profile: "broken"}, "", "", "", 0, nil, "unsupported mode ChainableRamRoleArn"},
+		// the metadata service is not asked when the flags are given
+		{"flags before metadata", nil, false, flags, "instance-role",
+			"command line flags", "LTAIflagflagf", 0, &credentials.AccessKeyCredential{}, ""},
+		{"role of env", map[string]string{provider.ENVEcsMetadata: "env-role"}, false, CredentialOptions{}, "instance-role",
+			"ecs ram role env-role", "", 0, &credentials.EcsRamRoleCredential{}, ""},
+		{"role of metadata", nil, false, CredentialOptions{}, "instance-role",
+			"ecs ram role instance-role", "", 1, &credentials.EcsRamRoleCredential{}, ""},
+		{"assume role", map[string]string{ENVRoleArn: "acs:ram::1:role/advisor"}, false, flags, "",
+			"command line flags assuming acs:ram::1:role/advisor", "LTAIflagflagf", 0, &credentials.RamRoleArnCredential{}, ""},
+		{"nothing", nil, false, CredentialOptions{}, "", "", "", 1, nil, "no credential found"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range []string{provider.ENVAccessKeyID, provider.ENVAccessKeySecret, ENVSecurityToken, ENVProfile, ENVRoleArn, provider.ENVEcsMetadata} {
+				t.Setenv(name, c.env[name])
+			}
+			// the default cli config of an empty home is not there
+			t.Setenv("HOME", t.TempDir())
+			if c.config {
+				c.opts.ConfigPath = configPath
+			}
+			server, probes := metadataServer(t, c.role)
+			c.opts.MetadataURL = server.URL
+			c.opts.MetadataTimeout = time.Second
+
+			resolved, err := ResolveCredential(c.opts)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("error is %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved.Source != c.source || resolved.AccessKeyId != c.keyId {
+				t.Errorf("credential of %s with %s, want %s with %s", resolved.Source, resolved.AccessKeyId, c.source, c.keyId)
+			}
+			if got, want := fmt.Sprintf("%T", resolved.Credential), fmt.Sprintf("%T", c.typeOf); got != want {
+				t.Errorf("credential is a %s, want a %s", got, want)
+			}
+			if *probes != c.probes {
+				t.Errorf("metadata service is probed %d times, want %d", *probes, c.probes)
+			}
+		})
+	}
+}
+
+func TestResolveCredentialDefaultProfile(t *testing.T) {
+	for _, name := range []string{provider.ENVAccessKeyID, provider.ENVAccessKeySecret, ENVProfile, ENVRoleArn, provider.ENVEcsMetadata} {
+		t.Setenv(name, "")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// no cli config in the home is no credential, not an error
+	if _, err := ResolveCredential(CredentialOptions{}); err == nil || !strings.Contains(err.Error(), "no credential found") {
+		t.Errorf("error is %v, want no credential found", err)
+	}
+
+	dir := filepath.Join(home, ".aliyun")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(credentialsCliConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := ResolveCredential(CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Source != "aliyun cli profile dev" {
+		t.Errorf("credential of %s, want the current profile of the default config", resolved.Source)
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	cases := map[string]string{
+		"":              "",
+		"abcdef":        "******",
+		"LTAIflagflagf": "LTA*******agf",
+	}
+	for value, want := range cases {
+		if got := MaskSecret(value); got != want {
+			t.Errorf("mask of %q is %q, want %q", value, got, want)
+		}
+	}
+	resolved := &ResolvedCredential{Source: "command line flags", AccessKeyId: "LTAIflagflagf"}
+	if s := resolved.String(); strings.Contains(s, "LTAIflagflagf") {
+		t.Errorf("%s prints the access key id", s)
+	}
+}
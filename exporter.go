@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a gauge of every pool
+type poolGauge struct {
+	name  string
+	help  string
+	value func(price InstancePrice) float64
+}
+
+var poolGauges = []poolGauge{
+	{"spot_instance_price", "Latest spot price per hour", func(price InstancePrice) float64 { return price.SpotPrice }},
+	{"spot_instance_origin_price", "On-demand price per hour", func(price InstancePrice) float64 { return price.OriginPrice }},
+	{"spot_instance_discount", "Spot price over on-demand price, 1 means 10% of the on-demand price", func(price InstancePrice) float64 { return price.Discount }},
+	{"spot_instance_price_per_core", "Latest spot price per vCPU per hour", func(price InstancePrice) float64 { return price.PricePerCore }},
+	{"spot_instance_price_per_memory", "Latest spot price per GiB of memory per hour", func(price InstancePrice) float64 { return price.PricePerMemory }},
+	{"spot_instance_volatility", "Coefficient of variation of the spot price over the window", func(price InstancePrice) float64 { return price.Stats.CV }},
+	{"spot_instance_forecast_price", "Spot price per hour forecast at the horizon", func(price InstancePrice) float64 { return price.Forecast.Price }},
+	{"spot_instance_hourly_cost", "Spot price plus the disks and bandwidth of a node per hour, 0 without -tco", func(price InstancePrice) float64 { return price.Cost.Hourly }},
+}
+
+// Exporter publishes the analyzed pools in the prometheus text format.
+//
+// The prices are replaced per region by Update from the refresh loop, a scrape
+// only reads the latest snapshot and never calls the api.
+type Exporter struct {
+	lock    sync.RWMutex
+	prices  map[string]SortedInstancePrices
+	updated map[string]time.Time
+}
+
+// Update replaces the snapshot of the region.
+func (e *Exporter) Update(region string, prices SortedInstancePrices) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.prices == nil {
+		e.prices = make(map[string]SortedInstancePrices)
+		e.updated = make(map[string]time.Time)
+	}
+	e.prices[region] = prices
+	e.updated[region] = time.Now()
+}
+
+// WriteMetrics writes every gauge of every pool, ordered by region, zone and instanceType.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	e.lock.RLock()
+	regions := make([]string, 0, len(e.prices))
+	pools := make([]InstancePrice, 0)
+	for region, prices := range e.prices {
+		regions = append(regions, region)
+		pools = append(pools, prices...)
+	}
+	updated := make(map[string]time.Time, len(e.updated))
+	for region, t := range e.updated {
+		updated[region] = t
+	}
+	e.lock.RUnlock()
+
+	sort.Strings(regions)
+	sort.SliceStable(pools, func(i, j int) bool {
+		a, b := pools[i], pools[j]
+		if a.RegionId != b.RegionId {
+			return a.RegionId < b.RegionId
+		}
+		if a.ZoneId != b.ZoneId {
+			return a.ZoneId < b.ZoneId
+		}
+		return a.InstanceTypeId < b.InstanceTypeId
+	})
+
+	lines := make([]string, 0)
+	for _, gauge := range poolGauges {
+		lines = append(lines, fmt.Sprintf("# HELP %s %s", gauge.name, gauge.help), fmt.Sprintf("# TYPE %s gauge", gauge.name))
+		for _, price := range pools {
+			labels := metricLabels("region", price.RegionId, "zone", price.ZoneId, "instance_type", price.InstanceTypeId, "family", price.InstanceTypeFamily)
+			lines = append(lines, gauge.name+labels+" "+formatMetric(gauge.value(price)))
+		}
+	}
+
+	name := "spot_instance_advisor_last_refresh_timestamp_seconds"
+	lines = append(lines, "# HELP "+name+" Unix time of the last refresh of the region", "# TYPE "+name+" gauge")
+	for _, region := range regions {
+		lines = append(lines, name+metricLabels("region", region)+" "+strconv.FormatInt(updated[region].Unix(), 10))
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteMetrics(w)
+}
+
+// metricLabels formats name and value pairs, escaping the values.
+func metricLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
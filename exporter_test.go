@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExporterWriteMetrics(t *testing.T) {
+	exporter := &Exporter{}
+	pool := func(region, zone, instanceType string, price float64) InstancePrice {
+		return InstancePrice{
+			InstanceType: ecsService.InstanceType{InstanceTypeId: instanceType, InstanceTypeFamily: "ecs.c6", CpuCoreCount: 2, MemorySize: 4},
+			RegionId:     region,
+			ZoneId:       zone,
+			SpotPrice:    price,
+			OriginPrice:  0.39,
+			PricePerCore: price / 2,
+		}
+	}
+	exporter.Update("cn-shanghai", SortedInstancePrices{pool("cn-shanghai", "cn-shanghai-b", "ecs.c6.large", 0.05)})
+	exporter.Update("cn-hangzhou", SortedInstancePrices{
+		pool("cn-hangzhou", "cn-hangzhou-i", "ecs.c6.large", 0.045),
+		pool("cn-hangzhou", "cn-hangzhou-h", "ecs.c6.large", 0.0429),
+	})
+	// an update replaces the snapshot of the region
+	exporter.Update("cn-shanghai", SortedInstancePrices{pool("cn-shanghai", "cn-shanghai-b", "ecs.c6.large", 0.06)})
+
+	buf := &bytes.Buffer{}
+	if err := exporter.WriteMetrics(buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+
+	want := strings.Join([]string{
+		"# HELP spot_instance_price Latest spot price per hour",
+		"# TYPE spot_instance_price gauge",
+		`spot_instance_price{region="cn-hangzhou",zone="cn-hangzhou-h",instance_type="ecs.c6.large",family="ecs.c6"} 0.0429`,
+		`spot_instance_price{region="cn-hangzhou",zone="cn-hangzhou-i",instance_type="ecs.c6.large",family="ecs.c6"} 0.045`,
+		`spot_instance_price{region="cn-shanghai",zone="cn-shanghai-b",instance_type="ecs.c6.large",family="ecs.c6"} 0.06`,
+		"# HELP spot_instance_origin_price On-demand price per hour",
+	}, "\n")
+	if !strings.HasPrefix(text, want) {
+		t.Errorf("metrics begin with\n%s\nwant\n%s", text[:len(want)], want)
+	}
+	for _, gauge := range poolGauges {
+		if n := strings.Count(text, "\n"+gauge.name+"{"); n != 3 {
+			t.Errorf("%d samples of %s, want one of every pool", n, gauge.name)
+		}
+	}
+	if !strings.Contains(text, `spot_instance_price_per_core{region="cn-hangzhou",zone="cn-hangzhou-h",instance_type="ecs.c6.large",family="ecs.c6"} 0.02145`) {
+		t.Errorf("no price per core of cn-hangzhou-h in\n%s", text)
+	}
+	for _, region := range []string{"cn-hangzhou", "cn-shanghai"} {
+		if !strings.Contains(text, `spot_instance_advisor_last_refresh_timestamp_seconds{region="`+region+`"} `) {
+			t.Errorf("no refresh time of %s", region)
+		}
+	}
+	if !strings.HasSuffix(text, "\n") {
+		t.Errorf("metrics do not end with a new line")
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	got := metricLabels("zone", `a"b\c`+"\nd", "odd")
+	if want := `{zone="a\"b\\c\nd"}`; got != want {
+		t.Errorf("labels are %s, want %s", got, want)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s := newReplayServer(t, "cn-hangzhou")
+	s.Exporter = &Exporter{}
+	load(s)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("content type of the metrics is %s", resp.Header.Get("Content-Type"))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	// the refresh exports the rank of the defaults
+	if !strings.Contains(text, `spot_instance_price{region="cn-hangzhou",zone="cn-hangzhou-h",instance_type="ecs.c6.large",family="ecs.c6"} `) {
+		t.Errorf("no price of ecs.c6.large in\n%s", text)
+	}
+	if !strings.Contains(text, `spot_instance_advisor_last_refresh_timestamp_seconds{region="cn-hangzhou"}`) {
+		t.Errorf("no refresh time in\n%s", text)
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// type of an expression node
+type exprType int
+
+const (
+	typeNumber exprType = iota
+	typeString
+	typeBool
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	}
+	return "bool"
+}
+
+// a field of the record the expression is evaluated against
+type exprField struct {
+	typ exprType
+	// the field only depends on the instanceType, not on the prices of the pool
+	static bool
+	num    func(price InstancePrice) float64
+	str    func(price InstancePrice) string
+	bool   func(price InstancePrice) bool
+}
+
+func numField(static bool, f func(price InstancePrice) float64) exprField {
+	return exprField{typ: typeNumber, static: static, num: f}
+}
+
+func strField(static bool, f func(price InstancePrice) string) exprField {
+	return exprField{typ: typeString, static: static, str: f}
+}
+
+var exprFields = map[string]exprField{
+	"type":       strField(true, func(p InstancePrice) string { return p.InstanceTypeId }),
+	"family":     strField(true, func(p InstancePrice) string { return p.InstanceTypeFamily }),
+	"generation": strField(true, func(p InstancePrice) string { return p.Generation }),
+	"level":      strField(true, func(p InstancePrice) string { return p.InstanceFamilyLevel }),
+	"gpuspec":    strField(true, func(p InstancePrice) string { return p.GPUSpec }),
+	"cpu":        numField(true, func(p InstancePrice) float64 { return float64(p.CpuCoreCount) }),
+	"mem":        numField(true, func(p InstancePrice) float64 { return p.MemorySize }),
+	"gpu":        numField(true, func(p InstancePrice) float64 { return float64(p.GPUAmount) }),
+	"eni":        numField(true, func(p InstancePrice) float64 { return float64(p.EniQuantity) }),
+	"disks":      numField(true, func(p InstancePrice) float64 { return float64(p.LocalStorageAmount) }),
+	"bandwidth": numField(true, func(p InstancePrice) float64 {
+		return float64(minInt(p.InstanceBandwidthRx, p.InstanceBandwidthTx)) / 1024
+	}),
+	"pps":        numField(true, func(p InstancePrice) float64 { return float64(minInt64(p.InstancePpsRx, p.InstancePpsTx)) }),
+	"region":     strField(false, func(p InstancePrice) string { return p.RegionId }),
+	"zone":       strField(false, func(p InstancePrice) string { return p.ZoneId }),
+	"stock":      strField(false, func(p InstancePrice) string { return p.Stock }),
+	"price":      numField(false, func(p InstancePrice) float64 { return p.SpotPrice }),
+	"origin":     numField(false, func(p InstancePrice) float64 { return p.OriginPrice }),
+	"discount":   numField(false, func(p InstancePrice) float64 { return p.Discount }),
+	"percore":    numField(false, func(p InstancePrice) float64 { return p.PricePerCore }),
+	"permem":     numField(false, func(p InstancePrice) float64 { return p.PricePerMemory }),
+	"risk":       numField(false, func(p InstancePrice) float64 { return p.Risk }),
+	"mean":       numField(false, func(p InstancePrice) float64 { return p.Stats.Mean }),
+	"stddev":     numField(false, func(p InstancePrice) float64 { return p.Stats.StdDev }),
+	"cv":         numField(false, func(p InstancePrice) float64 { return p.Stats.CV }),
+	"spike":      numField(false, func(p InstancePrice) float64 { return p.Stats.MaxSpike }),
+	"changes":    numField(false, func(p InstancePrice) float64 { return p.Stats.ChangesPerDay }),
+	"above":      numField(false, func(p InstancePrice) float64 { return p.Stats.TimeAboveThreshold }),
+	"forecast":   numField(false, func(p InstancePrice) float64 { return p.Forecast.Price }),
+	"limit":      numField(false, func(p InstancePrice) float64 { return p.Bid.SpotPriceLimit }),
+	"hourly":     numField(false, func(p InstancePrice) float64 { return p.Cost.Hourly }),
+	"monthly":    numField(false, func(p InstancePrice) float64 { return p.Cost.Monthly }),
+	"launchable": {typ: typeBool, bool: func(p InstancePrice) bool { return p.Launchable() }},
+}
+
+// ExprFields lists the field names of the expressions.
+func ExprFields() []string {
+	names := make([]string, 0, len(exprFields))
+	for name := range exprFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExprError points at the token of the expression that could not be parsed.
+type ExprError struct {
+	Expr    string
+	Pos     int
+	Message string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s at column %d\n\t%s\n\t%s^", e.Message, e.Pos+1, e.Expr, strings.Repeat(" ", e.Pos))
+}
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexExpr splits the expression into numbers, double quoted strings, identifiers and operators.
+func lexExpr(expr string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokenNumber, string(runes[i:j]), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokenIdent, string(runes[i:j]), i})
+			i = j
+		case r == '"':
+			value := make([]rune, 0)
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				value = append(value, runes[j])
+			}
+			if j >= len(runes) {
+				return nil, &ExprError{expr, i, "unterminated string"}
+			}
+			tokens = append(tokens, exprToken{tokenString, string(value), i})
+			i = j + 1
+		default:
+			op := ""
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if op == "" && strings.ContainsRune("!<>+-*/(),", r) {
+				op = string(r)
+			}
+			if op == "" {
+				return nil, &ExprError{expr, i, fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, exprToken{tokenOp, op, i})
+			i += len([]rune(op))
+		}
+	}
+	return append(tokens, exprToken{tokenEOF, "", len(runes)}), nil
+}
+
+// a compiled node, only the function of its type is set
+type exprNode struct {
+	typ    exprType
+	pos    int
+	static bool
+	num    func(price InstancePrice) float64
+	str    func(price InstancePrice) string
+	bool   func(price InstancePrice) bool
+	// false only when the bool is false whatever the prices are, see Expr.MayMatch
+	pre func(price InstancePrice) bool
+}
+
+// prefilter of a bool node, the static part of && and || is kept
+func (node exprNode) prefilter() func(price InstancePrice) bool {
+	switch {
+	case node.pre != nil:
+		return node.pre
+	case node.static:
+		return node.bool
+	}
+	return func(InstancePrice) bool { return true }
+}
+
+// Expr is a compiled -where expression.
+//
+//	cpu >= 4 && mem/cpu == 4 && family in ("ecs.g6","ecs.g7") && discount < 3 && zone != "cn-hangzhou-b"
+//
+// It has the operators || && ! == != < <= > >= + - * / in and not in, numbers,
+// double quoted strings, true, false and the fields of ExprFields.
+type Expr struct {
+	Source string
+	root   exprNode
+}
+
+// Match evaluates the expression against the pool.
+func (e *Expr) Match(price InstancePrice) bool {
+	return e.root.bool(price)
+}
+
+// MayMatch evaluates the parts of the expression that only depend on the
+// instanceType, so instanceTypes can be dropped before their prices are fetched.
+func (e *Expr) MayMatch(instanceType ecsService.InstanceType) bool {
+	return e.root.prefilter()(InstancePrice{InstanceType: instanceType})
+}
+
+// ParseExpr compiles the expression, it has to be a bool.
+func ParseExpr(source string) (*Expr, error) {
+	tokens, err := lexExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{source: source, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.errorf(next.pos, "unexpected %s", next)
+	}
+	if root.typ != typeBool {
+		return nil, p.errorf(root.pos, "expression is a %s, not a condition", root.typ)
+	}
+	return &Expr{Source: source, root: root}, nil
+}
+
+type exprParser struct {
+	source string
+	tokens []exprToken
+	next   int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() exprToken {
+	token := p.tokens[p.next]
+	if token.kind != tokenEOF {
+		p.next++
+	}
+	return token
+}
+
+func (p *exprParser) isOp(ops ...string) bool {
+	token := p.peek()
+	if token.kind != tokenOp {
+		return false
+	}
+	for _, op := range ops {
+		if token.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ExprError{p.source, pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) expect(node exprNode, typ exprType, op string) error {
+	if node.typ != typ {
+		return p.errorf(node.pos, "%s needs a %s, got a %s", op, typ, node.typ)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("||") {
+		op := p.take()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		if err := p.expect(left, typeBool, op.text); err != nil {
+			return left, err
+		}
+		if err := p.expect(right, typeBool, op.text); err != nil {
+			return right, err
+		}
+		l, r := left.bool, right.bool
+		lp, rp := left.prefilter(), right.prefilter()
+		left = exprNode{typ: typeBool, pos: left.pos, static: left.static && right.static,
+			bool: func(price InstancePrice) bool { return l(price) || r(price) },
+			pre:  func(price InstancePrice) bool { return lp(price) || rp(price) }}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("&&") {
+		op := p.take()
+		right, err := p.parseCompare()
+		if err != nil {
+			return right, err
+		}
+		if err := p.expect(left, typeBool, op.text); err != nil {
+			return left, err
+		}
+		if err := p.expect(right, typeBool, op.text); err != nil {
+			return right, err
+		}
+		l, r := left.bool, right.bool
+		lp, rp := left.prefilter(), right.prefilter()
+		left = exprNode{typ: typeBool, pos: left.pos, static: left.static && right.static,
+			bool: func(price InstancePrice) bool { return l(price) && r(price) },
+			pre:  func(price InstancePrice) bool { return lp(price) && rp(price) }}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return left, err
+	}
+
+	token := p.peek()
+	switch {
+	case p.isOp("==", "!=", "<", "<=", ">", ">="):
+		op := p.take()
+		right, err := p.parseAdd()
+		if err != nil {
+			return right, err
+		}
+		return p.compare(op, left, right)
+	case token.kind == tokenIdent && (token.text == "in" || token.text == "not"):
+		p.take()
+		negate := token.text == "not"
+		if negate {
+			if in := p.take(); in.kind != tokenIdent || in.text != "in" {
+				return left, p.errorf(in.pos, "expected in after not, got %s", in)
+			}
+		}
+		return p.parseIn(left, negate)
+	}
+	return left, nil
+}
+
+func (p *exprParser) compare(op exprToken, left, right exprNode) (exprNode, error) {
+	if left.typ != right.typ {
+		return right, p.errorf(right.pos, "can not compare a %s with a %s", left.typ, right.typ)
+	}
+	node := exprNode{typ: typeBool, pos: left.pos, static: left.static && right.static}
+	switch left.typ {
+	case typeNumber:
+		l, r := left.num, right.num
+		cmp := map[string]func(a, b float64) bool{
+			"==": func(a, b float64) bool { return a == b },
+			"!=": func(a, b float64) bool { return a != b },
+			"<":  func(a, b float64) bool { return a < b },
+			"<=": func(a, b float64) bool { return a <= b },
+			">":  func(a, b float64) bool { return a > b },
+			">=": func(a, b float64) bool { return a >= b },
+		}[op.text]
+		node.bool = func(price InstancePrice) bool { return cmp(l(price), r(price)) }
+	case typeString:
+		l, r := left.str, right.str
+		switch op.text {
+		case "==":
+			node.bool = func(price InstancePrice) bool { return l(price) == r(price) }
+		case "!=":
+			node.bool = func(price InstancePrice) bool { return l(price) != r(price) }
+		default:
+			return left, p.errorf(op.pos, "%s does not apply to strings", op.text)
+		}
+	case typeBool:
+		l, r := left.bool, right.bool
+		switch op.text {
+		case "==":
+			node.bool = func(price InstancePrice) bool { return l(price) == r(price) }
+		case "!=":
+			node.bool = func(price InstancePrice) bool { return l(price) != r(price) }
+		default:
+			return left, p.errorf(op.pos, "%s does not apply to bools", op.text)
+		}
+	}
+	return node, nil
+}
+
+// parseIn reads the parenthesized list after in, every item is compared with ==.
+func (p *exprParser) parseIn(left exprNode, negate bool) (exprNode, error) {
+	if open := p.take(); open.kind != tokenOp || open.text != "(" {
+		return left, p.errorf(open.pos, "expected ( after in, got %s", open)
+	}
+	matchers := make([]func(price InstancePrice) bool, 0)
+	static := left.static
+	for {
+		item, err := p.parseAdd()
+		if err != nil {
+			return item, err
+		}
+		eq, err := p.compare(exprToken{tokenOp, "==", item.pos}, left, item)
+		if err != nil {
+			return item, err
+		}
+		matchers = append(matchers, eq.bool)
+		static = static && item.static
+
+		token := p.take()
+		if token.kind == tokenOp && token.text == ")" {
+			break
+		}
+		if token.kind != tokenOp || token.text != "," {
+			return left, p.errorf(token.pos, "expected , or ) in the list, got %s", token)
+		}
+	}
+	return exprNode{typ: typeBool, pos: left.pos, static: static, bool: func(price InstancePrice) bool {
+		for _, match := range matchers {
+			if match(price) {
+				return !negate
+			}
+		}
+		return negate
+	}}, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("+", "-") {
+		op := p.take()
+		right, err := p.parseMul()
+		if err != nil {
+			return right, err
+		}
+		if left, err = p.arithmetic(op, left, right); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("*", "/") {
+		op := p.take()
+		right, err := p.parseUnary()
+		if err != nil {
+			return right, err
+		}
+		if left, err = p.arithmetic(op, left, right); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) arithmetic(op exprToken, left, right exprNode) (exprNode, error) {
+	if err := p.expect(left, typeNumber, op.text); err != nil {
+		return left, err
+	}
+	if err := p.expect(right, typeNumber, op.text); err != nil {
+		return right, err
+	}
+	l, r := left.num, right.num
+	node := exprNode{typ: typeNumber, pos: left.pos, static: left.static && right.static}
+	switch op.text {
+	case "+":
+		node.num = func(price InstancePrice) float64 { return l(price) + r(price) }
+	case "-":
+		node.num = func(price InstancePrice) float64 { return l(price) - r(price) }
+	case "*":
+		node.num = func(price InstancePrice) float64 { return l(price) * r(price) }
+	case "/":
+		node.num = func(price InstancePrice) float64 { return l(price) / r(price) }
+	}
+	return node, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if !p.isOp("!", "-") {
+		return p.parsePrimary()
+	}
+	op := p.take()
+	operand, err := p.parseUnary()
+	if err != nil {
+		return operand, err
+	}
+	if op.text == "!" {
+		if err := p.expect(operand, typeBool, op.text); err != nil {
+			return operand, err
+		}
+		f := operand.bool
+		return exprNode{typ: typeBool, pos: op.pos, static: operand.static, bool: func(price InstancePrice) bool { return !f(price) }}, nil
+	}
+	if err := p.expect(operand, typeNumber, op.text); err != nil {
+		return operand, err
+	}
+	f := operand.num
+	return exprNode{typ: typeNumber, pos: op.pos, static: operand.static, num: func(price InstancePrice) float64 { return -f(price) }}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.take()
+	switch token.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return exprNode{}, p.errorf(token.pos, "invalid number %s", token)
+		}
+		return exprNode{typ: typeNumber, pos: token.pos, static: true, num: func(InstancePrice) float64 { return value }}, nil
+	case tokenString:
+		value := token.text
+		return exprNode{typ: typeString, pos: token.pos, static: true, str: func(InstancePrice) string { return value }}, nil
+	case tokenIdent:
+		switch token.text {
+		case "true", "false":
+			value := token.text == "true"
+			return exprNode{typ: typeBool, pos: token.pos, static: true, bool: func(InstancePrice) bool { return value }}, nil
+		}
+		field, ok := exprFields[token.text]
+		if !ok {
+			return exprNode{}, p.errorf(token.pos, "unknown field %q, use one of %s", token.text, strings.Join(ExprFields(), ","))
+		}
+		return exprNode{typ: field.typ, pos: token.pos, static: field.static, num: field.num, str: field.str, bool: field.bool}, nil
+	case tokenOp:
+		if token.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return node, err
+			}
+			if closing := p.take(); closing.kind != tokenOp || closing.text != ")" {
+				return node, p.errorf(closing.pos, "expected ), got %s", closing)
+			}
+			return node, nil
+		}
+		return exprNode{}, p.errorf(token.pos, "unexpected %s", token)
+	}
+	return exprNode{}, p.errorf(token.pos, "unexpected %s", token)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strings"
+	"testing"
+)
+
+// pool of the instanceType at the spot price
+func exprPool(instanceType string, family string, cpu int, mem float64, zone string, spotPrice float64) InstancePrice {
+	return InstancePrice{
+		InstanceType: ecsService.InstanceType{
+			InstanceTypeId:     instanceType,
+			InstanceTypeFamily: family,
+			CpuCoreCount:       cpu,
+			MemorySize:         mem,
+		},
+		RegionId:  "cn-hangzhou",
+		ZoneId:    zone,
+		Stock:     StockWithStock,
+		SpotPrice: spotPrice,
+		Discount:  spotPrice * 10,
+	}
+}
+
+var exprPools = []InstancePrice{
+	exprPool("ecs.c6.large", "ecs.c6", 2, 4, "cn-hangzhou-h", 0.05),
+	exprPool("ecs.c6.2xlarge", "ecs.c6", 8, 16, "cn-hangzhou-i", 0.3),
+	exprPool("ecs.g6.xlarge", "ecs.g6", 4, 16, "cn-hangzhou-h", 0.12),
+	exprPool("ecs.r6.large", "ecs.r6", 2, 16, "cn-hangzhou-b", 0.09),
+}
+
+func TestExprMatch(t *testing.T) {
+	pool := exprPools[2]
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		// precedence
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"12 / 2 / 3 == 2", true},
+		{"-2 * 3 == -6", true},
+		{"- -2 == 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"false && false || true", true},
+		{"(1 < 2) == true", true},
+		{"mem / cpu == 4 && cpu >= 4", true},
+		{"cpu * 2 > mem - 10", true},
+
+		// fields and comparisons
+		{`family == "ecs.g6"`, true},
+		{`zone != "cn-hangzhou-h"`, false},
+		{"price <= 0.12 && discount < 1.5", true},
+		{"launchable", true},
+		{"launchable == false", false},
+		{`type == "ecs.g6.xlarge" && .5 < 1`, true},
+		{`"a \"quoted\" b" == "a \"quoted\" b"`, true},
+
+		// in and not in
+		{`family in ("ecs.c6", "ecs.g6")`, true},
+		{`family in ("ecs.c6")`, false},
+		{`family not in ("ecs.c6", "ecs.r6")`, true},
+		{`family not in ("ecs.g6")`, false},
+		{"cpu in (2, 2 + 2)", true},
+		{"cpu not in (1, 2, 8)", true},
+		{`!(family in ("ecs.g6")) || cpu == 4`, true},
+		{`zone in ("cn-hangzhou-b") || family in ("ecs.g6") && cpu > 2`, true},
+	}
+	for _, c := range cases {
+		expr, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got := expr.Match(pool); got != c.want {
+			t.Errorf("%s is %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	cases := []struct {
+		expr    string
+		pos     int
+		message string
+	}{
+		// type errors
+		{"cpu", 0, "expression is a number, not a condition"},
+		{`family`, 0, "expression is a string, not a condition"},
+		{`cpu == "4"`, 7, "can not compare a number with a string"},
+		{`family < "ecs.g6"`, 7, "< does not apply to strings"},
+		{"launchable > true", 11, "> does not apply to bools"},
+		{`cpu + "a" > 1`, 6, "+ needs a number, got a string"},
+		{`-family == "x"`, 1, "- needs a number, got a string"},
+		{"!cpu", 1, "! needs a bool, got a number"},
+		{"cpu >= 4 && mem", 12, "&& needs a bool, got a number"},
+		{"mem || cpu > 1", 0, "|| needs a bool, got a number"},
+		{`cpu in (2, "4")`, 11, "can not compare a number with a string"},
+
+		// syntax errors
+		{"gpus > 0", 0, `unknown field "gpus"`},
+		{"cpu >= 4 &&", 11, "unexpected end of expression"},
+		{"cpu >= 4 & mem > 1", 9, "unexpected character '&'"},
+		{`family == "ecs.g6`, 10, "unterminated string"},
+		{"(cpu > 1", 8, "expected ), got end of expression"},
+		{"cpu > 1)", 7, `unexpected ")"`},
+		{"family not (1)", 11, `expected in after not, got "("`},
+		{`family in "ecs.g6"`, 10, `expected ( after in, got "ecs.g6"`},
+		{`family in ("a" "b")`, 15, `expected , or ) in the list, got "b"`},
+		{"cpu > 1.2.3", 6, `invalid number "1.2.3"`},
+		// comparisons do not chain
+		{"1 < 2 == true", 6, `unexpected "=="`},
+	}
+	for _, c := range cases {
+		_, err := ParseExpr(c.expr)
+		if err == nil {
+			t.Errorf("%s: no error, want %q", c.expr, c.message)
+			continue
+		}
+		exprErr, ok := err.(*ExprError)
+		if !ok {
+			t.Errorf("%s: error %v is not an ExprError", c.expr, err)
+			continue
+		}
+		if exprErr.Pos != c.pos || !strings.HasPrefix(exprErr.Message, c.message) {
+			t.Errorf("%s: error %q at %d, want %q at %d", c.expr, exprErr.Message, exprErr.Pos, c.message, c.pos)
+		}
+	}
+}
+
+func TestExprErrorCaret(t *testing.T) {
+	_, err := ParseExpr(`cpu >= 4 && family < "ecs.g6"`)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	want := "< does not apply to strings at column 20\n\tcpu >= 4 && family < \"ecs.g6\"\n\t                   ^"
+	if err.Error() != want {
+		t.Errorf("error is\n%s\nwant\n%s", err, want)
+	}
+}
+
+func TestExprMayMatch(t *testing.T) {
+	cases := []struct {
+		expr string
+		// MayMatch of every pool of exprPools
+		want []bool
+	}{
+		// static expressions are decided by the instanceType
+		{"cpu >= 4", []bool{false, true, true, false}},
+		{`family in ("ecs.c6")`, []bool{true, true, false, false}},
+		{`family not in ("ecs.c6")`, []bool{false, false, true, true}},
+		{"!(cpu >= 4)", []bool{true, false, false, true}},
+		// prices are not known yet
+		{"price < 0.1", []bool{true, true, true, true}},
+		{"launchable", []bool{true, true, true, true}},
+		// the static part of && drops the instanceType
+		{"cpu >= 4 && price < 0.1", []bool{false, true, true, false}},
+		{"price < 0.1 && mem == 16", []bool{false, true, true, true}},
+		// either side of || may match
+		{"cpu >= 4 || price < 0.1", []bool{true, true, true, true}},
+		{`cpu >= 8 || family == "ecs.r6"`, []bool{false, true, false, true}},
+		{"(cpu >= 8 && price < 1) || (cpu == 2 && price < 0.06)", []bool{true, true, false, true}},
+		// ! of a dynamic condition can not drop anything
+		{"!(cpu >= 4 && price < 0.1)", []bool{true, true, true, true}},
+		{"!(cpu >= 4 || price < 0.1)", []bool{true, true, true, true}},
+		{"!!(cpu >= 4 && price < 0.1)", []bool{true, true, true, true}},
+		{"(cpu >= 4 && price < 0.1) == false", []bool{true, true, true, true}},
+		{`zone in ("cn-hangzhou-h") && !(family in ("ecs.g6"))`, []bool{true, true, false, true}},
+		{`!(zone in ("cn-hangzhou-h")) || cpu > 100`, []bool{true, true, true, true}},
+		{"cpu in (2, price * 20)", []bool{true, true, true, true}},
+	}
+	for _, c := range cases {
+		expr, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		for i, pool := range exprPools {
+			may := expr.MayMatch(pool.InstanceType)
+			if may != c.want[i] {
+				t.Errorf("%s: MayMatch of %s is %v, want %v", c.expr, pool.InstanceTypeId, may, c.want[i])
+			}
+			// the prefilter must never drop a pool the expression matches
+			if expr.Match(pool) && !may {
+				t.Errorf("%s: %s in %s matches but is dropped by MayMatch", c.expr, pool.InstanceTypeId, pool.ZoneId)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	sdkErrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MaxBackoff = 10 * time.Second
+)
+
+// options of the concurrent price fetching
+type FetchOptions struct {
+	Concurrency int
+	QPS         float64
+	Retries     int
+	Backoff     time.Duration
+}
+
+// outcome of fetching one instanceType, Attempts counts every request of every page
+type FetchResult struct {
+	InstanceType string
+	Attempts     int
+	Err          error
+}
+
+// rateLimiter hands out one token every 1/qps seconds, it never blocks when qps <= 0.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(qps float64) *rateLimiter {
+	if qps <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / qps))}
+}
+
+func (rl *rateLimiter) Wait() {
+	if rl.ticker != nil {
+		<-rl.ticker.C
+	}
+}
+
+func (rl *rateLimiter) Stop() {
+	if rl.ticker != nil {
+		rl.ticker.Stop()
+	}
+}
+
+// Caller performs one api request under the rate limit and retries it
+// when it fails with a throttling or transient error.
+type Caller func(request func() error) error
+
+// Run fetch for every instanceType with a bounded pool of workers.
+func (opts FetchOptions) Run(instanceTypes []string, fetch func(instanceType string, call Caller) error) []FetchResult {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	limiter := newRateLimiter(opts.QPS)
+	defer limiter.Stop()
+
+	results := make([]FetchResult, len(instanceTypes))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				result := &results[index]
+				result.InstanceType = instanceTypes[index]
+				result.Err = fetch(result.InstanceType, func(request func() error) error {
+					return opts.callWithRetry(limiter, &result.Attempts, request)
+				})
+			}
+		}()
+	}
+
+	for index := range instanceTypes {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (opts FetchOptions) callWithRetry(limiter *rateLimiter, attempts *int, request func() error) error {
+	for retry := 1; ; retry++ {
+		limiter.Wait()
+		*attempts++
+		err := request()
+		if err == nil || retry > opts.Retries || !IsRetryable(err) {
+			return err
+		}
+		time.Sleep(opts.backoff(retry))
+	}
+}
+
+// backoff doubles on every attempt and adds up to 50% jitter.
+func (opts FetchOptions) backoff(attempt int) time.Duration {
+	d := opts.Backoff << uint(attempt-1)
+	if d <= 0 || d > MaxBackoff {
+		d = MaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// IsRetryable reports whether the error is throttling or transient.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case *sdkErrors.ServerError:
+		return e.HttpStatus() >= 500 ||
+			strings.HasPrefix(e.ErrorCode(), "Throttling") ||
+			e.ErrorCode() == "ServiceUnavailable"
+	case *sdkErrors.ClientError:
+		if e.ErrorCode() == sdkErrors.TimeoutErrorCode {
+			return true
+		}
+		_, ok := e.OriginError().(net.Error)
+		return ok
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// DefaultFetchOptions is used unless the caller overrides it.
+func DefaultFetchOptions() FetchOptions {
+	return FetchOptions{
+		Concurrency: 8,
+		QPS:         10,
+		Retries:     3,
+		Backoff:     500 * time.Millisecond,
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	sdkErrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func throttlingError() error {
+	return sdkErrors.NewServerError(http.StatusBadRequest, `{"Code":"Throttling.User","Message":"Request was denied due to user flow control."}`, "")
+}
+
+func invalidParameterError() error {
+	return sdkErrors.NewServerError(http.StatusBadRequest, `{"Code":"InvalidParameter","Message":"The specified parameter is not valid."}`, "")
+}
+
+// stubCalls fails the first failures requests of every instanceType with the error of it.
+type stubCalls struct {
+	lock     sync.Mutex
+	failures map[string]int
+	errs     map[string]error
+	calls    map[string]int
+}
+
+func (s *stubCalls) request(instanceType string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls[instanceType]++
+	if s.calls[instanceType] <= s.failures[instanceType] {
+		return s.errs[instanceType]
+	}
+	return nil
+}
+
+func TestFetchOptionsRun(t *testing.T) {
+	stub := &stubCalls{
+		failures: map[string]int{
+			"ecs.throttled": 2,
+			"ecs.exhausted": 100,
+			"ecs.invalid":   100,
+			"ecs.flaky":     1,
+		},
+		errs: map[string]error{
+			"ecs.throttled": throttlingError(),
+			"ecs.exhausted": throttlingError(),
+			"ecs.invalid":   invalidParameterError(),
+			"ecs.flaky":     sdkErrors.NewServerError(http.StatusServiceUnavailable, `{"Code":"ServiceUnavailable"}`, ""),
+		},
+		calls: make(map[string]int),
+	}
+	opts := FetchOptions{Concurrency: 3, Retries: 3, Backoff: time.Millisecond}
+
+	instanceTypes := []string{"ecs.ok", "ecs.throttled", "ecs.exhausted", "ecs.invalid", "ecs.flaky", "ecs.paged"}
+	results := opts.Run(instanceTypes, func(instanceType string, call Caller) error {
+		pages := 1
+		if instanceType == "ecs.paged" {
+			pages = 3
+		}
+		for page := 0; page < pages; page++ {
+			if err := call(func() error { return stub.request(instanceType) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	want := []struct {
+		attempts int
+		err      bool
+	}{
+		{1, false},
+		// two throttled requests are retried
+		{3, false},
+		// the first request and every retry
+		{4, true},
+		// not retryable
+		{1, true},
+		{2, false},
+		// every page is an attempt
+		{3, false},
+	}
+	if len(results) != len(instanceTypes) {
+		t.Fatalf("%d results of %d instanceTypes", len(results), len(instanceTypes))
+	}
+	for i, result := range results {
+		if result.InstanceType != instanceTypes[i] {
+			t.Errorf("result %d is of %s, want %s", i, result.InstanceType, instanceTypes[i])
+		}
+		if result.Attempts != want[i].attempts {
+			t.Errorf("%s took %d attempts, want %d", result.InstanceType, result.Attempts, want[i].attempts)
+		}
+		if (result.Err != nil) != want[i].err {
+			t.Errorf("%s failed with %v, want an error %v", result.InstanceType, result.Err, want[i].err)
+		}
+	}
+	if err, ok := results[2].Err.(*sdkErrors.ServerError); !ok || err.ErrorCode() != "Throttling.User" {
+		t.Errorf("the exhausted retries end with %v, want the last throttling error", results[2].Err)
+	}
+	if err, ok := results[3].Err.(*sdkErrors.ServerError); !ok || err.ErrorCode() != "InvalidParameter" {
+		t.Errorf("the invalid request ends with %v", results[3].Err)
+	}
+}
+
+func TestFetchOptionsRunWithoutWorkers(t *testing.T) {
+	opts := FetchOptions{Concurrency: 0}
+	results := opts.Run([]string{"a", "b"}, func(instanceType string, call Caller) error {
+		return call(func() error { return nil })
+	})
+	for _, result := range results {
+		if result.Attempts != 1 || result.Err != nil {
+			t.Errorf("%s: %d attempts, %v", result.InstanceType, result.Attempts, result.Err)
+		}
+	}
+}
+
+func TestFetchOptionsRateLimit(t *testing.T) {
+	opts := FetchOptions{Concurrency: 5, QPS: 50}
+	begin := time.Now()
+	opts.Run([]string{"a", "b", "c", "d", "e"}, func(instanceType string, call Caller) error {
+		return call(func() error { return nil })
+	})
+	// a token every 20ms, the first one after 20ms
+	if elapsed := time.Since(begin); elapsed < 90*time.Millisecond {
+		t.Errorf("5 requests at 50 qps took %s", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	opts := FetchOptions{Backoff: 100 * time.Millisecond}
+	for attempt, base := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if d := opts.backoff(attempt + 1); d < base || d > base*3/2 {
+				t.Errorf("backoff of attempt %d is %s, want within [%s,%s]", attempt+1, d, base, base*3/2)
+			}
+		}
+	}
+	if d := opts.backoff(20); d < MaxBackoff || d > MaxBackoff*3/2 {
+		t.Errorf("backoff of attempt 20 is %s, want it capped at %s", d, MaxBackoff)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"throttling", throttlingError(), true},
+		{"unavailable", sdkErrors.NewServerError(http.StatusServiceUnavailable, `{"Code":"ServiceUnavailable"}`, ""), true},
+		{"server error", sdkErrors.NewServerError(http.StatusInternalServerError, `{"Code":"InternalError"}`, ""), true},
+		{"invalid parameter", invalidParameterError(), false},
+		{"timeout", sdkErrors.NewClientError(sdkErrors.TimeoutErrorCode, "timeout", nil), true},
+		{"network", sdkErrors.NewClientError("SDK.ServerUnreachable", "unreachable", &net.OpError{Op: "dial", Err: errors.New("refused")}), true},
+		{"client", sdkErrors.NewClientError("SDK.InvalidParam", "invalid", nil), false},
+		{"net", &net.DNSError{Err: "no such host"}, true},
+		{"other", errors.New("boom"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("%s: retryable is %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strings"
+)
+
+const (
+	LocalStorageAny      = "any"
+	LocalStorageRequired = "required"
+	LocalStorageNone     = "none"
+)
+
+// which instanceTypes are analyzed, a zero bound means no bound unless noted
+type InstanceFilter struct {
+	MinCpu    int
+	MaxCpu    int
+	MinMemory float64
+	MaxMemory float64
+	// Family is matched as a part of the instanceTypeId, or with ExactFamily
+	// as the whole family or instanceTypeId
+	Family      []string
+	ExactFamily bool
+	// families or instanceTypes left out, matched exactly
+	ExcludeFamily []string
+	Generation    []string
+	// InstanceFamilyLevel to keep or leave out (e.g. CreditEntryLevel of the burstable types)
+	FamilyLevel        []string
+	ExcludeFamilyLevel []string
+	MinGPU             int
+	// negative means no limit, so 0 can rule out every gpu
+	MaxGPU  int
+	GPUSpec string
+	// one of any,required,none
+	LocalStorage string
+	MinEni       int
+	// Mbps, the lower of rx and tx
+	MinBandwidth int
+	// packets per second, the lower of rx and tx
+	MinPps int64
+	// GiB of memory per vCPU
+	MinMemoryRatio float64
+	MaxMemoryRatio float64
+	// -where expression, only its part without prices is checked here
+	Where *Expr
+}
+
+// Validate checks the bounds that can not be satisfied.
+func (f InstanceFilter) Validate() error {
+	switch f.LocalStorage {
+	case LocalStorageAny, LocalStorageRequired, LocalStorageNone, "":
+	default:
+		return fmt.Errorf("unknown local storage %s, use one of any,required,none", f.LocalStorage)
+	}
+	if f.MaxCpu > 0 && f.MinCpu > f.MaxCpu {
+		return fmt.Errorf("min cpu %d is above max cpu %d", f.MinCpu, f.MaxCpu)
+	}
+	if f.MaxMemory > 0 && f.MinMemory > f.MaxMemory {
+		return fmt.Errorf("min memory %g is above max memory %g", f.MinMemory, f.MaxMemory)
+	}
+	if f.MaxGPU >= 0 && f.MinGPU > f.MaxGPU {
+		return fmt.Errorf("min gpu %d is above max gpu %d", f.MinGPU, f.MaxGPU)
+	}
+	if f.MaxMemoryRatio > 0 && f.MinMemoryRatio > f.MaxMemoryRatio {
+		return fmt.Errorf("min memory ratio %g is above max memory ratio %g", f.MinMemoryRatio, f.MaxMemoryRatio)
+	}
+	return nil
+}
+
+// Match reports whether the instanceType passes every bound of the filter.
+func (f InstanceFilter) Match(t ecsService.InstanceType) bool {
+	if t.CpuCoreCount < f.MinCpu || f.MaxCpu > 0 && t.CpuCoreCount > f.MaxCpu {
+		return false
+	}
+	if t.MemorySize < f.MinMemory || f.MaxMemory > 0 && t.MemorySize > f.MaxMemory {
+		return false
+	}
+	if !f.matchFamily(t) || containsExact(f.ExcludeFamily, t.InstanceTypeFamily, t.InstanceTypeId) {
+		return false
+	}
+	if len(f.Generation) > 0 && !containsExact(f.Generation, t.Generation) {
+		return false
+	}
+	if len(f.FamilyLevel) > 0 && !containsExact(f.FamilyLevel, t.InstanceFamilyLevel) ||
+		containsExact(f.ExcludeFamilyLevel, t.InstanceFamilyLevel) {
+		return false
+	}
+	if t.GPUAmount < f.MinGPU || f.MaxGPU >= 0 && t.GPUAmount > f.MaxGPU {
+		return false
+	}
+	if f.GPUSpec != "" && !strings.Contains(strings.ToLower(t.GPUSpec), strings.ToLower(f.GPUSpec)) {
+		return false
+	}
+	switch f.LocalStorage {
+	case LocalStorageRequired:
+		if t.LocalStorageAmount == 0 {
+			return false
+		}
+	case LocalStorageNone:
+		if t.LocalStorageAmount > 0 {
+			return false
+		}
+	}
+	if t.EniQuantity < f.MinEni {
+		return false
+	}
+	// the api reports the bandwidth in Kbps
+	if f.MinBandwidth > 0 && minInt(t.InstanceBandwidthRx, t.InstanceBandwidthTx) < f.MinBandwidth*1024 {
+		return false
+	}
+	if f.MinPps > 0 && minInt64(t.InstancePpsRx, t.InstancePpsTx) < f.MinPps {
+		return false
+	}
+	if f.MinMemoryRatio > 0 || f.MaxMemoryRatio > 0 {
+		if t.CpuCoreCount == 0 {
+			return false
+		}
+		ratio := t.MemorySize / float64(t.CpuCoreCount)
+		if ratio < f.MinMemoryRatio || f.MaxMemoryRatio > 0 && ratio > f.MaxMemoryRatio {
+			return false
+		}
+	}
+	if f.Where != nil && !f.Where.MayMatch(t) {
+		return false
+	}
+	return true
+}
+
+func (f InstanceFilter) matchFamily(t ecsService.InstanceType) bool {
+	if len(f.Family) == 0 {
+		return true
+	}
+	for _, family := range f.Family {
+		if f.ExactFamily && (t.InstanceTypeFamily == family || t.InstanceTypeId == family) ||
+			!f.ExactFamily && strings.Contains(t.InstanceTypeId, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList splits the comma separated value and drops the empty items.
+func SplitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsExact(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
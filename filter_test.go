@@ -0,0 +1,119 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strings"
+	"testing"
+)
+
+var filterTypes = map[string]ecsService.InstanceType{
+	"c6": {InstanceTypeId: "ecs.c6.xlarge", InstanceTypeFamily: "ecs.c6", Generation: "ecs-4", InstanceFamilyLevel: "EnterpriseLevel",
+		CpuCoreCount: 4, MemorySize: 8, EniQuantity: 3, InstanceBandwidthRx: 1536000, InstanceBandwidthTx: 1536000, InstancePpsRx: 300000, InstancePpsTx: 300000},
+	"c6e": {InstanceTypeId: "ecs.c6e.xlarge", InstanceTypeFamily: "ecs.c6e", Generation: "ecs-4", InstanceFamilyLevel: "EnterpriseLevel",
+		CpuCoreCount: 4, MemorySize: 8, EniQuantity: 3, InstanceBandwidthRx: 2048000, InstanceBandwidthTx: 1024000},
+	"r6": {InstanceTypeId: "ecs.r6.large", InstanceTypeFamily: "ecs.r6", Generation: "ecs-4", InstanceFamilyLevel: "EnterpriseLevel",
+		CpuCoreCount: 2, MemorySize: 16, EniQuantity: 2},
+	"t5": {InstanceTypeId: "ecs.t5-lc1m2.small", InstanceTypeFamily: "ecs.t5", Generation: "ecs-2", InstanceFamilyLevel: "CreditEntryLevel",
+		CpuCoreCount: 1, MemorySize: 2, EniQuantity: 1},
+	"gn6i": {InstanceTypeId: "ecs.gn6i-c4g1.xlarge", InstanceTypeFamily: "ecs.gn6i", Generation: "ecs-4", InstanceFamilyLevel: "EnterpriseLevel",
+		CpuCoreCount: 4, MemorySize: 15, GPUAmount: 1, GPUSpec: "NVIDIA T4", EniQuantity: 2},
+	"i2": {InstanceTypeId: "ecs.i2.xlarge", InstanceTypeFamily: "ecs.i2", Generation: "ecs-3", InstanceFamilyLevel: "EnterpriseLevel",
+		CpuCoreCount: 4, MemorySize: 32, LocalStorageAmount: 1, LocalStorageCapacity: 894, EniQuantity: 3},
+}
+
+func TestInstanceFilterMatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter InstanceFilter
+		want   []string
+	}{
+		{"no bound", InstanceFilter{MaxGPU: -1}, []string{"c6", "c6e", "r6", "t5", "gn6i", "i2"}},
+		{"cpu bounds", InstanceFilter{MinCpu: 2, MaxCpu: 2, MaxGPU: -1}, []string{"r6"}},
+		{"no max cpu", InstanceFilter{MinCpu: 4, MaxGPU: -1}, []string{"c6", "c6e", "gn6i", "i2"}},
+		{"memory bounds", InstanceFilter{MinMemory: 8, MaxMemory: 16, MaxGPU: -1}, []string{"c6", "c6e", "r6", "gn6i"}},
+		{"memory ratio", InstanceFilter{MinMemoryRatio: 4, MaxGPU: -1}, []string{"r6", "i2"}},
+		{"memory ratio range", InstanceFilter{MinMemoryRatio: 2, MaxMemoryRatio: 4, MaxGPU: -1}, []string{"c6", "c6e", "t5", "gn6i"}},
+		{"local storage required", InstanceFilter{LocalStorage: LocalStorageRequired, MaxGPU: -1}, []string{"i2"}},
+		{"local storage none", InstanceFilter{LocalStorage: LocalStorageNone, MaxGPU: -1}, []string{"c6", "c6e", "r6", "t5", "gn6i"}},
+		{"local storage any", InstanceFilter{LocalStorage: LocalStorageAny, MaxGPU: -1}, []string{"c6", "c6e", "r6", "t5", "gn6i", "i2"}},
+		// -1 is no limit, 0 rules out every gpu
+		{"gpu no limit", InstanceFilter{MaxGPU: -1}, []string{"c6", "c6e", "r6", "t5", "gn6i", "i2"}},
+		{"no gpu", InstanceFilter{MaxGPU: 0}, []string{"c6", "c6e", "r6", "t5", "i2"}},
+		{"min gpu", InstanceFilter{MinGPU: 1, MaxGPU: -1}, []string{"gn6i"}},
+		{"gpu spec", InstanceFilter{MinGPU: 1, MaxGPU: -1, GPUSpec: "t4"}, []string{"gn6i"}},
+		{"other gpu spec", InstanceFilter{MaxGPU: -1, GPUSpec: "A10"}, []string{}},
+		// a part of the instanceTypeId, so ecs.c6 matches ecs.c6e too
+		{"family prefix", InstanceFilter{Family: []string{"ecs.c6"}, MaxGPU: -1}, []string{"c6", "c6e"}},
+		{"exact family", InstanceFilter{Family: []string{"ecs.c6"}, ExactFamily: true, MaxGPU: -1}, []string{"c6"}},
+		{"exact instanceType", InstanceFilter{Family: []string{"ecs.c6e.xlarge", "ecs.r6"}, ExactFamily: true, MaxGPU: -1}, []string{"c6e", "r6"}},
+		{"exact part of an instanceType", InstanceFilter{Family: []string{"c6"}, ExactFamily: true, MaxGPU: -1}, []string{}},
+		{"exclude family", InstanceFilter{Family: []string{"ecs.c6"}, ExcludeFamily: []string{"ecs.c6e"}, MaxGPU: -1}, []string{"c6"}},
+		{"exclude instanceType", InstanceFilter{ExcludeFamily: []string{"ecs.c6.xlarge", "ecs.gn6i"}, MaxGPU: -1}, []string{"c6e", "r6", "t5", "i2"}},
+		{"generation", InstanceFilter{Generation: []string{"ecs-2", "ecs-3"}, MaxGPU: -1}, []string{"t5", "i2"}},
+		{"family level", InstanceFilter{FamilyLevel: []string{"CreditEntryLevel"}, MaxGPU: -1}, []string{"t5"}},
+		{"exclude family level", InstanceFilter{ExcludeFamilyLevel: []string{"CreditEntryLevel"}, MaxGPU: 0}, []string{"c6", "c6e", "r6", "i2"}},
+		{"eni", InstanceFilter{MinEni: 3, MaxGPU: -1}, []string{"c6", "c6e", "i2"}},
+		// the lower of rx and tx in Mbps
+		{"bandwidth", InstanceFilter{MinBandwidth: 1500, MaxGPU: -1}, []string{"c6"}},
+		{"pps", InstanceFilter{MinPps: 300000, MaxGPU: -1}, []string{"c6"}},
+	}
+	for _, c := range cases {
+		if err := c.filter.Validate(); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := make([]string, 0)
+		for _, name := range []string{"c6", "c6e", "r6", "t5", "gn6i", "i2"} {
+			if c.filter.Match(filterTypes[name]) {
+				got = append(got, name)
+			}
+		}
+		if !equalStrings(got, c.want) {
+			t.Errorf("%s: matched %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestInstanceFilterMatchWhere(t *testing.T) {
+	expr, err := ParseExpr(`cpu >= 4 && price < 0.1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := InstanceFilter{MaxGPU: -1, Where: expr}
+	// the part of the expression on the instanceType is checked before the prices
+	if filter.Match(filterTypes["r6"]) || !filter.Match(filterTypes["c6"]) {
+		t.Errorf("where is not applied to the instanceType")
+	}
+}
+
+func TestInstanceFilterValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter InstanceFilter
+		err    string
+	}{
+		{"defaults", InstanceFilter{MinCpu: 1, MaxCpu: 32, MaxMemory: 64, MaxGPU: -1}, ""},
+		{"no upper bounds", InstanceFilter{MinCpu: 64, MinMemory: 512, MinGPU: 8, MaxGPU: -1, MinMemoryRatio: 8}, ""},
+		{"equal bounds", InstanceFilter{MinCpu: 4, MaxCpu: 4, MinMemory: 8, MaxMemory: 8, MinGPU: 1, MaxGPU: 1}, ""},
+		{"cpu", InstanceFilter{MinCpu: 8, MaxCpu: 4, MaxGPU: -1}, "min cpu 8 is above max cpu 4"},
+		{"memory", InstanceFilter{MinMemory: 16, MaxMemory: 8, MaxGPU: -1}, "min memory 16 is above max memory 8"},
+		{"gpu", InstanceFilter{MinGPU: 1, MaxGPU: 0}, "min gpu 1 is above max gpu 0"},
+		{"memory ratio", InstanceFilter{MinMemoryRatio: 8, MaxMemoryRatio: 4, MaxGPU: -1}, "min memory ratio 8 is above max memory ratio 4"},
+		{"local storage", InstanceFilter{LocalStorage: "ssd", MaxGPU: -1}, "unknown local storage ssd"},
+	}
+	for _, c := range cases {
+		err := c.filter.Validate()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := SplitList(" ecs.c6, ,ecs.g6 ,"); !equalStrings(got, []string{"ecs.c6", "ecs.g6"}) {
+		t.Errorf("split is %v", got)
+	}
+	if got := SplitList(""); len(got) != 0 {
+		t.Errorf("split of nothing is %v", got)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+// The flags are registered in groups, a command takes the groups it needs on its own flag set.
+
+// the config file, taken by every command
+var configPath, configProfile *string
+
+func configFlags(fs *flag.FlagSet) {
+	configPath = fs.String("config", "", "Yaml or json file of the settings, the keys are the names of the flags")
+	configProfile = fs.String("config-profile", "", "Profile of the config file to apply over its top level settings")
+}
+
+// the credential and the api client
+var (
+	accessKeyId, accessKeySecret, profile, aliyunConfig *string
+	roleArn, roleSession                                *string
+	concurrency, retries                                *int
+	qps                                                 *float64
+	cacheDir                                            *string
+	noCache                                             *bool
+	typesTTL, stockTTL, priceTTL                        *time.Duration
+	replay, record, store                               *string
+)
+
+func apiFlags(fs *flag.FlagSet) {
+	accessKeyId = fs.String("accessKeyId", "", "Your accessKeyId of cloud account, prefer the environment variables or the aliyun cli profile")
+	accessKeySecret = fs.String("accessKeySecret", "", "Your accessKeySecret of cloud account, prefer the environment variables or the aliyun cli profile")
+	profile = fs.String("profile", "", "Profile of the aliyun cli config, defaults to its current profile")
+	aliyunConfig = fs.String("aliyun-config", "", "Path of the aliyun cli config, defaults to ~/.aliyun/config.json")
+	roleArn = fs.String("role-arn", "", "Ram role to assume through sts with the resolved access key")
+	roleSession = fs.String("role-session-name", DefaultSessionName, "Session name of the assumed ram role")
+	concurrency = fs.Int("concurrency", 8, "Max concurrent requests of price history")
+	qps = fs.Float64("qps", 10, "Max requests per second of price history, 0 means unlimited")
+	retries = fs.Int("retries", 3, "Retries of a throttled or failed price history request")
+	cacheDir = fs.String("cache-dir", DefaultCacheDir(), "Directory of the local cache of api responses")
+	noCache = fs.Bool("no-cache", false, "Call the api without the local cache")
+	typesTTL = fs.Duration("cache-types-ttl", 72*time.Hour, "How long the cached instance types stay fresh")
+	stockTTL = fs.Duration("cache-stock-ttl", 10*time.Minute, "How long the cached stock of the zones stays fresh")
+	priceTTL = fs.Duration("cache-price-ttl", 10*time.Minute, "How long the cached price history stays fresh")
+	replay = fs.String("replay", "", "Replay the api responses recorded in this directory instead of calling the live api")
+	record = fs.String("record", "", "Record the api responses into this directory for later replay")
+	store = fs.String("store", "", "Directory of the price history store, the record command writes it and the rank reads prices from it instead of the api")
+}
+
+var region *string
+
+func regionFlags(fs *flag.FlagSet) {
+	region = fs.String("region", DefaultRegion, "The regions of spot instances (e.g. cn-hangzhou,cn-shanghai), all means every region")
+}
+
+// the instance filter
+var (
+	cpu, memory, maxCpu, maxMemory    *int
+	family, excludeFamily, generation *string
+	exactFamily                       *bool
+	familyLevel, excludeLevel         *string
+	minGPU, maxGPU                    *int
+	gpuSpec, localStorage             *string
+	minEni, minBandwidth              *int
+	minPps                            *int64
+	minMemoryRatio, maxMemoryRatio    *float64
+	where                             *string
+)
+
+func filterFlags(fs *flag.FlagSet) {
+	cpu = fs.Int("mincpu", 1, "Min cores of spot instances")
+	memory = fs.Int("minmem", 2, "Min memory of spot instances")
+	maxCpu = fs.Int("maxcpu", 32, "Max cores of spot instances ")
+	maxMemory = fs.Int("maxmem", 64, "Max memory of spot instances")
+	family = fs.String("family", "", "The spot instance family you want (e.g. ecs.n1,ecs.n2)")
+	exactFamily = fs.Bool("exact-family", false, "Match -family against the whole family or instanceType, so ecs.c6 no longer matches ecs.c6e")
+	excludeFamily = fs.String("exclude-family", "", "The families or instanceTypes to leave out (e.g. ecs.c6e,ecs.c6.large)")
+	generation = fs.String("generation", "", "The generations of instance families you want (e.g. ecs-3,ecs-4)")
+	familyLevel = fs.String("family-level", "", "The family levels you want (e.g. EnterpriseLevel)")
+	excludeLevel = fs.String("exclude-family-level", "", "The family levels to leave out (e.g. CreditEntryLevel for the burstable types)")
+	minGPU = fs.Int("mingpu", 0, "Min GPUs of spot instances")
+	maxGPU = fs.Int("maxgpu", -1, "Max GPUs of spot instances, -1 means no limit")
+	gpuSpec = fs.String("gpu-spec", "", "GPU model of spot instances (e.g. T4)")
+	localStorage = fs.String("local-storage", LocalStorageAny, "Local disks of spot instances, one of any,required,none")
+	minEni = fs.Int("min-eni", 0, "Min elastic network interfaces of spot instances")
+	minBandwidth = fs.Int("min-bandwidth", 0, "Min internal bandwidth of spot instances in Mbps")
+	minPps = fs.Int64("min-pps", 0, "Min packets per second of spot instances")
+	minMemoryRatio = fs.Float64("min-mem-ratio", 0, "Min GiB of memory per vCPU of spot instances")
+	maxMemoryRatio = fs.Float64("max-mem-ratio", 0, "Max GiB of memory per vCPU of spot instances, 0 means no limit")
+	where = fs.String("where", "", "Expression the pools have to match (e.g. cpu >= 4 && mem/cpu == 4 && family in (\"ecs.g6\",\"ecs.g7\") && discount < 3)")
+}
+
+// the window of price history
+var (
+	resolution *int
+	start, end *string
+)
+
+func windowFlags(fs *flag.FlagSet) {
+	resolution = fs.Int("resolution", 7, "The window of price history analysis")
+	start = fs.String("start", "", "Start time of price history analysis (e.g. 2019-11-01T00:00:00Z), overrides -resolution")
+	end = fs.String("end", "", "End time of price history analysis (e.g. 2019-11-08T00:00:00Z), defaults to now")
+}
+
+// the analysis and the rank model
+var (
+	cutoff, limit                    *int
+	riskMetric                       *string
+	riskThreshold                    *float64
+	sortKey, sortWeights, rankConfig *string
+	showUnavailable                  *bool
+)
+
+func rankFlags(fs *flag.FlagSet) {
+	cutoff = fs.Int("cutoff", 2, "Discount of the spot instance prices")
+	limit = fs.Int("limit", 20, "Limit of the spot instances")
+	riskMetric = fs.String("risk-metric", RiskCV, "Metric of the risk column, one of stddev,cv,spike,changes,above")
+	riskThreshold = fs.Float64("risk-threshold", 0.5, "Price threshold of the above metric as a fraction of the on-demand price")
+	sortKey = fs.String("sort", SortByCore, "Sort key of the rank, one of core,memory,price,discount,risk,forecast,hourly,monthly,composite")
+	sortWeights = fs.String("sort-weights", "", "Weights of the composite sort key (e.g. core=0.7,risk=0.3)")
+	rankConfig = fs.String("rank-config", "", "Json file of the rank model (e.g. {\"Key\":\"composite\",\"Weights\":{\"core\":0.7,\"risk\":0.3}}), overrides -sort")
+	showUnavailable = fs.Bool("show-unavailable", false, "Keep the pools without stock in the rank and flag them, they are never used by -apg or -portfolio")
+}
+
+// the forecast of the pools
+var (
+	forecastModel               *string
+	forecastHorizon             *time.Duration
+	forecastAlpha, forecastConf *float64
+)
+
+func forecastFlags(fs *flag.FlagSet) {
+	forecastModel = fs.String("forecast", ForecastEWMA, "Forecast model of the spot price, one of ewma,trend,seasonal")
+	forecastHorizon = fs.Duration("forecast-horizon", 24*time.Hour, "How far after the end of the window the spot price is forecast")
+	forecastAlpha = fs.Float64("forecast-alpha", 0.1, "Smoothing factor of the ewma and seasonal forecast over hourly prices, higher follows recent prices closer")
+	forecastConf = fs.Float64("forecast-confidence", 0.9, "Confidence of the forecast band")
+}
+
+// the recommended price limit of the pools
+var bidCoverage, bidGoRatio *float64
+
+func bidFlags(fs *flag.FlagSet) {
+	bidCoverage = fs.Float64("bid-coverage", 0.95, "Fraction of the window the recommended price limit of a pool would have kept an instance running")
+	bidGoRatio = fs.Float64("bid-go-ratio", 0.8, "Recommend SpotAsPriceGo instead of a price limit once the limit reaches this fraction of the on-demand price")
+}
+
+// the bill of materials of a node and the currency of its cost
+var (
+	tco                           *bool
+	systemDiskCategory, dataDisks *string
+	internetChargeType, imageId   *string
+	systemDiskSize, bandwidth     *int
+	traffic                       *float64
+	currency, exchangeRates       *string
+)
+
+func tcoFlags(fs *flag.FlagSet) {
+	tco = fs.Bool("tco", false, "Add the disks, bandwidth and image of a node priced by DescribePrice to the spot price of every pool")
+	systemDiskCategory = fs.String("system-disk-category", "cloud_essd", "System disk category of a node")
+	systemDiskSize = fs.Int("system-disk-size", 40, "System disk size of a node in GiB")
+	dataDisks = fs.String("data-disks", "", "Data disks of a node as category:size in GiB (e.g. cloud_essd:100,cloud_efficiency:200), at most 4")
+	internetChargeType = fs.String("internet-charge-type", PayByTraffic, "Internet charge type of a node, one of PayByTraffic,PayByBandwidth")
+	bandwidth = fs.Int("bandwidth", 0, "Max outbound public bandwidth of a node in Mbps, 0 means no public ip")
+	traffic = fs.Float64("traffic", 0, "Outbound GB per month of a node, priced by the GB with PayByTraffic")
+	imageId = fs.String("image-id", "", "Image of a node, the license of a paid image is part of its cost")
+	currency = fs.String("currency", "", "Currency of the hourly and monthly cost, the one of the account when empty")
+	exchangeRates = fs.String("exchange-rates", "", "Value of one unit of the account currency in -currency (e.g. CNY=0.14)")
+}
+
+var output *string
+
+// outputFlags takes the output formats the command can write.
+func outputFlags(formats ...string) func(fs *flag.FlagSet) {
+	return func(fs *flag.FlagSet) {
+		output = fs.String("output", OutputTable, "Output format, one of "+strings.Join(formats, ","))
+	}
+}
+
+// the auto provisioning group
+var (
+	apgApply, apgDryRun           *bool
+	apgName                       *string
+	apgTop, apgCapacity           *int
+	apgWeight, apgStrategy        *string
+	apgMaxPrice                   *float64
+	apgBid                        *bool
+	apgVSwitches                  *string
+	launchTemplate, launchVersion *string
+)
+
+func apgFlags(fs *flag.FlagSet) {
+	apgApply = fs.Bool("apg-apply", false, "Create the auto provisioning group after printing its spec")
+	apgDryRun = fs.Bool("apg-dry-run", false, "Check the auto provisioning group with a dry run of the api without creating it")
+	apgName = fs.String("apg-name", "spot-instance-advisor", "Name of the auto provisioning group")
+	apgTop = fs.Int("apg-top", 10, "Number of top ranked pools in the auto provisioning group")
+	apgCapacity = fs.Int("apg-capacity", 10, "Total target capacity of the auto provisioning group in weight units")
+	apgWeight = fs.String("apg-weight", WeightByPrice, "Weighted capacity of each pool, one of price,core")
+	apgStrategy = fs.String("apg-strategy", "lowest-price", "Spot allocation strategy, one of lowest-price,diversified")
+	apgMaxPrice = fs.Float64("apg-max-price", 0, "Max spot price of the group, 0 means the highest on-demand price of the pools")
+	apgBid = fs.Bool("apg-bid", false, "Cap every pool by its recommended price limit instead of its on-demand price, see -bid-coverage")
+	apgVSwitches = fs.String("apg-vswitches", "", "VSwitch of each zone (e.g. cn-hangzhou-h=vsw-xxx,cn-hangzhou-i=vsw-yyy)")
+	launchTemplate = fs.String("launch-template-id", "", "Launch template of the auto provisioning group")
+	launchVersion = fs.String("launch-template-version", "", "Launch template version, defaults to the default version")
+}
+
+// the target capacity of the portfolio
+var (
+	targetCpu, minFamilies, minZones *int
+	targetMemory, maxShare, maxRisk  *float64
+)
+
+func portfolioFlags(fs *flag.FlagSet) {
+	targetCpu = fs.Int("target-cpu", 0, "Total vCPU the portfolio has to cover")
+	targetMemory = fs.Float64("target-mem", 0, "Total memory in GiB the portfolio has to cover")
+	maxShare = fs.Float64("max-share", 0.3, "Max share of the target capacity in one pool of the portfolio")
+	minFamilies = fs.Int("min-families", 2, "Min distinct instance families of the portfolio")
+	minZones = fs.Int("min-zones", 2, "Min distinct zones of the portfolio")
+	maxRisk = fs.Float64("max-risk", 0, "Max risk of the pools in the portfolio, 0 means no limit")
+}
+
+// the backtest command
+var (
+	strategyName        *string
+	strategyPools       *int
+	rebalance, lookback *time.Duration
+	bid                 *float64
+)
+
+func backtestFlags(fs *flag.FlagSet) {
+	strategyName = fs.String("strategy", StrategyTop, "Strategy of the backtest, one of top,types,portfolio")
+	strategyPools = fs.Int("pools", 10, "Pools run by the top strategy, instanceTypes run by the types strategy")
+	rebalance = fs.Duration("rebalance", 24*time.Hour, "Interval between two selections of the pools of the backtest")
+	lookback = fs.Duration("lookback", 72*time.Hour, "Price history analyzed before every selection of the backtest")
+	bid = fs.Float64("bid", 0, "Price limit of the backtest as a multiple of the spot price at launch, 0 means SpotAsPriceGo")
+}
+
+// the daemons of the record and watch commands
+var (
+	interval *time.Duration
+	once     *bool
+)
+
+func daemonFlags(fs *flag.FlagSet) {
+	interval = fs.Duration("interval", time.Hour, "Interval between two polls of the record and watch commands")
+	once = fs.Bool("once", false, "Poll once and exit instead of running the record or watch command as a daemon")
+}
+
+var (
+	listen  *string
+	refresh *time.Duration
+)
+
+func serveFlags(fs *flag.FlagSet) {
+	listen = fs.String("listen", ":8080", "Address the serve command listens on")
+	refresh = fs.Duration("refresh", 10*time.Minute, "Interval between two background refreshes of the serve command")
+}
+
+var rules *string
+
+func watchFlags(fs *flag.FlagSet) {
+	rules = fs.String("rules", "", "Json file of the alert rules and webhooks of the watch command")
+}
+
+// the view of the history command
+var (
+	sparkWidth *int
+	allPoints  *bool
+)
+
+func historyFlags(fs *flag.FlagSet) {
+	sparkWidth = fs.Int("width", 60, "Characters of the sparkline of the history command")
+	allPoints = fs.Bool("points", false, "List every price point of the history command instead of the price changes")
+}
+
+// the switches of the flat command line without a command
+var apg, portfolio *bool
+
+func flatFlags(fs *flag.FlagSet) {
+	apg = fs.Bool("apg", false, "Print an auto provisioning group spec built from the rank instead of the rank")
+	portfolio = fs.Bool("portfolio", false, "Print a mix of pools covering the target capacity instead of the rank")
+}
+
+// the flag groups of the flat command line
+var flagGroups = []func(fs *flag.FlagSet){
+	configFlags, apiFlags, regionFlags, filterFlags, windowFlags, rankFlags, forecastFlags, bidFlags, tcoFlags, outputFlags(OutputTable, OutputJSON, OutputCSV, OutputYAML),
+	apgFlags, portfolioFlags, flatFlags,
+}
+
+// the flag groups only taken by a command
+var commandFlagGroups = []func(fs *flag.FlagSet){
+	daemonFlags, serveFlags, watchFlags, historyFlags, backtestFlags,
+}
+
+// knownFlags registers every flag on a set of its own, so the flags a command
+// does not take keep their defaults and the config file may hold the keys of any command.
+// It has to be called before the flags of the command are registered.
+func knownFlags() *flag.FlagSet {
+	known := flag.NewFlagSet("all", flag.ContinueOnError)
+	for _, group := range append(flagGroups, commandFlagGroups...) {
+		group(known)
+	}
+	return known
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"math"
+	"time"
+)
+
+const (
+	ForecastEWMA     = "ewma"
+	ForecastTrend    = "trend"
+	ForecastSeasonal = "seasonal"
+)
+
+// options of the forecast of every pool
+type ForecastOptions struct {
+	// one of ewma,trend,seasonal
+	Model   string
+	Horizon time.Duration
+	// smoothing factor of the ewma over the hourly samples, higher follows the recent prices closer
+	Alpha float64
+	// probability of the price to fall within the band, 0.9 gives the 5% and the 95% quantile
+	Confidence float64
+}
+
+// the spot price predicted at Time, the horizon after the end of the window
+type Forecast struct {
+	Model string  `json:"Model"`
+	Time  string  `json:"Time"`
+	Price float64 `json:"Price"`
+	Lower float64 `json:"Lower"`
+	Upper float64 `json:"Upper"`
+}
+
+// Validate checks the model and its parameters.
+func (opts ForecastOptions) Validate() error {
+	switch opts.Model {
+	case ForecastEWMA, ForecastTrend, ForecastSeasonal:
+	default:
+		return fmt.Errorf("unknown forecast model %s, use one of ewma,trend,seasonal", opts.Model)
+	}
+	if opts.Horizon < 0 {
+		return fmt.Errorf("forecast horizon %s is negative", opts.Horizon)
+	}
+	if opts.Alpha <= 0 || opts.Alpha > 1 {
+		return fmt.Errorf("forecast alpha %g is not within (0,1]", opts.Alpha)
+	}
+	if opts.Confidence <= 0 || opts.Confidence >= 1 {
+		return fmt.Errorf("forecast confidence %g is not within (0,1)", opts.Confidence)
+	}
+	return nil
+}
+
+// ForecastPrice fits the model to the price history sampled every hour and predicts
+// the price at the horizon after the end of the window.
+//
+//   - ewma smooths the samples, the prediction is the smoothed level
+//   - trend fits a straight line by least squares and extends it
+//   - seasonal removes the mean price of every hour of the day (and of every day of the week
+//     with two weeks of history) before the ewma and adds them back at the predicted time
+//
+// The band is the normal quantile of the confidence times the deviation of the fit, it widens with the horizon.
+func ForecastPrice(prices []ecsService.SpotPriceType, window PriceWindow, opts ForecastOptions) Forecast {
+	forecast := Forecast{Model: opts.Model}
+	points := parsePricePoints(prices)
+	if len(points) == 0 {
+		return forecast
+	}
+
+	end := window.End
+	if end.IsZero() || end.Before(points[len(points)-1].time) {
+		end = points[len(points)-1].time
+	}
+	target := end.Add(opts.Horizon)
+	forecast.Time = target.UTC().Format(TimeLayout)
+
+	times, samples := hourlySamples(points, end)
+	steps := math.Max(1, opts.Horizon.Hours())
+	z := math.Sqrt2 * math.Erfinv(opts.Confidence)
+
+	var price, spread float64
+	switch opts.Model {
+	case ForecastTrend:
+		price, spread = forecastTrend(samples, steps)
+	case ForecastSeasonal:
+		seasons := newSeasons(times, samples)
+		for i := range samples {
+			samples[i] -= seasons.at(times[i])
+		}
+		price, spread = forecastEWMA(samples, steps, opts.Alpha)
+		price += seasons.at(target)
+	default:
+		price, spread = forecastEWMA(samples, steps, opts.Alpha)
+	}
+
+	forecast.Price = math.Max(0, price)
+	forecast.Lower = math.Max(0, price-z*spread)
+	forecast.Upper = math.Max(0, price+z*spread)
+	return forecast
+}
+
+// hourlySamples reads the step function of the prices every hour from the first point to the end.
+func hourlySamples(points []pricePoint, end time.Time) ([]time.Time, []float64) {
+	times := make([]time.Time, 0)
+	samples := make([]float64, 0)
+	next := 0
+	current := points[0].price
+	for t := points[0].time; !t.After(end); t = t.Add(time.Hour) {
+		for next < len(points) && !points[next].time.After(t) {
+			current = points[next].price
+			next++
+		}
+		times = append(times, t)
+		samples = append(samples, current)
+	}
+	return times, samples
+}
+
+// forecastEWMA returns the smoothed level and the deviation of its one step ahead errors,
+// which grows with the steps as the level of an ewma does.
+func forecastEWMA(samples []float64, steps float64, alpha float64) (float64, float64) {
+	level := samples[0]
+	squares := 0.0
+	for _, sample := range samples[1:] {
+		err := sample - level
+		squares += err * err
+		level += alpha * err
+	}
+	if len(samples) < 2 {
+		return level, 0
+	}
+	sigma := math.Sqrt(squares / float64(len(samples)-1))
+	return level, sigma * math.Sqrt(1+(steps-1)*alpha*alpha)
+}
+
+// forecastTrend returns the line fitted to the samples steps after the last one and the
+// deviation of the prediction of a least squares line.
+func forecastTrend(samples []float64, steps float64) (float64, float64) {
+	n := float64(len(samples))
+	if len(samples) < 3 {
+		return samples[len(samples)-1], 0
+	}
+	meanX, meanY := (n-1)/2, 0.0
+	for _, sample := range samples {
+		meanY += sample / n
+	}
+	sxx, sxy := 0.0, 0.0
+	for i, sample := range samples {
+		dx := float64(i) - meanX
+		sxx += dx * dx
+		sxy += dx * (sample - meanY)
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	squares := 0.0
+	for i, sample := range samples {
+		err := sample - (intercept + slope*float64(i))
+		squares += err * err
+	}
+	sigma := math.Sqrt(squares / (n - 2))
+
+	x := n - 1 + steps
+	return intercept + slope*x, sigma * math.Sqrt(1+1/n+(x-meanX)*(x-meanX)/sxx)
+}
+
+// the mean deviation of every hour of the day and of every day of the week from the mean price
+type seasons struct {
+	hours    [24]float64
+	weekdays [7]float64
+}
+
+// newSeasons needs two days of samples for the hours and two weeks for the weekdays, the others are 0.
+func newSeasons(times []time.Time, samples []float64) seasons {
+	s := seasons{}
+	if len(samples) < 48 {
+		return s
+	}
+	mean := 0.0
+	for _, sample := range samples {
+		mean += sample / float64(len(samples))
+	}
+
+	var sums [24]float64
+	var counts [24]int
+	for i, sample := range samples {
+		hour := times[i].UTC().Hour()
+		sums[hour] += sample - mean
+		counts[hour]++
+	}
+	for hour := range s.hours {
+		if counts[hour] > 0 {
+			s.hours[hour] = sums[hour] / float64(counts[hour])
+		}
+	}
+
+	if len(samples) < 14*24 {
+		return s
+	}
+	var daySums [7]float64
+	var dayCounts [7]int
+	for i, sample := range samples {
+		t := times[i].UTC()
+		daySums[t.Weekday()] += sample - mean - s.hours[t.Hour()]
+		dayCounts[t.Weekday()]++
+	}
+	for day := range s.weekdays {
+		if dayCounts[day] > 0 {
+			s.weekdays[day] = daySums[day] / float64(dayCounts[day])
+		}
+	}
+	return s
+}
+
+func (s seasons) at(t time.Time) float64 {
+	t = t.UTC()
+	return s.hours[t.Hour()] + s.weekdays[t.Weekday()]
+}
+
+// DefaultForecastOptions predicts a day ahead with an ewma.
+func DefaultForecastOptions() ForecastOptions {
+	return ForecastOptions{
+		Model:      ForecastEWMA,
+		Horizon:    24 * time.Hour,
+		Alpha:      0.1,
+		Confidence: 0.9,
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+var forecastStart = time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC)
+
+// hourlySeries is a point every hour of price(hour) and the window ending at the last one.
+func hourlySeries(hours int, price func(hour int) float64) ([]ecsService.SpotPriceType, PriceWindow) {
+	prices := make([]ecsService.SpotPriceType, 0, hours)
+	for hour := 0; hour < hours; hour++ {
+		prices = append(prices, ecsService.SpotPriceType{
+			ZoneId:    "cn-hangzhou-h",
+			Timestamp: forecastStart.Add(time.Duration(hour) * time.Hour).Format(TimeLayout),
+			SpotPrice: price(hour),
+		})
+	}
+	return prices, PriceWindow{Start: forecastStart, End: forecastStart.Add(time.Duration(hours-1) * time.Hour)}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestForecastPrice(t *testing.T) {
+	constant := func(hour int) float64 { return 0.05 }
+	linear := func(hour int) float64 { return 0.01 + 0.001*float64(hour) }
+	// 0.06 in the first half of every day and 0.04 in the second
+	periodic := func(hour int) float64 {
+		if hour%24 < 12 {
+			return 0.06
+		}
+		return 0.04
+	}
+	falling := func(hour int) float64 { return 0.05 - 0.001*float64(hour) }
+
+	cases := []struct {
+		name    string
+		hours   int
+		price   func(hour int) float64
+		model   string
+		horizon time.Duration
+		want    float64
+		// the band is expected to be empty
+		exact bool
+	}{
+		{"constant ewma", 48, constant, ForecastEWMA, 24 * time.Hour, 0.05, true},
+		{"constant trend", 48, constant, ForecastTrend, 24 * time.Hour, 0.05, true},
+		{"constant seasonal", 48, constant, ForecastSeasonal, 24 * time.Hour, 0.05, true},
+		// the line through the samples extended 24 hours after the 48th
+		{"linear trend", 48, linear, ForecastTrend, 24 * time.Hour, 0.01 + 0.001*71, true},
+		{"linear trend at the end", 48, linear, ForecastTrend, 0, 0.01 + 0.001*48, true},
+		// the hours of the day are added back at the target
+		{"periodic seasonal first half", 72, periodic, ForecastSeasonal, time.Hour, 0.06, true},
+		{"periodic seasonal second half", 72, periodic, ForecastSeasonal, 13 * time.Hour, 0.04, true},
+		{"periodic seasonal a day ahead", 72, periodic, ForecastSeasonal, 24 * time.Hour, 0.04, true},
+		// a falling line is never below 0
+		{"falling trend", 48, falling, ForecastTrend, 48 * time.Hour, 0, true},
+	}
+	for _, c := range cases {
+		prices, window := hourlySeries(c.hours, c.price)
+		opts := DefaultForecastOptions()
+		opts.Model = c.model
+		opts.Horizon = c.horizon
+		forecast := ForecastPrice(prices, window, opts)
+
+		if forecast.Model != c.model || forecast.Time != window.End.Add(c.horizon).Format(TimeLayout) {
+			t.Errorf("%s: forecast of %s at %s", c.name, forecast.Model, forecast.Time)
+		}
+		if !closeTo(forecast.Price, c.want) {
+			t.Errorf("%s: price is %g, want %g", c.name, forecast.Price, c.want)
+		}
+		if c.exact && (!closeTo(forecast.Lower, forecast.Price) || !closeTo(forecast.Upper, forecast.Price)) {
+			t.Errorf("%s: band is [%g,%g] around an exact fit %g", c.name, forecast.Lower, forecast.Upper, forecast.Price)
+		}
+	}
+}
+
+func TestForecastPriceBand(t *testing.T) {
+	// noise around 0.05 widens the band, further ahead more
+	noisy := func(hour int) float64 { return 0.05 + 0.005*math.Sin(float64(hour)*1.7) }
+	prices, window := hourlySeries(96, noisy)
+	for _, model := range []string{ForecastEWMA, ForecastTrend, ForecastSeasonal} {
+		opts := DefaultForecastOptions()
+		opts.Model = model
+		opts.Horizon = time.Hour
+		near := ForecastPrice(prices, window, opts)
+		opts.Horizon = 72 * time.Hour
+		far := ForecastPrice(prices, window, opts)
+
+		if !(near.Lower < near.Price && near.Price < near.Upper) {
+			t.Errorf("%s: price %g is not within its band [%g,%g]", model, near.Price, near.Lower, near.Upper)
+		}
+		if far.Upper-far.Lower <= near.Upper-near.Lower {
+			t.Errorf("%s: band of 72h %g is not wider than of 1h %g", model, far.Upper-far.Lower, near.Upper-near.Lower)
+		}
+		// a higher confidence is a wider band
+		opts.Confidence = 0.99
+		wide := ForecastPrice(prices, window, opts)
+		if wide.Upper-wide.Lower <= far.Upper-far.Lower {
+			t.Errorf("%s: band of 0.99 is not wider than of 0.9", model)
+		}
+	}
+
+	// the ewma lags a rising series, the trend follows it
+	linear := func(hour int) float64 { return 0.01 + 0.001*float64(hour) }
+	prices, window = hourlySeries(48, linear)
+	opts := DefaultForecastOptions()
+	opts.Horizon = 0
+	if ewma := ForecastPrice(prices, window, opts); ewma.Price >= linear(47) {
+		t.Errorf("ewma %g of a rising series is not below the last price %g", ewma.Price, linear(47))
+	}
+}
+
+func TestForecastPriceShortHistory(t *testing.T) {
+	at := func(hour int, price float64) ecsService.SpotPriceType {
+		return ecsService.SpotPriceType{Timestamp: forecastStart.Add(time.Duration(hour) * time.Hour).Format(TimeLayout), SpotPrice: price}
+	}
+	cases := []struct {
+		name   string
+		prices []ecsService.SpotPriceType
+		end    time.Time
+		want   float64
+		time   string
+	}{
+		{"no price", nil, forecastStart, 0, ""},
+		{"invalid timestamp", []ecsService.SpotPriceType{{Timestamp: "yesterday", SpotPrice: 0.05}}, forecastStart, 0, ""},
+		{"one point", []ecsService.SpotPriceType{at(0, 0.05)}, forecastStart, 0.05, "2026-10-12T00:00:00Z"},
+		{"two points", []ecsService.SpotPriceType{at(0, 0.05), at(1, 0.07)}, forecastStart.Add(time.Hour), 0.07, "2026-10-12T01:00:00Z"},
+		// the window ends before the last point
+		{"window before the points", []ecsService.SpotPriceType{at(0, 0.05)}, forecastStart.Add(-time.Hour), 0.05, "2026-10-12T00:00:00Z"},
+		// the last price holds until the end of the window
+		{"window after the point", []ecsService.SpotPriceType{at(0, 0.05)}, forecastStart.Add(48 * time.Hour), 0.05, "2026-10-14T00:00:00Z"},
+	}
+	for _, model := range []string{ForecastEWMA, ForecastTrend, ForecastSeasonal} {
+		for _, c := range cases {
+			opts := DefaultForecastOptions()
+			opts.Model = model
+			forecast := ForecastPrice(c.prices, PriceWindow{Start: forecastStart, End: c.end}, opts)
+			if forecast.Time != c.time {
+				t.Errorf("%s %s: forecast at %q, want %q", model, c.name, forecast.Time, c.time)
+			}
+			// ewma of two samples moves a tenth of the way
+			want := c.want
+			if c.name == "two points" && model != ForecastTrend {
+				want = 0.05 + 0.1*(0.07-0.05)
+			}
+			if !closeTo(forecast.Price, want) || forecast.Lower > forecast.Price || forecast.Upper < forecast.Price {
+				t.Errorf("%s %s: forecast %g in [%g,%g], want %g", model, c.name, forecast.Price, forecast.Lower, forecast.Upper, want)
+			}
+		}
+	}
+}
+
+func TestForecastEWMA(t *testing.T) {
+	cases := []struct {
+		samples []float64
+		steps   float64
+		alpha   float64
+		level   float64
+		spread  float64
+	}{
+		{[]float64{1}, 1, 0.5, 1, 0},
+		{[]float64{1, 2}, 1, 0.5, 1.5, 1},
+		// errors 1 and 0.5, sigma of sqrt(1.25/2)
+		{[]float64{1, 2, 2}, 1, 0.5, 1.75, math.Sqrt(0.625)},
+		{[]float64{1, 2}, 5, 0.5, 1.5, math.Sqrt(1 + 4*0.25)},
+		{[]float64{1, 2}, 1, 1, 2, 1},
+	}
+	for _, c := range cases {
+		level, spread := forecastEWMA(c.samples, c.steps, c.alpha)
+		if !closeTo(level, c.level) || !closeTo(spread, c.spread) {
+			t.Errorf("ewma of %v: %g,%g, want %g,%g", c.samples, level, spread, c.level, c.spread)
+		}
+	}
+}
+
+func TestForecastTrend(t *testing.T) {
+	cases := []struct {
+		samples []float64
+		steps   float64
+		price   float64
+		spread  float64
+	}{
+		{[]float64{1, 2, 3}, 1, 4, 0},
+		{[]float64{3, 2, 1, 0}, 2, -2, 0},
+		// too short for a line
+		{[]float64{5, 6}, 10, 6, 0},
+		{[]float64{5}, 10, 5, 0},
+	}
+	for _, c := range cases {
+		price, spread := forecastTrend(c.samples, c.steps)
+		if !closeTo(price, c.price) || !closeTo(spread, c.spread) {
+			t.Errorf("trend of %v: %g,%g, want %g,%g", c.samples, price, spread, c.price, c.spread)
+		}
+	}
+}
+
+func TestNewSeasons(t *testing.T) {
+	// a day of samples is not enough for the hours
+	prices, window := hourlySeries(47, func(hour int) float64 { return float64(hour % 24) })
+	points := parsePricePoints(prices)
+	times, samples := hourlySamples(points, window.End)
+	if s := newSeasons(times, samples); s != (seasons{}) {
+		t.Errorf("seasons of 47 hours are %v", s)
+	}
+
+	// two weeks of a price 1 higher on sundays
+	prices, window = hourlySeries(14*24, func(hour int) float64 {
+		if forecastStart.Add(time.Duration(hour)*time.Hour).Weekday() == time.Sunday {
+			return 2
+		}
+		return 1
+	})
+	times, samples = hourlySamples(parsePricePoints(prices), window.End)
+	s := newSeasons(times, samples)
+	for hour, value := range s.hours {
+		if !closeTo(value, 0) {
+			t.Errorf("hour %d is %g off the mean", hour, value)
+		}
+	}
+	if diff := s.weekdays[time.Sunday] - s.weekdays[time.Monday]; !closeTo(diff, 1) {
+		t.Errorf("sunday is %g above monday, want 1", diff)
+	}
+}
+
+func TestForecastOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(opts *ForecastOptions)
+		err  string
+	}{
+		{"defaults", func(opts *ForecastOptions) {}, ""},
+		{"model", func(opts *ForecastOptions) { opts.Model = "arima" }, "unknown forecast model arima"},
+		{"horizon", func(opts *ForecastOptions) { opts.Horizon = -time.Hour }, "forecast horizon -1h0m0s is negative"},
+		{"alpha zero", func(opts *ForecastOptions) { opts.Alpha = 0 }, "forecast alpha 0 is not within (0,1]"},
+		{"alpha one", func(opts *ForecastOptions) { opts.Alpha = 1 }, ""},
+		{"confidence", func(opts *ForecastOptions) { opts.Confidence = 1 }, "forecast confidence 1 is not within (0,1)"},
+	}
+	for _, c := range cases {
+		opts := DefaultForecastOptions()
+		c.edit(&opts)
+		err := opts.Validate()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+		}
+	}
+}
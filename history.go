@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io"
+	"sort"
+	"time"
+)
+
+// levels of the sparkline from the lowest price to the highest
+const sparkLevels = "_.-~=+*#"
+
+// a point of the price history
+type HistoryPoint struct {
+	Timestamp   string  `json:"Timestamp"`
+	SpotPrice   float64 `json:"SpotPrice"`
+	OriginPrice float64 `json:"OriginPrice"`
+}
+
+// a change of the spot price, Change is the fraction of the price before
+type PriceChange struct {
+	Timestamp string  `json:"Timestamp"`
+	From      float64 `json:"From"`
+	To        float64 `json:"To"`
+	Change    float64 `json:"Change"`
+}
+
+// the price history of one pool over the window
+type PoolHistory struct {
+	InstanceTypeId string  `json:"InstanceTypeId"`
+	RegionId       string  `json:"RegionId"`
+	ZoneId         string  `json:"ZoneId"`
+	OriginPrice    float64 `json:"OriginPrice"`
+	Min            float64 `json:"Min"`
+	Max            float64 `json:"Max"`
+	// time weighted mean of the spot price over the window
+	Avg       float64        `json:"Avg"`
+	Forecast  Forecast       `json:"Forecast"`
+	Sparkline string         `json:"Sparkline"`
+	Points    []HistoryPoint `json:"Points"`
+	Changes   []PriceChange  `json:"Changes"`
+}
+
+// NewPoolHistories splits the price history of the region into pools, ordered by instanceType and zone.
+// The sparkline of every pool is width characters over the window.
+func NewPoolHistories(region string, historyPrices map[string][]ecsService.SpotPriceType, window PriceWindow, width int, forecast ForecastOptions) []PoolHistory {
+	histories := make([]PoolHistory, 0)
+	for instanceTypeId, prices := range historyPrices {
+		zones := make(map[string][]ecsService.SpotPriceType)
+		for _, price := range prices {
+			zones[price.ZoneId] = append(zones[price.ZoneId], price)
+		}
+		for zoneId, zonePrices := range zones {
+			history := NewPoolHistory(zonePrices, window, width, forecast)
+			history.InstanceTypeId = instanceTypeId
+			history.RegionId = region
+			history.ZoneId = zoneId
+			histories = append(histories, history)
+		}
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		a, b := histories[i], histories[j]
+		if a.InstanceTypeId != b.InstanceTypeId {
+			return a.InstanceTypeId < b.InstanceTypeId
+		}
+		if a.RegionId != b.RegionId {
+			return a.RegionId < b.RegionId
+		}
+		return a.ZoneId < b.ZoneId
+	})
+	return histories
+}
+
+// NewPoolHistory summarizes the prices of one pool, the points without a valid timestamp are dropped.
+// The forecast is left out without a model.
+func NewPoolHistory(prices []ecsService.SpotPriceType, window PriceWindow, width int, forecast ForecastOptions) PoolHistory {
+	history := PoolHistory{Points: make([]HistoryPoint, 0), Changes: make([]PriceChange, 0)}
+
+	points := parsePricePoints(prices)
+	if len(points) == 0 {
+		return history
+	}
+
+	history.Min, history.Max = points[0].price, points[0].price
+	for i, point := range points {
+		history.Points = append(history.Points, HistoryPoint{
+			Timestamp:   point.time.UTC().Format(TimeLayout),
+			SpotPrice:   point.price,
+			OriginPrice: point.origin,
+		})
+		if point.price < history.Min {
+			history.Min = point.price
+		}
+		if point.price > history.Max {
+			history.Max = point.price
+		}
+		if i > 0 && point.price != points[i-1].price {
+			change := PriceChange{Timestamp: point.time.UTC().Format(TimeLayout), From: points[i-1].price, To: point.price}
+			if change.From > 0 {
+				change.Change = change.To/change.From - 1
+			}
+			history.Changes = append(history.Changes, change)
+		}
+	}
+	history.OriginPrice = points[len(points)-1].origin
+	history.Avg = CalculatePriceStats(prices, AnalysisOptions{Window: window}).Mean
+	history.Sparkline = Sparkline(points, window, width)
+	if forecast.Model != "" {
+		history.Forecast = ForecastPrice(prices, window, forecast)
+	}
+	return history
+}
+
+// Sparkline draws the prices as a step function over the window in width characters,
+// every character shows the highest price of its slice of the window and is blank before the first point.
+func Sparkline(points []pricePoint, window PriceWindow, width int) string {
+	if len(points) == 0 || width <= 0 || window.Duration() <= 0 {
+		return ""
+	}
+	min, max := points[0].price, points[0].price
+	for _, point := range points {
+		if point.price < min {
+			min = point.price
+		}
+		if point.price > max {
+			max = point.price
+		}
+	}
+
+	line := make([]byte, width)
+	step := window.Duration() / time.Duration(width)
+	next := 0
+	current := -1.0
+	for i := range line {
+		end := window.Start.Add(step * time.Duration(i+1))
+		high := current
+		for next < len(points) && points[next].time.Before(end) {
+			current = points[next].price
+			if current > high {
+				high = current
+			}
+			next++
+		}
+		switch {
+		case high < 0:
+			line[i] = ' '
+		case max == min:
+			line[i] = '-'
+		default:
+			line[i] = sparkLevels[int((high-min)/(max-min)*float64(len(sparkLevels)-1)+0.5)]
+		}
+	}
+	return string(line)
+}
+
+// WriteHistory renders the pools as a table or json, the table lists every point with points or only the changes.
+func WriteHistory(w io.Writer, histories []PoolHistory, format string, points bool) error {
+	switch format {
+	case OutputTable, "":
+		for index, h := range histories {
+			if index > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s %s %s\n", h.InstanceTypeId, h.RegionId, h.ZoneId)
+			fmt.Fprintf(w, "  min %.4f  max %.4f  avg %.4f  origin %.4f  %d changes\n", h.Min, h.Max, h.Avg, h.OriginPrice, len(h.Changes))
+			fmt.Fprintf(w, "  |%s|\n", h.Sparkline)
+			if h.Forecast.Model != "" {
+				fmt.Fprintf(w, "  forecast %s %.4f [%.4f, %.4f] by %s\n", h.Forecast.Model, h.Forecast.Price, h.Forecast.Lower, h.Forecast.Upper, h.Forecast.Time)
+			}
+			if points {
+				for _, point := range h.Points {
+					fmt.Fprintf(w, "  %22s %10.4f\n", point.Timestamp, point.SpotPrice)
+				}
+				continue
+			}
+			for _, change := range h.Changes {
+				fmt.Fprintf(w, "  %22s %10.4f -> %.4f %+7.1f%%\n", change.Timestamp, change.From, change.To, 100*change.Change)
+			}
+		}
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(histories)
+	}
+	return fmt.Errorf("unknown output format %s of history, use one of table,json", format)
+}
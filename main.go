@@ -1,42 +1,676 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
 	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-)
-
-var (
-	accessKeyId     = flag.String("accessKeyId", "", "Your accessKeyId of cloud account")
-	accessKeySecret = flag.String("accessKeySecret", "", "Your accessKeySecret of cloud account")
-	region          = flag.String("region", "cn-hangzhou", "The region of spot instances")
-	cpu             = flag.Int("mincpu", 1, "Min cores of spot instances")
-	memory          = flag.Int("minmem", 2, "Min memory of spot instances")
-	maxCpu          = flag.Int("maxcpu", 32, "Max cores of spot instances ")
-	maxMemory       = flag.Int("maxmem", 64, "Max memory of spot instances")
-	family          = flag.String("family", "", "The spot instance family you want (e.g. ecs.n1,ecs.n2)")
-	cutoff          = flag.Int("cutoff", 2, "Discount of the spot instance prices")
-	limit           = flag.Int("limit", 20, "Limit of the spot instances")
-	resolution      = flag.Int("resolution", 7, "The window of price history analysis")
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runFlat(args)
+		return
+	}
+
+	command := FindCommand(args[0])
+	if command == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", args[0])
+		PrintCommands(os.Stderr)
+		os.Exit(2)
+	}
+	command.Execute(args[1:])
+}
+
+// runFlat ranks with every flag on the command line and no command, -apg and -portfolio choose what is printed.
+func runFlat(args []string) {
+	known := knownFlags()
+	for _, group := range flagGroups {
+		group(flag.CommandLine)
+	}
+	flag.Usage = func() {
+		PrintCommands(flag.CommandLine.Output())
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	parseFlags(flag.CommandLine, known, args)
+
+	regions := resolveRegions()
+	if *apg && len(regions) > 1 {
+		panic(fmt.Sprintf("Failed to build auto provisioning group,because it belongs to one region but %d are given", len(regions)))
+	}
+
+	sortedInstancePrices := rankRegions(regions)
+
+	if *portfolio {
+		printPortfolio(sortedInstancePrices)
+		return
+	}
+
+	if *apg {
+		printAPG(newEcsClient(regions[0]), sortedInstancePrices)
+		return
+	}
+
+	printRank(sortedInstancePrices)
+}
+
+// runRank prints the rank of the regions.
+func runRank(args []string) {
+	printRank(rankRegions(resolveRegions()))
+}
+
+// runPortfolio prints the portfolio built from the rank of the regions.
+func runPortfolio(args []string) {
+	printPortfolio(rankRegions(resolveRegions()))
+}
+
+// runBid prints the recommended price limit of the ranked pools of the regions.
+func runBid(args []string) {
+	if err := PrintBids(os.Stdout, rankRegions(resolveRegions()), *limit, *output); err != nil {
+		panic(fmt.Sprintf("Failed to print the price limits,because of %v", err))
+	}
+}
+
+// runExportAPG prints and optionally creates the auto provisioning group of the region.
+func runExportAPG(args []string) {
+	regions := resolveRegions()
+	if len(regions) > 1 {
+		panic(fmt.Sprintf("Failed to build auto provisioning group,because it belongs to one region but %d are given", len(regions)))
+	}
+	printAPG(newEcsClient(regions[0]), rankRegions(regions))
+}
+
+// runTypes lists the filtered instanceTypes of the regions.
+func runTypes(args []string) {
+	filter := newInstanceFilter()
+	records := make([]TypeRecord, 0)
+	for _, region := range resolveRegions() {
+		metastore := NewMetaStore(newEcsClient(region))
+		metastore.Initialize(region)
+		records = append(records, metastore.ListTypes(filter)...)
+	}
+	if err := WriteTypes(os.Stdout, records, *output); err != nil {
+		panic(fmt.Sprintf("Failed to print instanceTypes,because of %v", err))
+	}
+}
+
+// runZones lists the zones of the regions with the stock of the filtered instanceTypes.
+func runZones(args []string) {
+	filter := newInstanceFilter()
+	records := make([]ZoneRecord, 0)
+	for _, region := range resolveRegions() {
+		metastore := NewMetaStore(newEcsClient(region))
+		metastore.Initialize(region)
+		records = append(records, metastore.ListZones(filter)...)
+	}
+	if err := WriteZones(os.Stdout, records, *output); err != nil {
+		panic(fmt.Sprintf("Failed to print zones,because of %v", err))
+	}
+}
 
-	client, err := ecsService.NewClientWithAccessKey(*region, *accessKeyId, *accessKeySecret)
+// runHistory prints the price history of the instanceTypes in the regions.
+func runHistory(args []string) {
+	if len(args) == 0 {
+		panic("Failed to print price history,because no instanceType is given")
+	}
+	window, err := NewPriceWindow(*start, *end, *resolution)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create ecs client,because of %v", err))
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+
+	histories := make([]PoolHistory, 0)
+	for _, region := range resolveRegions() {
+		metastore := newMetaStore(region, window, DefaultRankModel(), nil)
+		instanceTypes := make([]string, 0, len(args))
+		for _, instanceType := range args {
+			if _, ok := metastore.InstanceFamilyCache[instanceType]; !ok {
+				log.Warnf("InstanceType %s is not offered in %s", instanceType, region)
+				continue
+			}
+			instanceTypes = append(instanceTypes, instanceType)
+		}
+		historyPrices := metastore.FetchSpotPrices(instanceTypes, window)
+		histories = append(histories, NewPoolHistories(region, historyPrices, window, *sparkWidth, metastore.AnalysisOptions.Forecast)...)
+	}
+	if err := WriteHistory(os.Stdout, histories, *output, *allPoints); err != nil {
+		panic(fmt.Sprintf("Failed to print price history,because of %v", err))
+	}
+}
+
+// runBacktest replays the price history of the window through the strategy.
+func runBacktest(args []string) {
+	window, err := NewPriceWindow(*start, *end, *resolution)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+	newAnalysisOptions(window)
+	if *bid < 0 {
+		panic(fmt.Sprintf("Failed to backtest,because bid %g is negative", *bid))
 	}
 
+	var strategy Strategy
+	switch *strategyName {
+	case StrategyTop, StrategyTypes:
+		strategy = TopStrategy{Pools: *strategyPools, Distinct: *strategyName == StrategyTypes, TargetCpu: *targetCpu}
+	case StrategyPortfolio:
+		strategy = PortfolioStrategy{Options: newPortfolioOptions()}
+	default:
+		panic(fmt.Sprintf("Failed to backtest,because of unknown strategy %s, use one of top,types,portfolio", *strategyName))
+	}
+
+	model := newRankModel()
+	filter := newInstanceFilter()
+	backtest := &Backtest{
+		MetaStores: make(map[string]*MetaStore),
+		Prices:     make(map[string]map[string][]ecsService.SpotPriceType),
+		Window:     window,
+		Lookback:   *lookback,
+		Rebalance:  *rebalance,
+		Model:      model,
+		Strategy:   strategy,
+		Bid:        *bid,
+	}
+	// the history reaches back the lookback of the first rebalance
+	history := PriceWindow{Start: window.Start.Add(-*lookback), End: window.End}
+	for _, region := range resolveRegions() {
+		metastore := newMetaStore(region, history, model, filter.Where)
+		backtest.MetaStores[region] = metastore
+		backtest.Prices[region] = metastore.FetchSpotPrices(metastore.FilterInstances(filter), history)
+	}
+
+	report, err := backtest.Run()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to backtest,because of %v", err))
+	}
+	if err := report.Write(os.Stdout, *output); err != nil {
+		panic(fmt.Sprintf("Failed to print backtest,because of %v", err))
+	}
+}
+
+// rankRegions analyzes the filtered pools of the regions and ranks them with the model of the flags.
+func rankRegions(regions []string) SortedInstancePrices {
+	window, err := NewPriceWindow(*start, *end, *resolution)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+	// check the analysis flags before the regions are scanned concurrently
+	newAnalysisOptions(window)
+
+	model := newRankModel()
+	newTCOOptions(model)
+	filter := newInstanceFilter()
+
+	prices, _, err := scanPrices(regions, window, model, filter)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to scan regions,because of %v", err))
+	}
+	return prices
+}
+
+func printRank(prices SortedInstancePrices) {
+	err := PrintPriceRank(os.Stdout, prices, *cutoff, *limit, *output, *riskMetric)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to print the price rank,because of %v", err))
+	}
+}
+
+// parseFlags parses the command line and fills the flags it does not give from
+// the environment and the config file, whose keys have to be known flags.
+func parseFlags(fs *flag.FlagSet, known *flag.FlagSet, args []string) {
+	fs.Parse(args)
+
+	path, profile := *configPath, *configProfile
+	if path == "" {
+		path = os.Getenv(ConfigEnvName("config"))
+	}
+	if profile == "" {
+		profile = os.Getenv(ConfigEnvName("config-profile"))
+	}
+
+	var config *Config
+	if path != "" {
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to load config,because of %v", err))
+		}
+		config = loaded
+	} else if profile != "" {
+		panic(fmt.Sprintf("Failed to load config profile %s,because -config is not given", profile))
+	}
+
+	if err := config.Apply(fs, known, profile, "config", "config-profile"); err != nil {
+		panic(fmt.Sprintf("Failed to apply config,because of %v", err))
+	}
+}
+
+// newInstanceFilter reads the filter flags.
+func newInstanceFilter() InstanceFilter {
+	filter := InstanceFilter{
+		MinCpu:             *cpu,
+		MaxCpu:             *maxCpu,
+		MinMemory:          float64(*memory),
+		MaxMemory:          float64(*maxMemory),
+		Family:             SplitList(*family),
+		ExactFamily:        *exactFamily,
+		ExcludeFamily:      SplitList(*excludeFamily),
+		Generation:         SplitList(*generation),
+		FamilyLevel:        SplitList(*familyLevel),
+		ExcludeFamilyLevel: SplitList(*excludeLevel),
+		MinGPU:             *minGPU,
+		MaxGPU:             *maxGPU,
+		GPUSpec:            *gpuSpec,
+		LocalStorage:       *localStorage,
+		MinEni:             *minEni,
+		MinBandwidth:       *minBandwidth,
+		MinPps:             *minPps,
+		MinMemoryRatio:     *minMemoryRatio,
+		MaxMemoryRatio:     *maxMemoryRatio,
+	}
+	if *where != "" {
+		expr, err := ParseExpr(*where)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to parse -where,because of %v", err))
+		}
+		filter.Where = expr
+	}
+	if err := filter.Validate(); err != nil {
+		panic(fmt.Sprintf("Failed to parse the instance filter,because of %v", err))
+	}
+	return filter
+}
+
+// scanPrices analyzes the filtered instanceTypes of every region and scores them together.
+func scanPrices(regions []string, window PriceWindow, model RankModel, filter InstanceFilter) (SortedInstancePrices, []string, error) {
+	prices, scanned, err := ScanRegions(regions, func(region string) (SortedInstancePrices, error) {
+		metastore := newMetaStore(region, window, model, filter.Where)
+
+		instanceTypes := metastore.FilterInstances(filter)
+
+		historyPrices := metastore.FetchSpotPrices(instanceTypes, window)
+
+		return metastore.SpotPricesAnalysis(historyPrices)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// score again over all regions so the composite scales are global
+	if err := model.Score(prices); err != nil {
+		return nil, nil, err
+	}
+	return prices, scanned, nil
+}
+
+// newMetaStore builds the initialized metastore of the region from the flags.
+func newMetaStore(region string, window PriceWindow, model RankModel, where *Expr) *MetaStore {
+	client := newEcsClient(region)
+	if *store != "" {
+		client = NewStoreClient(client, *store, region)
+	}
 	metastore := NewMetaStore(client)
+	metastore.RankModel = model
+	metastore.ShowUnavailable = *showUnavailable
+	metastore.Where = where
+	metastore.FetchOptions.Concurrency = *concurrency
+	metastore.FetchOptions.QPS = *qps
+	metastore.FetchOptions.Retries = *retries
+	metastore.AnalysisOptions = newAnalysisOptions(window)
+	metastore.TCO = newTCOOptions(model)
 
-	metastore.Initialize(*region)
+	metastore.Initialize(region)
+	return metastore
+}
 
-	instanceTypes := metastore.FilterInstances(*cpu, *memory, *maxCpu, *maxMemory, *family)
+// newAnalysisOptions reads the risk, forecast and bid flags for the window.
+func newAnalysisOptions(window PriceWindow) AnalysisOptions {
+	if _, err := (PriceStats{}).Risk(*riskMetric); err != nil {
+		panic(fmt.Sprintf("Failed to parse risk metric,because of %v", err))
+	}
+	forecast := ForecastOptions{
+		Model:      *forecastModel,
+		Horizon:    *forecastHorizon,
+		Alpha:      *forecastAlpha,
+		Confidence: *forecastConf,
+	}
+	if err := forecast.Validate(); err != nil {
+		panic(fmt.Sprintf("Failed to parse forecast,because of %v", err))
+	}
+	bid := BidOptions{
+		Coverage: *bidCoverage,
+		GoRatio:  *bidGoRatio,
+	}
+	if err := bid.Validate(); err != nil {
+		panic(fmt.Sprintf("Failed to parse bid,because of %v", err))
+	}
+	return AnalysisOptions{
+		Window:        window,
+		RiskMetric:    *riskMetric,
+		RiskThreshold: *riskThreshold,
+		Forecast:      forecast,
+		Bid:           bid,
+	}
+}
+
+// newTCOOptions reads the bill of a node, nil without -tco.
+func newTCOOptions(model RankModel) *TCOOptions {
+	if !*tco {
+		if key := model.CostKey(); key != "" {
+			panic(fmt.Sprintf("Failed to rank by %s cost,because it needs -tco", key))
+		}
+		return nil
+	}
+
+	disks, err := ParseDataDisks(*dataDisks)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse data disks,because of %v", err))
+	}
+	rates, err := ParseRates(*exchangeRates)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse exchange rates,because of %v", err))
+	}
+	bill := NodeBill{
+		SystemDiskCategory: *systemDiskCategory,
+		SystemDiskSize:     *systemDiskSize,
+		DataDisks:          disks,
+		InternetChargeType: *internetChargeType,
+		Bandwidth:          *bandwidth,
+		ImageId:            *imageId,
+		TrafficGB:          *traffic,
+	}
+	if err := bill.Validate(); err != nil {
+		panic(fmt.Sprintf("Failed to parse the bill of a node,because of %v", err))
+	}
+	return &TCOOptions{Bill: bill, Currency: *currency, Rates: rates}
+}
+
+// runServer serves the rank of the regions over http.
+func runServer(args []string) {
+	window, err := NewPriceWindow(*start, *end, *resolution)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+	newAnalysisOptions(window)
+	model := newRankModel()
+
+	server := &Server{
+		Regions: resolveRegions(),
+		Refresh: *refresh,
+		Defaults: RankQuery{
+			Filter: newInstanceFilter(),
+			Cutoff: *cutoff,
+			Limit:  *limit,
+			Model:  model,
+			Output: OutputJSON,
+			TCO:    *tco,
+		},
+		RiskMetric: *riskMetric,
+		Exporter:   &Exporter{},
+		NewMetaStore: func(region string) *MetaStore {
+			// the window slides with every refresh, the where of the query is applied by the server
+			window, _ := NewPriceWindow(*start, *end, *resolution)
+			return newMetaStore(region, window, model, nil)
+		},
+	}
+
+	stop := stopOnSignal()
+
+	go server.Run(stop)
+	if err := server.ListenAndServe(*listen, stop); err != nil {
+		panic(fmt.Sprintf("Failed to serve,because of %v", err))
+	}
+}
+
+// runWatcher ranks on every interval and notifies the webhooks of the alert rules.
+func runWatcher(args []string) {
+	if *rules == "" {
+		panic("Failed to watch,because -rules is not given")
+	}
+	alertRules, err := LoadAlertRules(*rules)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load alert rules,because of %v", err))
+	}
+	window, err := NewPriceWindow(*start, *end, *resolution)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+	newAnalysisOptions(window)
+	model := newRankModel()
+	filter := newInstanceFilter()
+	regions := resolveRegions()
+	watcher := &Watcher{Rules: alertRules, Regions: regions}
+
+	stop := stopOnSignal()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		// the window slides with every evaluation
+		window, _ := NewPriceWindow(*start, *end, *resolution)
+		prices, scanned, err := scanPrices(regions, window, model, filter)
+		if err != nil {
+			log.Warnf("Failed to scan regions,because of %v", err)
+		} else {
+			watcher.Notify(prices, scanned, time.Now())
+		}
+
+		if *once {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopOnSignal is closed on the first interrupt or terminate signal.
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	return stop
+}
+
+// runRecorder polls the prices of the filtered instanceTypes into the store.
+func runRecorder(args []string) {
+	if *store == "" {
+		panic("Failed to record prices,because -store is not given")
+	}
+	window, err := NewPriceWindow(*start, *end, *resolution)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse the window of price history,because of %v", err))
+	}
+	filter := newInstanceFilter()
+
+	recorder := &Recorder{
+		Store:    &PriceStore{Dir: *store},
+		Regions:  resolveRegions(),
+		Interval: *interval,
+		First:    window,
+		InstanceTypes: func(region string) []string {
+			metastore := NewMetaStore(newEcsClient(region))
+			metastore.Initialize(region)
+			return metastore.FilterInstances(filter)
+		},
+		Fetch: func(region string, instanceTypes []string, window PriceWindow) map[string][]ecsService.SpotPriceType {
+			metastore := NewMetaStore(newEcsClient(region))
+			metastore.FetchOptions.Concurrency = *concurrency
+			metastore.FetchOptions.QPS = *qps
+			metastore.FetchOptions.Retries = *retries
+			metastore.Region = region
+			return metastore.FetchSpotPrices(instanceTypes, window)
+		},
+	}
+
+	if *once {
+		recorder.RecordAll(window.End)
+		return
+	}
+
+	stop := stopOnSignal()
+	fmt.Fprintf(os.Stderr, "Record prices of %d regions into %s every %s\n", len(recorder.Regions), *store, *interval)
+	recorder.Run(stop)
+}
 
-	historyPrices := metastore.FetchSpotPrices(instanceTypes, *resolution)
+func resolveRegions() []string {
+	var regionClient EcsClient
+	if *region == AllRegions {
+		regionClient = newEcsClient(DefaultRegion)
+	}
+	regions, err := ResolveRegions(*region, regionClient)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to resolve regions,because of %v", err))
+	}
+	return regions
+}
+
+func newPortfolioOptions() PortfolioOptions {
+	return PortfolioOptions{
+		TargetCpu:    *targetCpu,
+		TargetMemory: *targetMemory,
+		MaxShare:     *maxShare,
+		MinFamilies:  *minFamilies,
+		MinZones:     *minZones,
+		MaxRisk:      *maxRisk,
+	}
+}
+
+func printPortfolio(prices SortedInstancePrices) {
+	p, err := OptimizePortfolio(prices, newPortfolioOptions())
+	if err != nil {
+		panic(fmt.Sprintf("Failed to optimize portfolio,because of %v", err))
+	}
+	if err := p.Write(os.Stdout, *output); err != nil {
+		panic(fmt.Sprintf("Failed to print portfolio,because of %v", err))
+	}
+}
 
-	sortedInstancePrices := metastore.SpotPricesAnalysis(historyPrices)
+func printAPG(client EcsClient, prices SortedInstancePrices) {
+	vswitches, err := ParseVSwitches(*apgVSwitches)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse vswitches,because of %v", err))
+	}
+
+	spec, err := BuildAPGSpec(prices, APGOptions{
+		Name:                   *apgName,
+		LaunchTemplateId:       *launchTemplate,
+		LaunchTemplateVersion:  *launchVersion,
+		TotalTargetCapacity:    *apgCapacity,
+		SpotAllocationStrategy: *apgStrategy,
+		Weight:                 *apgWeight,
+		MaxSpotPrice:           *apgMaxPrice,
+		UseBid:                 *apgBid,
+		Top:                    *apgTop,
+		VSwitches:              vswitches,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to build auto provisioning group,because of %v", err))
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(spec); err != nil {
+		panic(fmt.Sprintf("Failed to print auto provisioning group,because of %v", err))
+	}
+
+	if !*apgApply && !*apgDryRun {
+		return
+	}
 
-	metastore.PrintPriceRank(sortedInstancePrices, *cutoff, *limit)
+	pc, ok := client.(ProvisioningClient)
+	if !ok {
+		panic("Failed to create auto provisioning group,because the client can not CreateAutoProvisioningGroup")
+	}
+	id, err := CreateAPG(pc, spec, *apgDryRun)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create auto provisioning group,because of %v", err))
+	}
+	if *apgDryRun {
+		fmt.Fprintf(os.Stderr, "Auto provisioning group %s passes the dry run of the api, nothing is created.\n", spec.AutoProvisioningGroupName)
+	} else {
+		fmt.Fprintf(os.Stderr, "Create auto provisioning group %s successfully.\n", id)
+	}
+}
+
+func newRankModel() RankModel {
+	if *rankConfig != "" {
+		model, err := LoadRankModel(*rankConfig)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to load rank model,because of %v", err))
+		}
+		return model
+	}
+
+	weights, err := ParseWeights(*sortWeights)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse sort weights,because of %v", err))
+	}
+	model := RankModel{Key: *sortKey, Weights: weights}
+	if err := model.Validate(); err != nil {
+		panic(fmt.Sprintf("Failed to parse sort key,because of %v", err))
+	}
+	return model
+}
+
+var (
+	credential     *ResolvedCredential
+	credentialOnce sync.Once
+)
+
+// resolveCredential walks the credential chain once for all the regions.
+func resolveCredential() *ResolvedCredential {
+	credentialOnce.Do(func() {
+		resolved, err := ResolveCredential(CredentialOptions{
+			AccessKeyId:     *accessKeyId,
+			AccessKeySecret: *accessKeySecret,
+			Profile:         *profile,
+			ConfigPath:      *aliyunConfig,
+			RoleArn:         *roleArn,
+			RoleSessionName: *roleSession,
+			MetadataTimeout: time.Second,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("Failed to resolve credential,because of %v", err))
+		}
+		log.Infof("Using credential from %s", resolved)
+		credential = resolved
+	})
+	return credential
+}
+
+func newEcsClient(region string) EcsClient {
+	if *replay != "" {
+		return NewFakeClient(*replay, region)
+	}
+
+	client, err := ecsService.NewClientWithOptions(region, sdk.NewConfig(), resolveCredential().Credential)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create ecs client,because of %v", err))
+	}
+
+	if *record != "" {
+		return NewRecordingClient(client, *record, region)
+	}
+	if !*noCache && *cacheDir != "" {
+		return NewCachingClient(client, *cacheDir, region, CacheTTL{
+			InstanceTypes: *typesTTL,
+			Stock:         *stockTTL,
+			Prices:        *priceTTL,
+		})
+	}
+	return client
 }
@@ -2,24 +2,44 @@ package main
 
 import (
 	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-	"github.com/fatih/color"
-	"sort"
-	"strings"
-	"time"
+	"os"
+	"sync"
 )
 
 const (
 	TimeLayout = "2006-01-02T15:04:05Z"
+
+	// guard against an api that never stops paging
+	MaxSpotPricePages = 1000
 )
 
 type MetaStore struct {
-	*ecsService.Client
+	EcsClient
+	Region              string
 	InstanceFamilyCache map[string]ecsService.InstanceType
+	// stock of every pool, instanceTypeId -> zoneId -> stock
+	ZoneStocks map[string]map[string]string
+	// keep the pools that can not be launched in the rank, flagged by their stock
+	ShowUnavailable bool
+	// the pools that do not match are left out of the rank
+	Where           *Expr
+	FetchOptions    FetchOptions
+	AnalysisOptions AnalysisOptions
+	RankModel       RankModel
+	// the cost of the nodes is added to every pool, none without it
+	TCO *TCOOptions
+	// cost of the node of every instanceType besides the instance, guarded by costLock
+	NodeCosts map[string]NodeCost
+	costLock  sync.Mutex
 }
 
 // Initialize the instance type
 func (ms *MetaStore) Initialize(region string) {
+	ms.Region = region
+
 	req := ecsService.CreateDescribeInstanceTypesRequest()
 	req.RegionId = region
 	resp, err := ms.DescribeInstanceTypes(req)
@@ -42,83 +62,116 @@ func (ms *MetaStore) Initialize(region string) {
 		panic(fmt.Sprintf("Failed to get available resource,because of %v", err))
 	}
 
-	zoneStocks := d_resp.AvailableZones.AvailableZone
-
-	for instanceTypeId := range ms.InstanceFamilyCache {
-		found := 0
-		for _, zoneStock := range zoneStocks {
-			for _, resource := range zoneStock.AvailableResources.AvailableResource[0].SupportedResources.SupportedResource {
-				if resource.Value == instanceTypeId {
-					found = 1
-					break
-				}
+	for _, zoneStock := range d_resp.AvailableZones.AvailableZone {
+		for _, available := range zoneStock.AvailableResources.AvailableResource {
+			if available.Type != "" && available.Type != "InstanceType" {
+				continue
 			}
-			if found == 1 {
-				break
+			for _, resource := range available.SupportedResources.SupportedResource {
+				if ms.ZoneStocks[resource.Value] == nil {
+					ms.ZoneStocks[resource.Value] = make(map[string]string)
+				}
+				ms.ZoneStocks[resource.Value][zoneStock.ZoneId] = StockOf(zoneStock, resource)
 			}
 		}
-		if found == 0 {
+	}
+
+	// drop the instanceTypes offered in none of the zones
+	for instanceTypeId := range ms.InstanceFamilyCache {
+		if len(ms.ZoneStocks[instanceTypeId]) == 0 {
 			delete(ms.InstanceFamilyCache, instanceTypeId)
 		}
 	}
 
-	fmt.Printf("Initialize cache ready with %d kinds of instanceTypes\n", len(instanceTypes))
+	fmt.Fprintf(os.Stderr, "Initialize cache of %s ready with %d kinds of instanceTypes\n", region, len(instanceTypes))
 }
 
 // Get the instanceType with in the range.
-func (ms *MetaStore) FilterInstances(cpu, memory, maxCpu, maxMemory int, family string) (instanceTypes []string) {
+func (ms *MetaStore) FilterInstances(filter InstanceFilter) (instanceTypes []string) {
 	instanceTypes = make([]string, 0)
 
-	instancesFamily := strings.Split(family, ",")
-
 	for key, instanceType := range ms.InstanceFamilyCache {
-		if instanceType.CpuCoreCount >= cpu && instanceType.CpuCoreCount <= maxCpu &&
-			instanceType.MemorySize >= float64(memory) && instanceType.MemorySize <= float64(maxMemory) {
-
-			for _, instanceFamily := range instancesFamily {
-				if strings.Contains(key, instanceFamily) {
-					instanceTypes = append(instanceTypes, key)
-					break
-				}
-			}
-
+		if filter.Match(instanceType) {
+			instanceTypes = append(instanceTypes, key)
 		}
 	}
 
-	fmt.Printf("Filter %d of %d kinds of instanceTypes.\n", len(instanceTypes), len(ms.InstanceFamilyCache))
+	fmt.Fprintf(os.Stderr, "Filter %d of %d kinds of instanceTypes in %s.\n", len(instanceTypes), len(ms.InstanceFamilyCache), ms.Region)
 
 	return instanceTypes
 }
 
-// Fetch spot price history
-func (ms *MetaStore) FetchSpotPrices(instanceTypes []string, resolution int) (historyPrices map[string][]ecsService.SpotPriceType) {
+// Fetch spot price history of the window, page by page
+func (ms *MetaStore) FetchSpotPrices(instanceTypes []string, window PriceWindow) (historyPrices map[string][]ecsService.SpotPriceType) {
 
 	historyPrices = make(map[string][]ecsService.SpotPriceType)
+	lock := sync.Mutex{}
+
+	results := ms.FetchOptions.Run(instanceTypes, func(instanceType string, call Caller) error {
+		prices := make([]ecsService.SpotPriceType, 0)
+		offset, pages := 0, 0
+		for {
+			req := ecsService.CreateDescribeSpotPriceHistoryRequest()
+			req.NetworkType = "vpc"
+			req.InstanceType = instanceType
+			req.IoOptimized = "optimized"
+			req.StartTime = window.StartTime()
+			req.EndTime = window.EndTime()
+			req.Offset = requests.NewInteger(offset)
+
+			var resp *ecsService.DescribeSpotPriceHistoryResponse
+			err := call(func() (err error) {
+				resp, err = ms.DescribeSpotPriceHistory(req)
+				return err
+			})
+			if err != nil {
+				return err
+			}
 
-	for _, instanceType := range instanceTypes {
-		req := ecsService.CreateDescribeSpotPriceHistoryRequest()
-		req.NetworkType = "vpc"
-		req.InstanceType = instanceType
-		req.IoOptimized = "optimized"
-		resp, err := ms.DescribeSpotPriceHistory(req)
-
-		resolutionDuration := time.Duration(resolution * -1*24) * time.Hour
-		req.StartTime = time.Now().Add(resolutionDuration).Format(TimeLayout)
-		if err != nil {
-			continue
+			// a page may have no point in the window while the later pages do,
+			// only an offset that does not move on ends the history
+			prices = append(prices, resp.SpotPrices.SpotPriceType...)
+			pages++
+			if resp.NextOffset <= offset || pages >= MaxSpotPricePages {
+				break
+			}
+			offset = resp.NextOffset
 		}
 
-		historyPrices[instanceType] = resp.SpotPrices.SpotPriceType
+		lock.Lock()
+		historyPrices[instanceType] = prices
+		lock.Unlock()
+		return nil
+	})
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			log.Warnf("Failed to fetch prices of %s after %d attempts,because of %v", result.InstanceType, result.Attempts, result.Err)
+		}
 	}
 
-	fmt.Printf("Fetch %d kinds of InstanceTypes prices successfully.\n", len(instanceTypes))
+	fmt.Fprintf(os.Stderr, "Fetch %d of %d kinds of InstanceTypes prices in %s successfully, %d failed.\n", len(instanceTypes)-failed, len(instanceTypes), ms.Region, failed)
 
 	return historyPrices
 }
 
-// Print spot history sort and rank
-func (ms *MetaStore) SpotPricesAnalysis(historyPrices map[string][]ecsService.SpotPriceType) SortedInstancePrices {
+// Print spot history sort and rank, it fails when the rank model can not score the pools
+func (ms *MetaStore) SpotPricesAnalysis(historyPrices map[string][]ecsService.SpotPriceType) (SortedInstancePrices, error) {
 	sp := make(SortedInstancePrices, 0)
+	hidden := 0
+	if ms.TCO != nil {
+		instanceTypes := make([]string, 0, len(historyPrices))
+		for instanceTypeId := range historyPrices {
+			if _, ok := ms.InstanceFamilyCache[instanceTypeId]; ok {
+				instanceTypes = append(instanceTypes, instanceTypeId)
+			}
+		}
+		ms.FetchNodeCosts(instanceTypes)
+	}
+	var costErr error
+	uncosted := 0
 	for instanceTypeId, prices := range historyPrices {
 		var meta ecsService.InstanceType
 		if m, ok := ms.InstanceFamilyCache[instanceTypeId]; !ok {
@@ -136,35 +189,61 @@ func (ms *MetaStore) SpotPricesAnalysis(historyPrices map[string][]ecsService.Sp
 		}
 
 		for zoneId, price := range priceAZMap {
-			ip := CreateInstancePrice(meta, zoneId, price)
+			ip := CreateInstancePrice(meta, zoneId, price, ms.AnalysisOptions)
+			ip.RegionId = ms.Region
+			ip.Stock = ms.ZoneStocks[instanceTypeId][zoneId]
+			if ip.Stock == "" {
+				ip.Stock = StockNotOffered
+			}
+			if !ip.Launchable() && !ms.ShowUnavailable {
+				hidden++
+				continue
+			}
+			if ms.TCO != nil {
+				node, ok := ms.NodeCost(instanceTypeId)
+				if !ok {
+					uncosted++
+					continue
+				}
+				cost, err := ms.TCO.PoolCost(ip.SpotPrice, node)
+				if err != nil {
+					costErr = err
+					uncosted++
+					continue
+				}
+				ip.Cost = cost
+			}
+			if ms.Where != nil && !ms.Where.Match(ip) {
+				continue
+			}
 			sp = append(sp, ip)
 		}
 	}
 
-	fmt.Printf("Successfully compare %d kinds of instanceTypes\n", len(sp))
-	return sp
-}
-
-func (ms *MetaStore) PrintPriceRank(prices SortedInstancePrices, cutoff int, limit int) {
-	sort.Sort(prices)
-
-	color.Green("%30s %20s %15s %15s %15s\n", "InstanceTypeId", "ZoneId", "Price(Core)", "Discount", "ratio")
+	if err := ms.RankModel.Score(sp); err != nil {
+		return nil, fmt.Errorf("failed to score the pools of %s: %v", ms.Region, err)
+	}
 
-	for index, price := range prices {
-		if index >= limit {
-			break
-		}
-		if price.Discount <= float64(cutoff) {
-			color.Green("%30s %20s %15.4f %15.1f %15.1f\n", price.InstanceTypeId, price.ZoneId, price.PricePerCore, price.Discount, price.Possibility)
-		} else {
-			color.Blue("%30s %20s %15.4f %15.1f %15.1f\n", price.InstanceTypeId, price.ZoneId, price.PricePerCore, price.Discount, price.Possibility)
-		}
+	if costErr != nil {
+		log.Warnf("Drop %d pools without node cost in %s,because of %v", uncosted, ms.Region, costErr)
+	} else if uncosted > 0 {
+		log.Warnf("Drop %d pools without node cost in %s", uncosted, ms.Region)
+	}
+	if hidden > 0 {
+		fmt.Fprintf(os.Stderr, "Hide %d pools without stock in %s\n", hidden, ms.Region)
 	}
+	fmt.Fprintf(os.Stderr, "Successfully compare %d kinds of instanceTypes in %s\n", len(sp), ms.Region)
+	return sp, nil
 }
 
-func NewMetaStore(client *ecsService.Client) *MetaStore {
+func NewMetaStore(client EcsClient) *MetaStore {
 	return &MetaStore{
-		Client:              client,
+		EcsClient:           client,
 		InstanceFamilyCache: make(map[string]ecsService.InstanceType),
+		ZoneStocks:          make(map[string]map[string]string),
+		FetchOptions:        DefaultFetchOptions(),
+		AnalysisOptions:     DefaultAnalysisOptions(),
+		RankModel:           DefaultRankModel(),
+		NodeCosts:           make(map[string]NodeCost),
 	}
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newReplayMetaStore initializes a metastore of the responses recorded in testdata.
+func newReplayMetaStore(t *testing.T, region string) *MetaStore {
+	t.Helper()
+	ms := NewMetaStore(NewFakeClient("testdata", region))
+	ms.FetchOptions.QPS = 0
+	ms.Initialize(region)
+	return ms
+}
+
+func replayWindow(t *testing.T) PriceWindow {
+	t.Helper()
+	window, err := NewPriceWindow("2026-10-11T00:00:00Z", "2026-10-18T00:00:00Z", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return window
+}
+
+func TestInitialize(t *testing.T) {
+	ms := newReplayMetaStore(t, "cn-hangzhou")
+
+	if ms.Region != "cn-hangzhou" {
+		t.Errorf("region is %s", ms.Region)
+	}
+	if len(ms.InstanceFamilyCache) != 10 {
+		t.Errorf("%d instanceTypes are cached, want 10", len(ms.InstanceFamilyCache))
+	}
+	// offered in none of the zones
+	if _, ok := ms.InstanceFamilyCache["ecs.re6.52xlarge"]; ok {
+		t.Errorf("ecs.re6.52xlarge is not offered but cached")
+	}
+
+	stocks := []struct {
+		instanceType, zone, stock string
+	}{
+		{"ecs.c6.large", "cn-hangzhou-h", StockWithStock},
+		{"ecs.c6.large", "cn-hangzhou-i", StockWithStock},
+		{"ecs.gn6i-c4g1.xlarge", "cn-hangzhou-h", StockWithoutStock},
+		// the status of the resource wins over its category
+		{"ecs.hfc6.large", "cn-hangzhou-i", StockSoldOut},
+	}
+	for _, c := range stocks {
+		if stock := ms.ZoneStocks[c.instanceType][c.zone]; stock != c.stock {
+			t.Errorf("stock of %s in %s is %q, want %q", c.instanceType, c.zone, stock, c.stock)
+		}
+	}
+}
+
+func TestFilterInstances(t *testing.T) {
+	ms := newReplayMetaStore(t, "cn-hangzhou")
+
+	cases := []struct {
+		name   string
+		filter InstanceFilter
+		want   []string
+	}{
+		{"cpu", InstanceFilter{MinCpu: 2, MaxCpu: 2, MaxGPU: -1},
+			[]string{"ecs.c6.large", "ecs.c6e.large", "ecs.g6.large", "ecs.hfc6.large", "ecs.r6.large"}},
+		{"family", InstanceFilter{MinCpu: 1, MaxCpu: 8, MaxMemory: 64, Family: []string{"ecs.c6"}, ExactFamily: true, MaxGPU: -1},
+			[]string{"ecs.c6.2xlarge", "ecs.c6.large", "ecs.c6.xlarge"}},
+		{"no gpu", InstanceFilter{MinCpu: 4, MaxCpu: 4, MaxMemory: 64, MaxGPU: 0},
+			[]string{"ecs.c6.xlarge", "ecs.g6.xlarge"}},
+	}
+	for _, c := range cases {
+		got := ms.FilterInstances(c.filter)
+		sort.Strings(got)
+		if !equalStrings(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFetchSpotPricesPages(t *testing.T) {
+	ms := newReplayMetaStore(t, "cn-hangzhou")
+
+	// ecs.c6.large is recorded in two pages of 16 points
+	cases := []struct {
+		name       string
+		start, end string
+		want       int
+	}{
+		{"both pages", "2026-10-11T00:00:00Z", "2026-10-18T00:00:00Z", 32},
+		// the first page has no point in the window but the second has
+		{"second page", "2026-10-15T13:00:00Z", "2026-10-18T00:00:00Z", 7},
+		{"first day", "2026-10-11T00:00:00Z", "2026-10-12T00:00:00Z", 5},
+		{"no point", "2026-10-18T01:00:00Z", "2026-10-19T00:00:00Z", 0},
+	}
+	for _, c := range cases {
+		window, err := NewPriceWindow(c.start, c.end, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history := ms.FetchSpotPrices([]string{"ecs.c6.large", "ecs.g6.large"}, window)
+		if n := len(history["ecs.c6.large"]); n != c.want {
+			t.Errorf("%s: ecs.c6.large has %d points, want %d", c.name, n, c.want)
+		}
+		for _, price := range history["ecs.c6.large"] {
+			if price.InstanceType != "ecs.c6.large" {
+				t.Errorf("%s: point of %s in the history of ecs.c6.large", c.name, price.InstanceType)
+			}
+			if ts, _ := time.Parse(time.RFC3339, price.Timestamp); !window.Contains(ts) {
+				t.Errorf("%s: point at %s is out of the window", c.name, price.Timestamp)
+			}
+		}
+	}
+	if n := len(ms.FetchSpotPrices([]string{"ecs.g6.large"}, replayWindow(t))["ecs.g6.large"]); n == 0 {
+		t.Errorf("ecs.g6.large has no points")
+	}
+	// nothing is recorded of it
+	if _, ok := ms.FetchSpotPrices([]string{"ecs.nosuch.large"}, replayWindow(t))["ecs.nosuch.large"]; ok {
+		t.Errorf("ecs.nosuch.large has a history")
+	}
+}
+
+func TestSpotPricesAnalysisRank(t *testing.T) {
+	ms := newReplayMetaStore(t, "cn-hangzhou")
+	ms.AnalysisOptions.Window = replayWindow(t)
+
+	instanceTypes := ms.FilterInstances(InstanceFilter{MinCpu: 1, MaxCpu: 32, MaxMemory: 64, MaxGPU: -1})
+	prices, err := ms.SpotPricesAnalysis(ms.FetchSpotPrices(instanceTypes, ms.AnalysisOptions.Window))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pools without stock are hidden
+	for _, price := range prices {
+		if !price.Launchable() {
+			t.Errorf("pool %s in %s without stock is ranked", price.InstanceTypeId, price.ZoneId)
+		}
+	}
+	if len(prices) != 18 {
+		t.Errorf("%d pools are ranked, want 18", len(prices))
+	}
+
+	prices = TopPrices(prices, 5)
+	want := []string{
+		"ecs.c6.2xlarge/cn-hangzhou-i",
+		"ecs.c6.2xlarge/cn-hangzhou-h",
+		"ecs.c6.large/cn-hangzhou-h",
+		"ecs.c6e.large/cn-hangzhou-i",
+		"ecs.t5-lc1m2.small/cn-hangzhou-i",
+	}
+	got := make([]string, 0, len(prices))
+	for _, price := range prices {
+		got = append(got, price.InstanceTypeId+"/"+price.ZoneId)
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("rank is %v, want %v", got, want)
+	}
+
+	ms.ShowUnavailable = true
+	prices, err = ms.SpotPricesAnalysis(ms.FetchSpotPrices(instanceTypes, ms.AnalysisOptions.Window))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 20 {
+		t.Errorf("%d pools are ranked with the unavailable ones, want 20", len(prices))
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSpotPricesAnalysisScoreError(t *testing.T) {
+	ms := newReplayMetaStore(t, "cn-hangzhou")
+	ms.AnalysisOptions.Window = replayWindow(t)
+	ms.RankModel = RankModel{Key: "nosuchkey"}
+
+	prices, err := ms.SpotPricesAnalysis(ms.FetchSpotPrices([]string{"ecs.c6.large"}, ms.AnalysisOptions.Window))
+	if err == nil || !strings.Contains(err.Error(), "unknown sort key nosuchkey") {
+		t.Errorf("error is %v, want the unknown sort key", err)
+	}
+	if prices != nil {
+		t.Errorf("%d pools are ranked by an invalid model", len(prices))
+	}
+}
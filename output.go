@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+	OutputYAML  = "yaml"
+)
+
+// flat record of InstancePrice for the machine readable outputs
+type PriceRecord struct {
+	InstanceTypeId     string  `json:"InstanceTypeId"`
+	RegionId           string  `json:"RegionId"`
+	ZoneId             string  `json:"ZoneId"`
+	Stock              string  `json:"Stock"`
+	PricePerCore       float64 `json:"PricePerCore"`
+	PricePerMemory     float64 `json:"PricePerMemory"`
+	Price              float64 `json:"Price"`
+	OriginPrice        float64 `json:"OriginPrice"`
+	Discount           float64 `json:"Discount"`
+	Risk               float64 `json:"Risk"`
+	Mean               float64 `json:"Mean"`
+	StdDev             float64 `json:"StdDev"`
+	CV                 float64 `json:"CV"`
+	MaxSpike           float64 `json:"MaxSpike"`
+	ChangesPerDay      float64 `json:"ChangesPerDay"`
+	TimeAboveThreshold float64 `json:"TimeAboveThreshold"`
+	Forecast           float64 `json:"Forecast"`
+	ForecastLower      float64 `json:"ForecastLower"`
+	ForecastUpper      float64 `json:"ForecastUpper"`
+	SpotPriceLimit     float64 `json:"SpotPriceLimit"`
+	SpotStrategy       string  `json:"SpotStrategy"`
+	LimitBreaches      int     `json:"LimitBreaches"`
+	NodeCost           float64 `json:"NodeCost"`
+	HourlyCost         float64 `json:"HourlyCost"`
+	MonthlyCost        float64 `json:"MonthlyCost"`
+	Currency           string  `json:"Currency"`
+	CpuCoreCount       int     `json:"CpuCoreCount"`
+	MemorySize         float64 `json:"MemorySize"`
+	InstanceTypeFamily string  `json:"InstanceTypeFamily"`
+	Score              float64 `json:"Score"`
+}
+
+func NewPriceRecord(price InstancePrice) PriceRecord {
+	return PriceRecord{
+		InstanceTypeId:     price.InstanceTypeId,
+		RegionId:           price.RegionId,
+		ZoneId:             price.ZoneId,
+		Stock:              price.Stock,
+		PricePerCore:       price.PricePerCore,
+		PricePerMemory:     price.PricePerMemory,
+		Price:              price.SpotPrice,
+		OriginPrice:        price.OriginPrice,
+		Discount:           price.Discount,
+		Risk:               price.Risk,
+		Mean:               price.Stats.Mean,
+		StdDev:             price.Stats.StdDev,
+		CV:                 price.Stats.CV,
+		MaxSpike:           price.Stats.MaxSpike,
+		ChangesPerDay:      price.Stats.ChangesPerDay,
+		TimeAboveThreshold: price.Stats.TimeAboveThreshold,
+		Forecast:           price.Forecast.Price,
+		ForecastLower:      price.Forecast.Lower,
+		ForecastUpper:      price.Forecast.Upper,
+		SpotPriceLimit:     price.Bid.SpotPriceLimit,
+		SpotStrategy:       price.Bid.SpotStrategy,
+		LimitBreaches:      price.Bid.Breaches,
+		NodeCost:           price.Cost.Node,
+		HourlyCost:         price.Cost.Hourly,
+		MonthlyCost:        price.Cost.Monthly,
+		Currency:           price.Cost.Currency,
+		CpuCoreCount:       price.CpuCoreCount,
+		MemorySize:         price.MemorySize,
+		InstanceTypeFamily: price.InstanceTypeFamily,
+		Score:              price.Score,
+	}
+}
+
+// Fields in column order, values are string or float64 or int.
+func (r PriceRecord) Fields() ([]string, []interface{}) {
+	return []string{"InstanceTypeId", "RegionId", "ZoneId", "Stock", "PricePerCore", "PricePerMemory", "Price", "OriginPrice", "Discount", "Risk",
+			"Mean", "StdDev", "CV", "MaxSpike", "ChangesPerDay", "TimeAboveThreshold", "Forecast", "ForecastLower", "ForecastUpper", "SpotPriceLimit", "SpotStrategy", "LimitBreaches", "NodeCost", "HourlyCost", "MonthlyCost", "Currency", "CpuCoreCount", "MemorySize", "InstanceTypeFamily", "Score"},
+		[]interface{}{r.InstanceTypeId, r.RegionId, r.ZoneId, r.Stock, r.PricePerCore, r.PricePerMemory, r.Price, r.OriginPrice, r.Discount, r.Risk,
+			r.Mean, r.StdDev, r.CV, r.MaxSpike, r.ChangesPerDay, r.TimeAboveThreshold, r.Forecast, r.ForecastLower, r.ForecastUpper, r.SpotPriceLimit, r.SpotStrategy, r.LimitBreaches, r.NodeCost, r.HourlyCost, r.MonthlyCost, r.Currency, r.CpuCoreCount, r.MemorySize, r.InstanceTypeFamily, r.Score}
+}
+
+// Print the top limit prices in the output format
+func PrintPriceRank(w io.Writer, prices SortedInstancePrices, cutoff int, limit int, format string, riskMetric string) error {
+	return WritePrices(w, TopPrices(prices, limit), cutoff, format, riskMetric)
+}
+
+// TopPrices sorts the prices and keeps the first limit of them, none when the limit is negative.
+func TopPrices(prices SortedInstancePrices, limit int) SortedInstancePrices {
+	sort.Sort(prices)
+	if limit < 0 {
+		limit = 0
+	}
+	if len(prices) > limit {
+		prices = prices[:limit]
+	}
+	return prices
+}
+
+// WritePrices renders the prices in the format, cutoff only colors the table
+// and riskMetric names its risk column.
+func WritePrices(w io.Writer, prices SortedInstancePrices, cutoff int, format string, riskMetric string) error {
+	records := make([]PriceRecord, 0, len(prices))
+	for _, price := range prices {
+		records = append(records, NewPriceRecord(price))
+	}
+
+	switch format {
+	case OutputTable, "":
+		return writeTable(w, prices, cutoff, riskMetric)
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case OutputCSV:
+		return writeCSV(w, records)
+	case OutputYAML:
+		return writeYAML(w, records)
+	}
+	return fmt.Errorf("unknown output format %s, use one of table,json,csv,yaml", format)
+}
+
+// writeTable adds the region column when the prices span regions and the cost columns
+// when the pools are costed, pools above the cutoff or without stock are blue.
+func writeTable(w io.Writer, prices SortedInstancePrices, cutoff int, riskMetric string) error {
+	green := color.New(color.FgGreen)
+	blue := color.New(color.FgBlue)
+
+	regions := make(map[string]bool)
+	currency := ""
+	for _, price := range prices {
+		regions[price.RegionId] = true
+		if price.Cost.Currency != "" {
+			currency = price.Cost.Currency
+		}
+	}
+	withRegion := len(regions) > 1
+
+	header := "%30s"
+	columns := []interface{}{"InstanceTypeId"}
+	if withRegion {
+		header += " %20s"
+		columns = append(columns, "RegionId")
+	}
+	header += " %20s %15s %15s %15s %15s"
+	columns = append(columns, "ZoneId", "Price(Core)", "Discount", "Risk("+riskMetric+")", "Stock")
+	if currency != "" {
+		header += " %15s %15s"
+		columns = append(columns, "Hourly("+currency+")", "Monthly("+currency+")")
+	}
+	green.Fprintf(w, header+"\n", columns...)
+
+	for _, price := range prices {
+		c := green
+		if price.Discount > float64(cutoff) || !price.Launchable() {
+			c = blue
+		}
+		row := "%30s"
+		values := []interface{}{price.InstanceTypeId}
+		if withRegion {
+			row += " %20s"
+			values = append(values, price.RegionId)
+		}
+		row += " %20s %15.4f %15.1f %15.4f %15s"
+		values = append(values, price.ZoneId, price.PricePerCore, price.Discount, price.Risk, price.Stock)
+		if currency != "" {
+			row += " %15.4f %15.2f"
+			values = append(values, price.Cost.Hourly, price.Cost.Monthly)
+		}
+		c.Fprintf(w, row+"\n", values...)
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, records []PriceRecord) error {
+	writer := csv.NewWriter(w)
+	names, _ := PriceRecord{}.Fields()
+	if err := writer.Write(names); err != nil {
+		return err
+	}
+	for _, record := range records {
+		_, values := record.Fields()
+		row := make([]string, 0, len(values))
+		for _, value := range values {
+			row = append(row, formatValue(value, false))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeYAML(w io.Writer, records []PriceRecord) error {
+	if len(records) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	for _, record := range records {
+		names, values := record.Fields()
+		for index, name := range names {
+			prefix := "  "
+			if index == 0 {
+				prefix = "- "
+			}
+			if _, err := fmt.Fprintf(w, "%s%s: %s\n", prefix, name, formatValue(values[index], true)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatValue prints the shortest exact number, strings are double quoted for yaml.
+func formatValue(value interface{}, quote bool) string {
+	switch v := value.(type) {
+	case string:
+		if quote {
+			return strconv.Quote(v)
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// constraints of the portfolio
+type PortfolioOptions struct {
+	TargetCpu    int
+	TargetMemory float64
+	MaxShare     float64
+	MinFamilies  int
+	MinZones     int
+	MaxRisk      float64
+}
+
+// instances to launch in one pool
+type PortfolioItem struct {
+	InstanceTypeId     string  `json:"InstanceTypeId"`
+	ZoneId             string  `json:"ZoneId"`
+	InstanceTypeFamily string  `json:"InstanceTypeFamily"`
+	Count              int     `json:"Count"`
+	CpuCoreCount       int     `json:"CpuCoreCount"`
+	MemorySize         float64 `json:"MemorySize"`
+	SpotPrice          float64 `json:"SpotPrice"`
+	HourlyCost         float64 `json:"HourlyCost"`
+}
+
+// mix of pools covering the target capacity
+type Portfolio struct {
+	Items       []PortfolioItem `json:"Items"`
+	TotalCpu    int             `json:"TotalCpu"`
+	TotalMemory float64         `json:"TotalMemory"`
+	HourlyCost  float64         `json:"HourlyCost"`
+	Families    int             `json:"Families"`
+	Zones       int             `json:"Zones"`
+}
+
+type portfolioSolver struct {
+	opts       PortfolioOptions
+	candidates SortedInstancePrices
+	counts     []int
+	cpu        int
+	memory     float64
+}
+
+// OptimizePortfolio picks counts per pool that cover the target vCPU and memory at a low hourly cost.
+//
+// It is a greedy heuristic: pools are first seeded to reach the family and zone minimums,
+// then the pool with the lowest price per unit of still missing capacity is added one
+// instance at a time, and finally instances that are no longer needed are dropped,
+// most expensive first. No pool may hold more than MaxShare of the target capacity.
+func OptimizePortfolio(prices SortedInstancePrices, opts PortfolioOptions) (*Portfolio, error) {
+	if opts.TargetCpu <= 0 && opts.TargetMemory <= 0 {
+		return nil, fmt.Errorf("target cpu or memory must be positive")
+	}
+	if opts.MaxShare <= 0 || opts.MaxShare > 1 {
+		opts.MaxShare = 1
+	}
+
+	candidates := make(SortedInstancePrices, 0, len(prices))
+	for _, price := range prices {
+		if price.SpotPrice <= 0 || price.CpuCoreCount <= 0 || !price.Launchable() {
+			continue
+		}
+		if opts.MaxRisk > 0 && price.Risk > opts.MaxRisk {
+			continue
+		}
+		candidates = append(candidates, price)
+	}
+	sort.Stable(candidates)
+
+	ps := &portfolioSolver{opts: opts, candidates: candidates, counts: make([]int, len(candidates))}
+	ps.seed()
+	for !ps.covered() {
+		best := ps.best(func(int) bool { return true })
+		if best < 0 {
+			return nil, fmt.Errorf("target capacity can not be covered with max share %.2f, %d vCPU and %.1f GiB reached", opts.MaxShare, ps.cpu, ps.memory)
+		}
+		ps.add(best)
+	}
+	ps.prune()
+
+	portfolio := ps.portfolio()
+	if portfolio.Families < opts.MinFamilies || portfolio.Zones < opts.MinZones {
+		return nil, fmt.Errorf("only %d families and %d zones are available within the constraints", portfolio.Families, portfolio.Zones)
+	}
+	return portfolio, nil
+}
+
+// seed adds one instance of the best pool of a new family or zone until the minimums are met.
+func (ps *portfolioSolver) seed() {
+	for {
+		families, zones := ps.diversity()
+		needFamily := len(families) < ps.opts.MinFamilies
+		needZone := len(zones) < ps.opts.MinZones
+		if !needFamily && !needZone {
+			return
+		}
+		best := ps.best(func(index int) bool {
+			price := ps.candidates[index]
+			return (!needFamily || !families[price.InstanceTypeFamily]) && (!needZone || !zones[price.ZoneId])
+		})
+		if best < 0 {
+			// no pool brings both, settle for either
+			best = ps.best(func(index int) bool {
+				price := ps.candidates[index]
+				return (needFamily && !families[price.InstanceTypeFamily]) || (needZone && !zones[price.ZoneId])
+			})
+		}
+		if best < 0 {
+			return
+		}
+		ps.add(best)
+	}
+}
+
+// best returns the allowed pool with the lowest price per unit of missing capacity, or -1.
+func (ps *portfolioSolver) best(allowed func(index int) bool) int {
+	best := -1
+	bestCost := math.MaxFloat64
+	for index, price := range ps.candidates {
+		if !allowed(index) || !ps.fits(index) {
+			continue
+		}
+		value := ps.value(price)
+		if value <= 0 {
+			continue
+		}
+		if cost := price.SpotPrice / value; cost < bestCost {
+			best, bestCost = index, cost
+		}
+	}
+	return best
+}
+
+// value is the missing capacity one instance covers, as a fraction of the targets.
+func (ps *portfolioSolver) value(price InstancePrice) float64 {
+	value := 0.0
+	if ps.opts.TargetCpu > 0 {
+		missing := math.Max(float64(ps.opts.TargetCpu-ps.cpu), 0)
+		value += math.Min(float64(price.CpuCoreCount), missing) / float64(ps.opts.TargetCpu)
+	}
+	if ps.opts.TargetMemory > 0 {
+		missing := math.Max(ps.opts.TargetMemory-ps.memory, 0)
+		value += math.Min(price.MemorySize, missing) / ps.opts.TargetMemory
+	}
+	return value
+}
+
+// fits reports whether one more instance keeps the pool within the max share,
+// a pool whose single instance is above the max share never fits.
+func (ps *portfolioSolver) fits(index int) bool {
+	return ps.share(ps.candidates[index], ps.counts[index]+1) <= ps.opts.MaxShare
+}
+
+func (ps *portfolioSolver) share(price InstancePrice, count int) float64 {
+	share := 0.0
+	if ps.opts.TargetCpu > 0 {
+		share = float64(count*price.CpuCoreCount) / float64(ps.opts.TargetCpu)
+	}
+	if ps.opts.TargetMemory > 0 {
+		share = math.Max(share, float64(count)*price.MemorySize/ps.opts.TargetMemory)
+	}
+	return share
+}
+
+func (ps *portfolioSolver) add(index int) {
+	ps.counts[index]++
+	ps.cpu += ps.candidates[index].CpuCoreCount
+	ps.memory += ps.candidates[index].MemorySize
+}
+
+func (ps *portfolioSolver) remove(index int) {
+	ps.counts[index]--
+	ps.cpu -= ps.candidates[index].CpuCoreCount
+	ps.memory -= ps.candidates[index].MemorySize
+}
+
+func (ps *portfolioSolver) covered() bool {
+	return ps.cpu >= ps.opts.TargetCpu && ps.memory >= ps.opts.TargetMemory
+}
+
+// prune drops instances, most expensive first, while the targets and minimums still hold.
+func (ps *portfolioSolver) prune() {
+	order := make([]int, len(ps.candidates))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return ps.candidates[order[i]].SpotPrice > ps.candidates[order[j]].SpotPrice
+	})
+
+	for _, index := range order {
+		for ps.counts[index] > 0 {
+			families, zones := ps.diversity()
+			ps.remove(index)
+			after, afterZones := ps.diversity()
+			if !ps.covered() ||
+				(len(after) < len(families) && len(after) < ps.opts.MinFamilies) ||
+				(len(afterZones) < len(zones) && len(afterZones) < ps.opts.MinZones) {
+				ps.add(index)
+				break
+			}
+		}
+	}
+}
+
+func (ps *portfolioSolver) diversity() (families map[string]bool, zones map[string]bool) {
+	families = make(map[string]bool)
+	zones = make(map[string]bool)
+	for index, count := range ps.counts {
+		if count > 0 {
+			families[ps.candidates[index].InstanceTypeFamily] = true
+			zones[ps.candidates[index].ZoneId] = true
+		}
+	}
+	return families, zones
+}
+
+func (ps *portfolioSolver) portfolio() *Portfolio {
+	portfolio := &Portfolio{Items: make([]PortfolioItem, 0)}
+	for index, count := range ps.counts {
+		if count == 0 {
+			continue
+		}
+		price := ps.candidates[index]
+		item := PortfolioItem{
+			InstanceTypeId:     price.InstanceTypeId,
+			ZoneId:             price.ZoneId,
+			InstanceTypeFamily: price.InstanceTypeFamily,
+			Count:              count,
+			CpuCoreCount:       price.CpuCoreCount,
+			MemorySize:         price.MemorySize,
+			SpotPrice:          price.SpotPrice,
+			HourlyCost:         float64(count) * price.SpotPrice,
+		}
+		portfolio.Items = append(portfolio.Items, item)
+		portfolio.TotalCpu += count * price.CpuCoreCount
+		portfolio.TotalMemory += float64(count) * price.MemorySize
+		portfolio.HourlyCost += item.HourlyCost
+	}
+	families, zones := ps.diversity()
+	portfolio.Families = len(families)
+	portfolio.Zones = len(zones)
+	return portfolio
+}
+
+// Write the portfolio as a table or json.
+func (p *Portfolio) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputTable, "":
+		fmt.Fprintf(w, "%30s %20s %8s %8s %10s %15s\n", "InstanceTypeId", "ZoneId", "Count", "Cores", "Memory", "Cost(Hour)")
+		for _, item := range p.Items {
+			fmt.Fprintf(w, "%30s %20s %8d %8d %10.1f %15.4f\n", item.InstanceTypeId, item.ZoneId, item.Count, item.Count*item.CpuCoreCount, float64(item.Count)*item.MemorySize, item.HourlyCost)
+		}
+		fmt.Fprintf(w, "%30s %20s %8s %8d %10.1f %15.4f\n", "Total", fmt.Sprintf("%d families/%d zones", p.Families, p.Zones), "", p.TotalCpu, p.TotalMemory, p.HourlyCost)
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+	return fmt.Errorf("unknown output format %s of portfolio, use one of table,json", format)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strings"
+	"testing"
+)
+
+func portfolioPool(instanceType string, family string, cpu int, mem float64, zone string, spotPrice float64) InstancePrice {
+	return InstancePrice{
+		InstanceType: ecsService.InstanceType{
+			InstanceTypeId:     instanceType,
+			InstanceTypeFamily: family,
+			CpuCoreCount:       cpu,
+			MemorySize:         mem,
+		},
+		ZoneId:    zone,
+		Stock:     StockWithStock,
+		SpotPrice: spotPrice,
+	}
+}
+
+var (
+	c6XlargeH = portfolioPool("ecs.c6.xlarge", "ecs.c6", 4, 8, "cn-hangzhou-h", 0.08)
+	c6XlargeI = portfolioPool("ecs.c6.xlarge", "ecs.c6", 4, 8, "cn-hangzhou-i", 0.085)
+	g6XlargeI = portfolioPool("ecs.g6.xlarge", "ecs.g6", 4, 16, "cn-hangzhou-i", 0.12)
+	c6Huge    = portfolioPool("ecs.c6.8xlarge", "ecs.c6", 32, 64, "cn-hangzhou-h", 0.48)
+	r6LargeH  = portfolioPool("ecs.r6.large", "ecs.r6", 2, 16, "cn-hangzhou-h", 0.05)
+	c6Double  = portfolioPool("ecs.c6.2xlarge", "ecs.c6", 8, 16, "cn-hangzhou-h", 0.11)
+)
+
+func TestOptimizePortfolio(t *testing.T) {
+	cases := []struct {
+		name   string
+		prices SortedInstancePrices
+		opts   PortfolioOptions
+		// count of every pool by instanceType/zone
+		want map[string]int
+		err  string
+	}{
+		{"cheapest per core", SortedInstancePrices{g6XlargeI, c6Huge, c6XlargeH},
+			PortfolioOptions{TargetCpu: 16, MaxShare: 1},
+			map[string]int{"ecs.c6.xlarge/cn-hangzhou-h": 4}, ""},
+		{"max share", SortedInstancePrices{g6XlargeI, c6Huge, c6XlargeH},
+			PortfolioOptions{TargetCpu: 16, MaxShare: 0.5},
+			map[string]int{"ecs.c6.xlarge/cn-hangzhou-h": 2, "ecs.g6.xlarge/cn-hangzhou-i": 2}, ""},
+		// one instance of the pool is twice the target
+		{"single instance above the max share", SortedInstancePrices{c6Huge},
+			PortfolioOptions{TargetCpu: 16, MaxShare: 0.5},
+			nil, "target capacity can not be covered with max share 0.50"},
+		{"families and zones", SortedInstancePrices{g6XlargeI, c6XlargeI, c6XlargeH},
+			PortfolioOptions{TargetCpu: 16, MaxShare: 1, MinFamilies: 2, MinZones: 2},
+			map[string]int{"ecs.c6.xlarge/cn-hangzhou-h": 3, "ecs.g6.xlarge/cn-hangzhou-i": 1}, ""},
+		{"too few zones", SortedInstancePrices{g6XlargeI, c6XlargeH},
+			PortfolioOptions{TargetCpu: 16, MaxShare: 1, MinZones: 3},
+			nil, "only 2 families and 2 zones are available"},
+		// the memory of the first pool is covered by the second, which is needed for the cores anyway
+		{"prune", SortedInstancePrices{c6Double, r6LargeH},
+			PortfolioOptions{TargetCpu: 8, TargetMemory: 16, MaxShare: 1},
+			map[string]int{"ecs.c6.2xlarge/cn-hangzhou-h": 1}, ""},
+		{"no target", SortedInstancePrices{c6XlargeH}, PortfolioOptions{MaxShare: 1}, nil, "target cpu or memory must be positive"},
+	}
+	for _, c := range cases {
+		portfolio, err := OptimizePortfolio(c.prices, c.opts)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := make(map[string]int)
+		for _, item := range portfolio.Items {
+			got[item.InstanceTypeId+"/"+item.ZoneId] = item.Count
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: portfolio is %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for pool, count := range c.want {
+			if got[pool] != count {
+				t.Errorf("%s: portfolio is %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+		if portfolio.TotalCpu < c.opts.TargetCpu || portfolio.TotalMemory < c.opts.TargetMemory {
+			t.Errorf("%s: %d vCPU and %.1f GiB do not cover the target", c.name, portfolio.TotalCpu, portfolio.TotalMemory)
+		}
+		for _, item := range portfolio.Items {
+			if share := float64(item.Count*item.CpuCoreCount) / float64(c.opts.TargetCpu); share > c.opts.MaxShare {
+				t.Errorf("%s: %s in %s holds %.2f of the target", c.name, item.InstanceTypeId, item.ZoneId, share)
+			}
+		}
+	}
+}
+
+func TestOptimizePortfolioSkipsUnavailable(t *testing.T) {
+	soldOut := c6XlargeH
+	soldOut.Stock = StockSoldOut
+	risky := c6XlargeI
+	risky.Risk = 0.5
+
+	portfolio, err := OptimizePortfolio(SortedInstancePrices{soldOut, risky, g6XlargeI}, PortfolioOptions{TargetCpu: 8, MaxShare: 1, MaxRisk: 0.2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range portfolio.Items {
+		if item.InstanceTypeId != "ecs.g6.xlarge" {
+			t.Errorf("%s in %s is in the portfolio", item.InstanceTypeId, item.ZoneId)
+		}
+	}
+	if portfolio.Families != 1 || portfolio.Zones != 1 || portfolio.TotalCpu != 8 {
+		t.Errorf("portfolio of %d families, %d zones and %d vCPU", portfolio.Families, portfolio.Zones, portfolio.TotalCpu)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SortByCore      = "core"
+	SortByMemory    = "memory"
+	SortByPrice     = "price"
+	SortByDiscount  = "discount"
+	SortByRisk      = "risk"
+	SortByForecast  = "forecast"
+	SortByHourly    = "hourly"
+	SortByMonthly   = "monthly"
+	SortByComposite = "composite"
+)
+
+// sort keys, lower is better for all of them
+var sortKeys = map[string]func(price InstancePrice) float64{
+	SortByCore:     func(price InstancePrice) float64 { return price.PricePerCore },
+	SortByMemory:   func(price InstancePrice) float64 { return price.PricePerMemory },
+	SortByPrice:    func(price InstancePrice) float64 { return price.SpotPrice },
+	SortByDiscount: func(price InstancePrice) float64 { return price.Discount },
+	SortByRisk:     func(price InstancePrice) float64 { return price.Risk },
+	// forecast spot price per core
+	SortByForecast: func(price InstancePrice) float64 { return price.Forecast.Price / float64(price.CpuCoreCount) },
+	// full cost of a node with its disks and bandwidth
+	SortByHourly:  func(price InstancePrice) float64 { return price.Cost.Hourly },
+	SortByMonthly: func(price InstancePrice) float64 { return price.Cost.Monthly },
+}
+
+// how the prices are scored before they are sorted
+type RankModel struct {
+	Key     string             `json:"Key"`
+	Weights map[string]float64 `json:"Weights"`
+}
+
+// Validate checks the key and the weights of the composite.
+func (m RankModel) Validate() error {
+	if m.Key == SortByComposite {
+		if len(m.Weights) == 0 {
+			return fmt.Errorf("composite sort key needs weights")
+		}
+		for key := range m.Weights {
+			if _, ok := sortKeys[key]; !ok {
+				return fmt.Errorf("unknown sort key %s in weights, use one of %s", key, strings.Join(SortKeys(), ","))
+			}
+		}
+		return nil
+	}
+	if _, ok := sortKeys[m.Key]; !ok {
+		return fmt.Errorf("unknown sort key %s, use one of %s,%s", m.Key, strings.Join(SortKeys(), ","), SortByComposite)
+	}
+	return nil
+}
+
+// CostKey is the key of the model that ranks by the bill of a node, empty if none.
+func (m RankModel) CostKey() string {
+	for _, key := range []string{SortByHourly, SortByMonthly} {
+		if _, ok := m.Weights[key]; ok || m.Key == key {
+			return key
+		}
+	}
+	return ""
+}
+
+// Score sets the Score of every price, the composite is the weighted sum of
+// the keys scaled to [0,1] over the prices.
+func (m RankModel) Score(prices SortedInstancePrices) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if m.Key != SortByComposite {
+		key := sortKeys[m.Key]
+		for i := range prices {
+			prices[i].Score = key(prices[i])
+		}
+		return nil
+	}
+
+	for i := range prices {
+		prices[i].Score = 0
+	}
+	// sum in a fixed order so equal inputs give bit-equal scores
+	names := make([]string, 0, len(m.Weights))
+	for name := range m.Weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, weight := sortKeys[name], m.Weights[name]
+		min, max := math.MaxFloat64, -math.MaxFloat64
+		for _, price := range prices {
+			min = math.Min(min, key(price))
+			max = math.Max(max, key(price))
+		}
+		if max <= min {
+			continue
+		}
+		for i := range prices {
+			prices[i].Score += weight * (key(prices[i]) - min) / (max - min)
+		}
+	}
+	return nil
+}
+
+// SortKeys lists the single sort keys.
+func SortKeys() []string {
+	keys := make([]string, 0, len(sortKeys))
+	for key := range sortKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseWeights reads "key=weight" pairs separated by comma.
+func ParseWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid weight %q, expect key=weight", pair)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight %q: %v", pair, err)
+		}
+		weights[strings.TrimSpace(kv[0])] = weight
+	}
+	return weights, nil
+}
+
+// LoadRankModel reads the rank model from a json file.
+func LoadRankModel(path string) (RankModel, error) {
+	model := RankModel{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return model, err
+	}
+	if err := json.Unmarshal(data, &model); err != nil {
+		return model, fmt.Errorf("invalid rank model %s: %v", path, err)
+	}
+	return model, model.Validate()
+}
+
+// DefaultRankModel sorts by price per core.
+func DefaultRankModel() RankModel {
+	return RankModel{Key: SortByCore}
+}
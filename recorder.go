@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"os"
+	"time"
+)
+
+const (
+	CommandRecord = "record"
+)
+
+// Recorder polls the spot prices of the regions into the PriceStore on an interval.
+//
+// Every instanceType continues from its own watermark, so one that failed or
+// was filtered in later is fetched from where it stopped instead of being skipped.
+type Recorder struct {
+	Store    *PriceStore
+	Regions  []string
+	Interval time.Duration
+	// window of the first poll of an instanceType without recorded history
+	First PriceWindow
+	// InstanceTypes lists the instanceTypes to record in the region, it may panic
+	InstanceTypes func(region string) []string
+	// Fetch returns the price history of the instanceTypes over the window, the failed ones are left out, it may panic
+	Fetch func(region string, instanceTypes []string, window PriceWindow) map[string][]ecsService.SpotPriceType
+}
+
+// Run polls every region at once and then every Interval until stop is closed.
+func (r *Recorder) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		r.RecordAll(time.Now().UTC())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecordAll records every region up to now, a failed region is logged and retried on the next poll.
+func (r *Recorder) RecordAll(now time.Time) {
+	for _, region := range r.Regions {
+		if err := r.RecordOnce(region, now); err != nil {
+			log.Warnf("Failed to record prices of region %s,because of %v", region, err)
+		}
+	}
+}
+
+// RecordOnce fetches the prices of every instanceType since its watermark up to now.
+func (r *Recorder) RecordOnce(region string, now time.Time) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	// instanceTypes of the same watermark are fetched together
+	windows := make(map[PriceWindow][]string)
+	for _, instanceType := range r.InstanceTypes(region) {
+		watermark, err := r.Store.Watermark(region, instanceType)
+		if err != nil {
+			return err
+		}
+		window := r.First
+		if !watermark.IsZero() {
+			window = PriceWindow{Start: watermark, End: now}
+		}
+		if window.Start.Before(window.End) {
+			windows[window] = append(windows[window], instanceType)
+		}
+	}
+
+	for window, instanceTypes := range windows {
+		historyPrices := r.Fetch(region, instanceTypes, window)
+		written := 0
+		for instanceType, prices := range historyPrices {
+			n, err := r.Store.Append(region, instanceType, prices)
+			if err != nil {
+				return err
+			}
+			if err := r.Store.SetWatermark(region, instanceType, window.End); err != nil {
+				return err
+			}
+			written += n
+		}
+
+		fmt.Fprintf(os.Stderr, "Record %d new price points of %d of %d kinds of instanceTypes in %s from %s to %s\n",
+			written, len(historyPrices), len(instanceTypes), region, window.StartTime(), window.EndTime())
+	}
+	return nil
+}
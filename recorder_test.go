@@ -0,0 +1,113 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newReplayRecorder records the instanceTypes replayed from testdata, it counts the windows fetched.
+func newReplayRecorder(t *testing.T, instanceTypes []string) (*Recorder, *[]PriceWindow) {
+	t.Helper()
+	first, err := NewPriceWindow("2026-10-11T00:00:00Z", "2026-10-15T00:00:00Z", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := sync.Mutex{}
+	fetched := make([]PriceWindow, 0)
+	recorder := &Recorder{
+		Store:    &PriceStore{Dir: t.TempDir()},
+		Regions:  []string{"cn-hangzhou", "cn-shanghai"},
+		Interval: time.Hour,
+		First:    first,
+		InstanceTypes: func(region string) []string {
+			return instanceTypes
+		},
+		Fetch: func(region string, instanceTypes []string, window PriceWindow) map[string][]ecsService.SpotPriceType {
+			lock.Lock()
+			fetched = append(fetched, window)
+			lock.Unlock()
+			ms := NewMetaStore(NewFakeClient("testdata", region))
+			ms.FetchOptions.QPS = 0
+			ms.Region = region
+			return ms.FetchSpotPrices(instanceTypes, window)
+		},
+	}
+	return recorder, &fetched
+}
+
+func TestRecorderRecordAll(t *testing.T) {
+	recorder, fetched := newReplayRecorder(t, []string{"ecs.c6.large", "ecs.nosuchtype"})
+	firstEnd := recorder.First.End
+
+	// -once records the first window of every region
+	recorder.RecordAll(firstEnd)
+	for _, region := range recorder.Regions {
+		watermark, err := recorder.Store.Watermark(region, "ecs.c6.large")
+		if err != nil || !watermark.Equal(firstEnd) {
+			t.Errorf("watermark of %s is %v, %v, want %v", region, watermark, err, firstEnd)
+		}
+	}
+	if len(*fetched) != 2 {
+		t.Errorf("%d windows are fetched, want one of every region", len(*fetched))
+	}
+	recorded, err := recorder.Store.read(recorder.Store.path("cn-hangzhou", "ecs.c6.large"))
+	if err != nil || len(recorded) == 0 {
+		t.Fatalf("nothing recorded in cn-hangzhou: %v", err)
+	}
+	first := len(recorded)
+
+	// polling at the watermark again fetches nothing but the failed instanceType
+	*fetched = (*fetched)[:0]
+	recorder.RecordAll(firstEnd)
+	for _, window := range *fetched {
+		if window != recorder.First {
+			t.Errorf("window %s-%s is fetched again", window.StartTime(), window.EndTime())
+		}
+	}
+
+	// the next poll continues from the watermark
+	*fetched = (*fetched)[:0]
+	end := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	recorder.RecordAll(end)
+	continued := false
+	for _, window := range *fetched {
+		if window.Start.Equal(firstEnd) && window.End.Equal(end) {
+			continued = true
+		}
+	}
+	if !continued {
+		t.Errorf("windows %v do not continue from the watermark", *fetched)
+	}
+	recorded, err = recorder.Store.read(recorder.Store.path("cn-hangzhou", "ecs.c6.large"))
+	if err != nil || len(recorded) <= first {
+		t.Errorf("%d points recorded after the next poll, %d before: %v", len(recorded), first, err)
+	}
+
+	// the instanceType that failed has no watermark and starts from the first window again
+	if watermark, err := recorder.Store.Watermark("cn-hangzhou", "ecs.nosuchtype"); err != nil || !watermark.IsZero() {
+		t.Errorf("watermark of the failed instanceType is %v, %v", watermark, err)
+	}
+}
+
+func TestRecorderRun(t *testing.T) {
+	recorder, fetched := newReplayRecorder(t, []string{"ecs.c6.large"})
+	// a window that ends before now is recorded at once
+	stop := make(chan struct{})
+	close(stop)
+	recorder.Run(stop)
+	if len(*fetched) != len(recorder.Regions) {
+		t.Errorf("%d windows are fetched before the stop, want %d", len(*fetched), len(recorder.Regions))
+	}
+}
+
+func TestRecorderRecordOncePanic(t *testing.T) {
+	recorder, _ := newReplayRecorder(t, nil)
+	recorder.InstanceTypes = func(region string) []string {
+		panic("Failed to describe instanceTypes,because of replay")
+	}
+	if err := recorder.RecordOnce("cn-hangzhou", recorder.First.End); err == nil {
+		t.Errorf("panic of the instanceTypes is not returned")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	AllRegions    = "all"
+	DefaultRegion = "cn-hangzhou"
+)
+
+// ResolveRegions splits the comma separated regions, "all" lists every
+// available region through DescribeRegions of the client.
+func ResolveRegions(value string, client EcsClient) ([]string, error) {
+	if strings.TrimSpace(value) != AllRegions {
+		regions := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, region := range strings.Split(value, ",") {
+			region = strings.TrimSpace(region)
+			if region != "" && !seen[region] {
+				seen[region] = true
+				regions = append(regions, region)
+			}
+		}
+		if len(regions) == 0 {
+			return nil, fmt.Errorf("no region is given")
+		}
+		return regions, nil
+	}
+
+	req := ecsService.CreateDescribeRegionsRequest()
+	req.InstanceChargeType = "PostPaid"
+	resp, err := client.DescribeRegions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]string, 0, len(resp.Regions.Region))
+	for _, region := range resp.Regions.Region {
+		if region.Status == "" || region.Status == "available" {
+			regions = append(regions, region.RegionId)
+		}
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// ScanRegions runs scan for every region concurrently and merges the prices of the
+// regions that were scanned. A region that fails or panics is logged and skipped,
+// it is an error only when every region fails.
+func ScanRegions(regions []string, scan func(region string) (SortedInstancePrices, error)) (SortedInstancePrices, []string, error) {
+	results := make([]SortedInstancePrices, len(regions))
+	errs := make([]error, len(regions))
+	wg := sync.WaitGroup{}
+
+	for index, region := range regions {
+		wg.Add(1)
+		go func(index int, region string) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[index] = fmt.Errorf("%v", r)
+				}
+			}()
+			results[index], errs[index] = scan(region)
+		}(index, region)
+	}
+	wg.Wait()
+
+	merged := make(SortedInstancePrices, 0)
+	scanned := make([]string, 0, len(regions))
+	failed := 0
+	for index, region := range regions {
+		if errs[index] != nil {
+			failed++
+			log.Warnf("Failed to scan region %s,because of %v", region, errs[index])
+			continue
+		}
+		merged = append(merged, results[index]...)
+		scanned = append(scanned, region)
+	}
+
+	if len(regions) > 1 {
+		fmt.Fprintf(os.Stderr, "Scan %d of %d regions successfully, %d failed.\n", len(regions)-failed, len(regions), failed)
+	}
+	if failed == len(regions) {
+		return nil, nil, fmt.Errorf("all of the %d regions failed", failed)
+	}
+	return merged, scanned, nil
+}
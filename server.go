@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CommandServe = "serve"
+)
+
+// parameters of a rank request, the zero values are taken from the defaults of the server
+type RankQuery struct {
+	Filter InstanceFilter
+	Cutoff int
+	Limit  int
+	Model  RankModel
+	Output string
+	// the bill of a node is known, so the hourly and monthly cost can be ranked
+	TCO bool
+}
+
+// ParseRankQuery reads mincpu,minmem,maxcpu,maxmem,mingpu,maxgpu,family,exclude-family,
+// where,cutoff,limit,sort,sort-weights and output of the query string over the defaults.
+func ParseRankQuery(values url.Values, defaults RankQuery) (RankQuery, error) {
+	query := defaults
+	ints := map[string]*int{
+		"mincpu": &query.Filter.MinCpu,
+		"maxcpu": &query.Filter.MaxCpu,
+		"mingpu": &query.Filter.MinGPU,
+		"maxgpu": &query.Filter.MaxGPU,
+		"cutoff": &query.Cutoff,
+		"limit":  &query.Limit,
+	}
+	for name, value := range ints {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*value = n
+		}
+	}
+	floats := map[string]*float64{
+		"minmem": &query.Filter.MinMemory,
+		"maxmem": &query.Filter.MaxMemory,
+	}
+	for name, value := range floats {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*value = n
+		}
+	}
+	if v, ok := values["family"]; ok {
+		query.Filter.Family = SplitList(strings.Join(v, ","))
+	}
+	if v, ok := values["exclude-family"]; ok {
+		query.Filter.ExcludeFamily = SplitList(strings.Join(v, ","))
+	}
+	if v := values.Get("where"); v != "" {
+		expr, err := ParseExpr(v)
+		if err != nil {
+			return query, err
+		}
+		query.Filter.Where = expr
+	}
+	if err := query.Filter.Validate(); err != nil {
+		return query, err
+	}
+	if query.Limit < 0 {
+		return query, fmt.Errorf("invalid limit %d, it can not be negative", query.Limit)
+	}
+	if v := values.Get("output"); v != "" {
+		query.Output = v
+	}
+	switch query.Output {
+	case OutputJSON, OutputCSV, OutputYAML:
+	default:
+		return query, fmt.Errorf("unknown output %s, use one of json,csv,yaml", query.Output)
+	}
+
+	if key, weights := values.Get("sort"), values.Get("sort-weights"); key != "" || weights != "" {
+		if key != "" {
+			query.Model = RankModel{Key: key}
+		}
+		if weights != "" {
+			parsed, err := ParseWeights(weights)
+			if err != nil {
+				return query, err
+			}
+			query.Model = RankModel{Key: query.Model.Key, Weights: parsed}
+		}
+		if err := query.Model.Validate(); err != nil {
+			return query, err
+		}
+		if key := query.Model.CostKey(); key != "" && !query.TCO {
+			return query, fmt.Errorf("sort key %s needs the bill of a node, serve with -tco", key)
+		}
+	}
+	return query, nil
+}
+
+// warm state of a region, the metastore is replaced on every refresh
+type regionRank struct {
+	lock sync.RWMutex
+	meta *MetaStore
+	// price history of every instanceType requested so far
+	prices map[string][]ecsService.SpotPriceType
+}
+
+// Server answers rank requests from warm metastores that are refreshed in the background.
+//
+//	GET /healthz                       the process is up
+//	GET /readyz                        every region has been loaded once
+//	GET /v1/regions                    the served regions
+//	GET /v1/regions/{region}/rank      the rank of the region, see ParseRankQuery
+//	GET /metrics                       the rank of the defaults for prometheus, if Exporter is set
+type Server struct {
+	Regions    []string
+	Refresh    time.Duration
+	Defaults   RankQuery
+	RiskMetric string
+	Exporter   *Exporter
+	// NewMetaStore returns an initialized metastore of the region, it may panic
+	NewMetaStore func(region string) *MetaStore
+
+	ranks map[string]*regionRank
+	once  sync.Once
+}
+
+func (s *Server) init() {
+	s.once.Do(func() {
+		s.ranks = make(map[string]*regionRank)
+		for _, region := range s.Regions {
+			s.ranks[region] = &regionRank{prices: make(map[string][]ecsService.SpotPriceType)}
+		}
+	})
+}
+
+// Run loads every region, then refreshes them every Refresh until stop is closed.
+func (s *Server) Run(stop <-chan struct{}) {
+	s.init()
+	ticker := time.NewTicker(s.Refresh)
+	defer ticker.Stop()
+	for {
+		for _, region := range s.Regions {
+			if err := s.refresh(region); err != nil {
+				log.Warnf("Failed to refresh region %s,because of %v", region, err)
+				continue
+			}
+			if s.Exporter != nil {
+				prices, err := s.Rank(region, s.Defaults)
+				if err != nil {
+					log.Warnf("Failed to export the rank of region %s,because of %v", region, err)
+					continue
+				}
+				s.Exporter.Update(region, prices)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh rebuilds the metastore and fetches the prices of the known instanceTypes again.
+func (s *Server) refresh(region string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	rank := s.ranks[region]
+	meta := s.NewMetaStore(region)
+
+	rank.lock.RLock()
+	known := make([]string, 0, len(rank.prices))
+	for instanceType := range rank.prices {
+		if _, ok := meta.InstanceFamilyCache[instanceType]; ok {
+			known = append(known, instanceType)
+		}
+	}
+	rank.lock.RUnlock()
+
+	prices := make(map[string][]ecsService.SpotPriceType)
+	if len(known) > 0 {
+		prices = meta.FetchSpotPrices(known, meta.AnalysisOptions.Window)
+	}
+
+	rank.lock.Lock()
+	rank.meta = meta
+	rank.prices = prices
+	rank.lock.Unlock()
+	return nil
+}
+
+// Ready reports whether every region has been loaded.
+func (s *Server) Ready() bool {
+	s.init()
+	for _, rank := range s.ranks {
+		rank.lock.RLock()
+		ready := rank.meta != nil
+		rank.lock.RUnlock()
+		if !ready {
+			return false
+		}
+	}
+	return true
+}
+
+// Rank filters the warm metastore of the region, fetching the prices it has not seen yet.
+func (s *Server) Rank(region string, query RankQuery) (prices SortedInstancePrices, err error) {
+	s.init()
+	rank, ok := s.ranks[region]
+	if !ok {
+		return nil, errNotFound{fmt.Sprintf("region %s is not served", region)}
+	}
+
+	rank.lock.RLock()
+	meta := rank.meta
+	rank.lock.RUnlock()
+	if meta == nil {
+		return nil, errNotReady{fmt.Sprintf("region %s is not loaded yet", region)}
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	instanceTypes := meta.FilterInstances(query.Filter)
+
+	rank.lock.RLock()
+	missing := make([]string, 0)
+	for _, instanceType := range instanceTypes {
+		if _, ok := rank.prices[instanceType]; !ok {
+			missing = append(missing, instanceType)
+		}
+	}
+	rank.lock.RUnlock()
+
+	if len(missing) > 0 {
+		fetched := meta.FetchSpotPrices(missing, meta.AnalysisOptions.Window)
+		rank.lock.Lock()
+		// a refresh in between brought its own prices
+		if rank.meta == meta {
+			for instanceType, history := range fetched {
+				rank.prices[instanceType] = history
+			}
+		}
+		rank.lock.Unlock()
+	}
+
+	historyPrices := make(map[string][]ecsService.SpotPriceType)
+	rank.lock.RLock()
+	for _, instanceType := range instanceTypes {
+		if history, ok := rank.prices[instanceType]; ok {
+			historyPrices[instanceType] = history
+		}
+	}
+	rank.lock.RUnlock()
+
+	if prices, err = meta.SpotPricesAnalysis(historyPrices); err != nil {
+		return nil, err
+	}
+	if where := query.Filter.Where; where != nil {
+		matched := make(SortedInstancePrices, 0, len(prices))
+		for _, price := range prices {
+			if where.Match(price) {
+				matched = append(matched, price)
+			}
+		}
+		prices = matched
+	}
+	if err := query.Model.Score(prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// Handler routes the endpoints of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/v1/regions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Regions)
+	})
+	mux.HandleFunc("/v1/regions/", s.handleRank)
+	if s.Exporter != nil {
+		mux.Handle("/metrics", s.Exporter)
+	}
+	return mux
+}
+
+func (s *Server) handleRank(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/regions/"), "/")
+	if len(parts) != 2 || parts[1] != "rank" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("path %s is not found", r.URL.Path))
+		return
+	}
+
+	query, err := ParseRankQuery(r.URL.Query(), s.Defaults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	prices, err := s.Rank(parts[0], query)
+	switch err.(type) {
+	case nil:
+	case errNotFound:
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errNotReady:
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch query.Output {
+	case OutputCSV:
+		w.Header().Set("Content-Type", "text/csv")
+	case OutputYAML:
+		w.Header().Set("Content-Type", "application/yaml")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := PrintPriceRank(w, prices, query.Cutoff, query.Limit, query.Output, s.RiskMetric); err != nil {
+		log.Warnf("Failed to write the rank of %s,because of %v", parts[0], err)
+	}
+}
+
+// ListenAndServe serves addr until stop is closed.
+func (s *Server) ListenAndServe(addr string, stop <-chan struct{}) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serve the rank of %d regions on %s\n", len(s.Regions), addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+type errNotFound struct{ message string }
+
+func (e errNotFound) Error() string { return e.message }
+
+type errNotReady struct{ message string }
+
+func (e errNotReady) Error() string { return e.message }
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serverDefaults() RankQuery {
+	return RankQuery{
+		Filter: InstanceFilter{MinCpu: 1, MaxCpu: 64, MaxMemory: 512, MaxGPU: -1},
+		Cutoff: 2,
+		Limit:  5,
+		Model:  DefaultRankModel(),
+		Output: OutputJSON,
+	}
+}
+
+func TestParseRankQuery(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		tco   bool
+		check func(query RankQuery) bool
+		err   string
+	}{
+		{"defaults", "", false, func(query RankQuery) bool {
+			return query.Filter.MinCpu == 1 && query.Limit == 5 && query.Model.Key == SortByCore && query.Output == OutputJSON
+		}, ""},
+		{"filter", "mincpu=4&maxcpu=8&minmem=8.5&maxgpu=0&family=ecs.c6&family=ecs.g6,ecs.r6&exclude-family=ecs.c6e", false, func(query RankQuery) bool {
+			return query.Filter.MinCpu == 4 && query.Filter.MaxCpu == 8 && query.Filter.MinMemory == 8.5 && query.Filter.MaxGPU == 0 &&
+				equalStrings(query.Filter.Family, []string{"ecs.c6", "ecs.g6", "ecs.r6"}) && equalStrings(query.Filter.ExcludeFamily, []string{"ecs.c6e"})
+		}, ""},
+		{"limit and output", "limit=2&cutoff=3&output=csv", false, func(query RankQuery) bool {
+			return query.Limit == 2 && query.Cutoff == 3 && query.Output == OutputCSV
+		}, ""},
+		{"where", `where=zone == "cn-hangzhou-i"`, false, func(query RankQuery) bool {
+			return query.Filter.Where != nil
+		}, ""},
+		{"sort", "sort=risk", false, func(query RankQuery) bool {
+			return query.Model.Key == SortByRisk && query.Model.Weights == nil
+		}, ""},
+		{"sort weights", "sort=composite&sort-weights=core=0.7,risk=0.3", false, func(query RankQuery) bool {
+			return query.Model.Key == SortByComposite && query.Model.Weights[SortByCore] == 0.7 && query.Model.Weights[SortByRisk] == 0.3
+		}, ""},
+		{"cost with tco", "sort=monthly", true, func(query RankQuery) bool {
+			return query.Model.Key == SortByMonthly
+		}, ""},
+
+		{"invalid int", "mincpu=two", false, nil, `invalid mincpu "two"`},
+		{"invalid float", "maxmem=lots", false, nil, `invalid maxmem "lots"`},
+		{"invalid filter", "mincpu=8&maxcpu=4", false, nil, "min cpu 8 is above max cpu 4"},
+		{"negative limit", "limit=-1", false, nil, "invalid limit -1"},
+		{"table output", "output=table", false, nil, "unknown output table"},
+		{"unknown sort", "sort=nosuchkey", false, nil, "unknown sort key nosuchkey"},
+		{"invalid weights", "sort=composite&sort-weights=core", false, nil, `invalid weight "core"`},
+		{"invalid where", "where=gpus > 0", false, nil, `unknown field "gpus"`},
+		{"hourly without tco", "sort=hourly", false, nil, "sort key hourly needs the bill of a node"},
+		{"monthly without tco", "sort=monthly", false, nil, "sort key monthly needs the bill of a node"},
+		{"cost weight without tco", "sort=composite&sort-weights=core=1,hourly=1", false, nil, "sort key hourly needs the bill of a node"},
+	}
+	for _, c := range cases {
+		values, err := url.ParseQuery(c.query)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		defaults := serverDefaults()
+		defaults.TCO = c.tco
+		query, err := ParseRankQuery(values, defaults)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !c.check(query) {
+			t.Errorf("%s: unexpected query %+v", c.name, query)
+		}
+	}
+}
+
+// newReplayServer serves the regions replayed from testdata.
+func newReplayServer(t *testing.T, regions ...string) *Server {
+	t.Helper()
+	window := replayWindow(t)
+	return &Server{
+		Regions:  regions,
+		Refresh:  time.Hour,
+		Defaults: serverDefaults(),
+		NewMetaStore: func(region string) *MetaStore {
+			ms := newReplayMetaStore(t, region)
+			ms.AnalysisOptions.Window = window
+			return ms
+		},
+	}
+}
+
+// load refreshes every region of the server once.
+func load(s *Server) {
+	stop := make(chan struct{})
+	close(stop)
+	s.Run(stop)
+}
+
+func getRank(t *testing.T, server *httptest.Server, path string) (*http.Response, []PriceRecord) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	records := make([]PriceRecord, 0)
+	if resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return resp, records
+}
+
+func TestServerRank(t *testing.T) {
+	s := newReplayServer(t, "cn-hangzhou")
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	// nothing is served before the first refresh
+	if resp, _ := getRank(t, server, "/readyz"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz before the load is %d", resp.StatusCode)
+	}
+	if resp, _ := getRank(t, server, "/v1/regions/cn-hangzhou/rank"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("rank before the load is %d", resp.StatusCode)
+	}
+
+	load(s)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if resp, _ := getRank(t, server, path); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s is %d", path, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(server.URL + "/v1/regions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regions := make([]string, 0)
+	json.NewDecoder(resp.Body).Decode(&regions)
+	resp.Body.Close()
+	if !equalStrings(regions, []string{"cn-hangzhou"}) {
+		t.Errorf("served regions are %v", regions)
+	}
+
+	cases := []struct {
+		path  string
+		count int
+		check func(record PriceRecord) bool
+	}{
+		// the limit of the defaults
+		{"", 5, nil},
+		{"?limit=2", 2, nil},
+		{"?limit=0", 0, nil},
+		{"?mincpu=4&maxcpu=4&maxgpu=0&limit=10", 4, func(record PriceRecord) bool {
+			return record.InstanceTypeId == "ecs.c6.xlarge" || record.InstanceTypeId == "ecs.g6.xlarge"
+		}},
+		{"?family=ecs.g6&limit=10", 4, func(record PriceRecord) bool {
+			return strings.HasPrefix(record.InstanceTypeId, "ecs.g6.")
+		}},
+		{"?where=" + url.QueryEscape(`zone == "cn-hangzhou-i"`) + "&limit=100", -1, func(record PriceRecord) bool {
+			return record.ZoneId == "cn-hangzhou-i"
+		}},
+	}
+	for _, c := range cases {
+		resp, records := getRank(t, server, "/v1/regions/cn-hangzhou/rank"+c.path)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", c.path, resp.StatusCode)
+			continue
+		}
+		if c.count >= 0 && len(records) != c.count {
+			t.Errorf("%s: %d records, want %d", c.path, len(records), c.count)
+		}
+		if c.count < 0 && len(records) == 0 {
+			t.Errorf("%s: no record", c.path)
+		}
+		for _, record := range records {
+			if c.check != nil && !c.check(record) {
+				t.Errorf("%s: unexpected %s in %s", c.path, record.InstanceTypeId, record.ZoneId)
+			}
+		}
+		if !sort.SliceIsSorted(records, func(i, j int) bool { return records[i].PricePerCore < records[j].PricePerCore }) {
+			t.Errorf("%s: records are not sorted by the price per core", c.path)
+		}
+	}
+
+	// another sort key of the query
+	_, records := getRank(t, server, "/v1/regions/cn-hangzhou/rank?sort=risk&limit=100")
+	if len(records) == 0 || !sort.SliceIsSorted(records, func(i, j int) bool { return records[i].Risk < records[j].Risk }) {
+		t.Errorf("%d records are not sorted by risk", len(records))
+	}
+}
+
+func TestServerOutput(t *testing.T) {
+	s := newReplayServer(t, "cn-hangzhou")
+	load(s)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/regions/cn-hangzhou/rank?output=csv&limit=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("content type of csv is %s", resp.Header.Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[0][0] != "InstanceTypeId" {
+		t.Errorf("csv has %d rows with the header %v, want a header and 3 rows", len(rows), rows[0])
+	}
+
+	resp, err = http.Get(server.URL + "/v1/regions/cn-hangzhou/rank?output=yaml&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/yaml" {
+		t.Errorf("content type of yaml is %s", resp.Header.Get("Content-Type"))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := strings.Count("\n"+string(data), "\n- "); records != 1 {
+		t.Errorf("yaml has %d records, want 1:\n%s", records, data)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	s := newReplayServer(t, "cn-hangzhou")
+	load(s)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		err    string
+	}{
+		{"GET", "/v1/regions/cn-hangzhou/rank?mincpu=two", http.StatusBadRequest, `invalid mincpu "two"`},
+		{"GET", "/v1/regions/cn-hangzhou/rank?limit=-1", http.StatusBadRequest, "invalid limit -1"},
+		{"GET", "/v1/regions/cn-hangzhou/rank?output=table", http.StatusBadRequest, "unknown output table"},
+		{"GET", "/v1/regions/cn-hangzhou/rank?sort=hourly", http.StatusBadRequest, "needs the bill of a node"},
+		{"GET", "/v1/regions/cn-beijing/rank", http.StatusNotFound, "region cn-beijing is not served"},
+		{"GET", "/v1/regions/cn-hangzhou/history", http.StatusNotFound, "is not found"},
+		{"POST", "/v1/regions/cn-hangzhou/rank", http.StatusMethodNotAllowed, "method POST is not allowed"},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, server.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || !strings.Contains(body["Error"], c.err) {
+			t.Errorf("%s %s: %d %q, want %d %q", c.method, c.path, resp.StatusCode, body["Error"], c.status, c.err)
+		}
+	}
+}
@@ -4,18 +4,32 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
-	"math"
 	"time"
 )
 
 // data structure of instance prices
 type InstancePrice struct {
 	ecsService.InstanceType
-	ZoneId       string
-	PricePerCore float64
-	Price        string
-	Discount     float64
-	Possibility  float64
+	RegionId       string
+	ZoneId         string
+	Stock          string // stock of the pool, see IsLaunchable
+	PricePerCore   float64
+	PricePerMemory float64 // spot price per GiB of memory
+	Price          string
+	SpotPrice      float64
+	OriginPrice    float64
+	Discount       float64
+	Stats          PriceStats
+	Risk           float64
+	Forecast       Forecast  // the predicted spot price, see AnalysisOptions.Forecast
+	Bid            BidAdvice // the recommended price limit, see AnalysisOptions.Bid
+	Cost           PoolCost  // the full cost of a node, see MetaStore.TCO
+	Score          float64   // lower is better, set by the RankModel
+}
+
+// Launchable reports whether the pool has stock for a spot instance.
+func (ip InstancePrice) Launchable() bool {
+	return IsLaunchable(ip.Stock)
 }
 
 // sorted structure of
@@ -25,23 +39,47 @@ func (sp SortedInstancePrices) Len() int {
 	return len(sp)
 }
 
+// Less orders by score, ties are broken by price per core, instanceType and zone
+// so the rank is deterministic.
 func (sp SortedInstancePrices) Less(i, j int) bool {
-	return sp[i].PricePerCore < sp[j].PricePerCore
+	if sp[i].Score != sp[j].Score {
+		return sp[i].Score < sp[j].Score
+	}
+	if sp[i].PricePerCore != sp[j].PricePerCore {
+		return sp[i].PricePerCore < sp[j].PricePerCore
+	}
+	if sp[i].InstanceTypeId != sp[j].InstanceTypeId {
+		return sp[i].InstanceTypeId < sp[j].InstanceTypeId
+	}
+	return sp[i].ZoneId < sp[j].ZoneId
 }
 
 func (sp SortedInstancePrices) Swap(i, j int) {
 	sp[i], sp[j] = sp[j], sp[i]
 }
 
-func CreateInstancePrice(meta ecsService.InstanceType, zoneId string, prices []ecsService.SpotPriceType) InstancePrice {
+func CreateInstancePrice(meta ecsService.InstanceType, zoneId string, prices []ecsService.SpotPriceType, opts AnalysisOptions) InstancePrice {
 	latestPrice := FindLatestPrice(prices)
+	stats := CalculatePriceStats(prices, opts)
+	risk, _ := stats.Risk(opts.RiskMetric)
 	ip := InstancePrice{
-		InstanceType: meta,
-		ZoneId:       zoneId,
-		PricePerCore: latestPrice.SpotPrice / float64(meta.CpuCoreCount),
-		Price:        fmt.Sprintf("%f", latestPrice.SpotPrice),
-		Discount:     10 * latestPrice.SpotPrice / latestPrice.OriginPrice,
-		Possibility:  GetPossibility(prices),
+		InstanceType:   meta,
+		ZoneId:         zoneId,
+		PricePerCore:   latestPrice.SpotPrice / float64(meta.CpuCoreCount),
+		PricePerMemory: latestPrice.SpotPrice / meta.MemorySize,
+		Price:          fmt.Sprintf("%f", latestPrice.SpotPrice),
+		SpotPrice:      latestPrice.SpotPrice,
+		OriginPrice:    latestPrice.OriginPrice,
+		Discount:       10 * latestPrice.SpotPrice / latestPrice.OriginPrice,
+		Stats:          stats,
+		Risk:           risk,
+		Score:          latestPrice.SpotPrice / float64(meta.CpuCoreCount),
+	}
+	if opts.Forecast.Model != "" {
+		ip.Forecast = ForecastPrice(prices, opts.Window, opts.Forecast)
+	}
+	if opts.Bid.Coverage > 0 {
+		ip.Bid = RecommendBid(prices, opts.Window, opts.Bid)
 	}
 	return ip
 }
@@ -71,16 +109,3 @@ func FindLatestPrice(prices []ecsService.SpotPriceType) ecsService.SpotPriceType
 
 	return latestPrice
 }
-
-func GetPossibility(prices []ecsService.SpotPriceType) float64 {
-	variance := 0.0
-	sigma := 0.0
-
-	for _, price := range prices {
-		variance += math.Pow((price.SpotPrice - 0.1*price.OriginPrice), 2)
-	}
-
-	sigma = math.Sqrt(variance / float64(len(prices)))
-
-	return sigma
-}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	RiskStdDev  = "stddev"
+	RiskCV      = "cv"
+	RiskSpike   = "spike"
+	RiskChanges = "changes"
+	RiskAbove   = "above"
+)
+
+// options of the price history analysis
+type AnalysisOptions struct {
+	Window        PriceWindow
+	RiskMetric    string
+	RiskThreshold float64
+	// the forecast of every pool, none without a model
+	Forecast ForecastOptions
+	// the recommended price limit of every pool, none without a coverage
+	Bid BidOptions
+}
+
+// statistics of the price history of one pool
+type PriceStats struct {
+	// time weighted mean and standard deviation of the spot price
+	Mean   float64
+	StdDev float64
+	// coefficient of variation, StdDev / Mean, comparable across instance sizes
+	CV float64
+	// highest spot price over the mean, 0.5 means 50% above the mean
+	MaxSpike float64
+	// price changes per day of the window
+	ChangesPerDay float64
+	// fraction of the history the spot price stayed above RiskThreshold * OriginPrice
+	TimeAboveThreshold float64
+}
+
+// a price point with the parsed timestamp
+type pricePoint struct {
+	time   time.Time
+	price  float64
+	origin float64
+}
+
+// parsePricePoints orders the prices by time, the prices without a valid timestamp are dropped.
+func parsePricePoints(prices []ecsService.SpotPriceType) []pricePoint {
+	points := make([]pricePoint, 0, len(prices))
+	for _, price := range prices {
+		t, err := time.Parse(time.RFC3339, price.Timestamp)
+		if err != nil {
+			continue
+		}
+		points = append(points, pricePoint{time: t, price: price.SpotPrice, origin: price.OriginPrice})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].time.Before(points[j].time)
+	})
+	return points
+}
+
+// CalculatePriceStats treats the history as a step function: every price holds
+// until the next one, the last one holds until the end of the window.
+func CalculatePriceStats(prices []ecsService.SpotPriceType, opts AnalysisOptions) PriceStats {
+	stats := PriceStats{}
+
+	points := parsePricePoints(prices)
+	if len(points) == 0 {
+		return stats
+	}
+
+	end := opts.Window.End
+	if end.IsZero() || end.Before(points[len(points)-1].time) {
+		end = points[len(points)-1].time
+	}
+	start := opts.Window.Start
+	if start.IsZero() || start.After(points[0].time) {
+		start = points[0].time
+	}
+
+	// weight of every point in seconds, equal weights if the history has no duration
+	weights := make([]float64, len(points))
+	total := 0.0
+	for i, point := range points {
+		next := end
+		if i+1 < len(points) {
+			next = points[i+1].time
+		}
+		weights[i] = next.Sub(point.time).Seconds()
+		total += weights[i]
+	}
+	if total <= 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(len(weights))
+	}
+
+	max := 0.0
+	above := 0.0
+	changes := 0
+	for i, point := range points {
+		stats.Mean += point.price * weights[i] / total
+		max = math.Max(max, point.price)
+		if point.price > opts.RiskThreshold*point.origin {
+			above += weights[i]
+		}
+		if i > 0 && point.price != points[i-1].price {
+			changes++
+		}
+	}
+
+	variance := 0.0
+	for i, point := range points {
+		variance += math.Pow(point.price-stats.Mean, 2) * weights[i] / total
+	}
+	stats.StdDev = math.Sqrt(variance)
+
+	if stats.Mean > 0 {
+		stats.CV = stats.StdDev / stats.Mean
+		stats.MaxSpike = max/stats.Mean - 1
+	}
+	if days := end.Sub(start).Hours() / 24; days > 0 {
+		stats.ChangesPerDay = float64(changes) / days
+	}
+	stats.TimeAboveThreshold = above / total
+
+	return stats
+}
+
+// Risk returns the metric that drives the risk column.
+func (stats PriceStats) Risk(metric string) (float64, error) {
+	switch metric {
+	case RiskStdDev:
+		return stats.StdDev, nil
+	case RiskCV, "":
+		return stats.CV, nil
+	case RiskSpike:
+		return stats.MaxSpike, nil
+	case RiskChanges:
+		return stats.ChangesPerDay, nil
+	case RiskAbove:
+		return stats.TimeAboveThreshold, nil
+	}
+	return 0, fmt.Errorf("unknown risk metric %s, use one of stddev,cv,spike,changes,above", metric)
+}
+
+// DefaultAnalysisOptions is used unless the caller overrides it.
+func DefaultAnalysisOptions() AnalysisOptions {
+	return AnalysisOptions{
+		RiskMetric:    RiskCV,
+		RiskThreshold: 0.5,
+		Forecast:      DefaultForecastOptions(),
+		Bid:           DefaultBidOptions(),
+	}
+}
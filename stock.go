@@ -0,0 +1,33 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+const (
+	StockWithStock       = "WithStock"
+	StockClosedWithStock = "ClosedWithStock"
+	StockWithoutStock    = "WithoutStock"
+	StockSoldOut         = "SoldOut"
+	StockNotOffered      = "NotOffered"
+)
+
+// StockOf folds the status of the zone and of the resource into one stock,
+// a zone or resource that is not Available reports its own status.
+func StockOf(zone ecsService.AvailableZone, resource ecsService.SupportedResource) string {
+	if zone.Status != "" && zone.Status != "Available" {
+		return zone.Status
+	}
+	if resource.Status != "" && resource.Status != "Available" {
+		return resource.Status
+	}
+	if resource.StatusCategory == "" {
+		return StockWithStock
+	}
+	return resource.StatusCategory
+}
+
+// IsLaunchable reports whether a spot instance can be created with the stock.
+func IsLaunchable(stock string) bool {
+	return stock == StockWithStock || stock == StockClosedWithStock
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StoreFileSuffix     = ".jsonl"
+	WatermarkFileSuffix = ".watermark"
+)
+
+// PriceStore is an append only time series of spot prices on the local disk.
+//
+// Every instanceType of a region has its own file <dir>/<region>/<instanceType>.jsonl
+// with one SpotPriceType per line. A point is only appended when the price of its
+// zone changed, the price in between is the last one recorded before.
+// <instanceType>.watermark holds the end of the last window recorded for the instanceType.
+type PriceStore struct {
+	Dir  string
+	lock sync.Mutex
+}
+
+// Append records the new points of the prices and returns how many were written.
+func (ps *PriceStore) Append(region, instanceType string, prices []ecsService.SpotPriceType) (int, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	path := ps.path(region, instanceType)
+	recorded, err := ps.read(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// last recorded point of every zone
+	last := make(map[string]ecsService.SpotPriceType)
+	for _, price := range recorded {
+		last[price.ZoneId] = price
+	}
+
+	sorted := make([]ecsService.SpotPriceType, len(prices))
+	copy(sorted, prices)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	lines := make([]string, 0)
+	for _, price := range sorted {
+		previous, ok := last[price.ZoneId]
+		if ok && (price.Timestamp <= previous.Timestamp ||
+			price.SpotPrice == previous.SpotPrice && price.OriginPrice == previous.OriginPrice) {
+			continue
+		}
+		data, err := json.Marshal(price)
+		if err != nil {
+			return 0, err
+		}
+		lines = append(lines, string(data))
+		last[price.ZoneId] = price
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return 0, err
+	}
+	return len(lines), nil
+}
+
+// Query returns the points of the window. The last point of every zone before
+// the window is moved to its start, so the price holding at the start is kept.
+func (ps *PriceStore) Query(region, instanceType string, window PriceWindow) ([]ecsService.SpotPriceType, error) {
+	ps.lock.Lock()
+	recorded, err := ps.read(ps.path(region, instanceType))
+	ps.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return slicePrices(recorded, window), nil
+}
+
+// slicePrices keeps the prices of the window, the last price of every zone before the window
+// is moved to its start so the price at the start is known.
+func slicePrices(recorded []ecsService.SpotPriceType, window PriceWindow) []ecsService.SpotPriceType {
+	before := make(map[string]ecsService.SpotPriceType)
+	prices := make([]ecsService.SpotPriceType, 0)
+	for _, price := range recorded {
+		t, err := time.Parse(time.RFC3339, price.Timestamp)
+		if err != nil {
+			continue
+		}
+		switch {
+		case t.Before(window.Start):
+			if last, ok := before[price.ZoneId]; !ok || last.Timestamp <= price.Timestamp {
+				before[price.ZoneId] = price
+			}
+		case !t.After(window.End):
+			prices = append(prices, price)
+		}
+	}
+
+	for _, price := range before {
+		price.Timestamp = window.StartTime()
+		prices = append(prices, price)
+	}
+	sort.SliceStable(prices, func(i, j int) bool {
+		return prices[i].Timestamp < prices[j].Timestamp
+	})
+	return prices
+}
+
+// Watermark is the end of the last window recorded for the instanceType, zero if none.
+// A store without the watermark falls back to the newest point of the instanceType.
+func (ps *PriceStore) Watermark(region, instanceType string) (time.Time, error) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	watermark := time.Time{}
+	data, err := ioutil.ReadFile(ps.watermarkPath(region, instanceType))
+	if err == nil {
+		return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return watermark, err
+	}
+
+	recorded, err := ps.read(ps.path(region, instanceType))
+	if err != nil {
+		return watermark, err
+	}
+	for _, price := range recorded {
+		if t, err := time.Parse(time.RFC3339, price.Timestamp); err == nil && t.After(watermark) {
+			watermark = t
+		}
+	}
+	return watermark, nil
+}
+
+// SetWatermark records that the prices of the instanceType are complete up to t.
+func (ps *PriceStore) SetWatermark(region, instanceType string, t time.Time) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	path := ps.watermarkPath(region, instanceType)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(t.UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+func (ps *PriceStore) path(region, instanceType string) string {
+	return filepath.Join(ps.Dir, region, instanceType+StoreFileSuffix)
+}
+
+func (ps *PriceStore) watermarkPath(region, instanceType string) string {
+	return filepath.Join(ps.Dir, region, instanceType+WatermarkFileSuffix)
+}
+
+// read loads every point of the file, a missing file has none.
+func (ps *PriceStore) read(path string) ([]ecsService.SpotPriceType, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prices := make([]ecsService.SpotPriceType, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		price := ecsService.SpotPriceType{}
+		if err := json.Unmarshal(scanner.Bytes(), &price); err != nil {
+			return nil, fmt.Errorf("invalid point at %s:%d: %v", path, line, err)
+		}
+		prices = append(prices, price)
+	}
+	return prices, scanner.Err()
+}
+
+// StoreClient serves the price history from the PriceStore, the other apis
+// still go to the wrapped client.
+type StoreClient struct {
+	EcsClient
+	Store  *PriceStore
+	Region string
+}
+
+func (sc *StoreClient) DescribeSpotPriceHistory(request *ecsService.DescribeSpotPriceHistoryRequest) (*ecsService.DescribeSpotPriceHistoryResponse, error) {
+	resp := ecsService.CreateDescribeSpotPriceHistoryResponse()
+	// the whole history is served as the first page
+	if offset := string(request.Offset); offset != "" && offset != "0" {
+		return resp, nil
+	}
+
+	window, err := NewPriceWindow(request.StartTime, request.EndTime, 0)
+	if err != nil {
+		return resp, err
+	}
+	prices, err := sc.Store.Query(sc.Region, request.InstanceType, window)
+	if err != nil {
+		return resp, err
+	}
+	resp.SpotPrices.SpotPriceType = prices
+	return resp, nil
+}
+
+// DescribePrice goes to the wrapped client.
+func (sc *StoreClient) DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error) {
+	client, ok := sc.EcsClient.(PricingClient)
+	if !ok {
+		return nil, fmt.Errorf("client of region %s can not describe price", sc.Region)
+	}
+	return client.DescribePrice(request)
+}
+
+// NewStoreClient reads the price history of the region from the store under dir.
+func NewStoreClient(client EcsClient, dir, region string) *StoreClient {
+	return &StoreClient{EcsClient: client, Store: &PriceStore{Dir: dir}, Region: region}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func storePoint(zone, timestamp string, price float64) ecsService.SpotPriceType {
+	return ecsService.SpotPriceType{ZoneId: zone, InstanceType: "ecs.c6.large", Timestamp: timestamp, SpotPrice: price, OriginPrice: 0.39}
+}
+
+func TestPriceStoreAppend(t *testing.T) {
+	store := &PriceStore{Dir: t.TempDir()}
+
+	cases := []struct {
+		name    string
+		prices  []ecsService.SpotPriceType
+		written int
+	}{
+		{"first points out of order", []ecsService.SpotPriceType{
+			storePoint("cn-hangzhou-h", "2026-10-11T12:00:00Z", 0.05),
+			storePoint("cn-hangzhou-h", "2026-10-11T00:00:00Z", 0.04),
+			storePoint("cn-hangzhou-i", "2026-10-11T00:00:00Z", 0.06),
+		}, 3},
+		{"same points again", []ecsService.SpotPriceType{
+			storePoint("cn-hangzhou-h", "2026-10-11T12:00:00Z", 0.05),
+			storePoint("cn-hangzhou-i", "2026-10-11T00:00:00Z", 0.06),
+		}, 0},
+		// the price did not change since the last point of the zone
+		{"unchanged price", []ecsService.SpotPriceType{
+			storePoint("cn-hangzhou-h", "2026-10-12T00:00:00Z", 0.05),
+			storePoint("cn-hangzhou-i", "2026-10-12T00:00:00Z", 0.06),
+		}, 0},
+		{"older than the last point", []ecsService.SpotPriceType{
+			storePoint("cn-hangzhou-h", "2026-10-10T00:00:00Z", 0.09),
+		}, 0},
+		{"changed price", []ecsService.SpotPriceType{
+			storePoint("cn-hangzhou-h", "2026-10-12T12:00:00Z", 0.05),
+			storePoint("cn-hangzhou-h", "2026-10-13T00:00:00Z", 0.07),
+			storePoint("cn-hangzhou-h", "2026-10-13T12:00:00Z", 0.07),
+			storePoint("cn-hangzhou-i", "2026-10-13T00:00:00Z", 0.06),
+		}, 1},
+		{"nothing", nil, 0},
+	}
+	for _, c := range cases {
+		written, err := store.Append("cn-hangzhou", "ecs.c6.large", c.prices)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if written != c.written {
+			t.Errorf("%s: %d points are written, want %d", c.name, written, c.written)
+		}
+	}
+
+	recorded, err := store.read(store.path("cn-hangzhou", "ecs.c6.large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"cn-hangzhou-h 2026-10-11T00:00:00Z",
+		"cn-hangzhou-i 2026-10-11T00:00:00Z",
+		"cn-hangzhou-h 2026-10-11T12:00:00Z",
+		"cn-hangzhou-h 2026-10-13T00:00:00Z",
+	}
+	if len(recorded) != len(want) {
+		t.Fatalf("%d points are recorded, want %d", len(recorded), len(want))
+	}
+	for i, price := range recorded {
+		if got := price.ZoneId + " " + price.Timestamp; got != want[i] {
+			t.Errorf("point %d is %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestPriceStoreQuery(t *testing.T) {
+	store := &PriceStore{Dir: t.TempDir()}
+	if _, err := store.Append("cn-hangzhou", "ecs.c6.large", []ecsService.SpotPriceType{
+		storePoint("cn-hangzhou-h", "2026-10-11T00:00:00Z", 0.04),
+		storePoint("cn-hangzhou-h", "2026-10-12T00:00:00Z", 0.05),
+		storePoint("cn-hangzhou-h", "2026-10-14T00:00:00Z", 0.06),
+		storePoint("cn-hangzhou-i", "2026-10-13T00:00:00Z", 0.07),
+		storePoint("cn-hangzhou-h", "2026-10-16T00:00:00Z", 0.08),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	window, err := NewPriceWindow("2026-10-13T00:00:00Z", "2026-10-15T00:00:00Z", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices, err := store.Query("cn-hangzhou", "ecs.c6.large", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the price of zone h holding at the start is moved to it
+	want := []ecsService.SpotPriceType{
+		storePoint("cn-hangzhou-i", "2026-10-13T00:00:00Z", 0.07),
+		storePoint("cn-hangzhou-h", "2026-10-13T00:00:00Z", 0.05),
+		storePoint("cn-hangzhou-h", "2026-10-14T00:00:00Z", 0.06),
+	}
+	if len(prices) != len(want) {
+		t.Fatalf("%d prices in the window, want %d: %v", len(prices), len(want), prices)
+	}
+	for i := range want {
+		if prices[i].Timestamp != want[i].Timestamp || prices[i].SpotPrice != want[i].SpotPrice {
+			t.Errorf("price %d is %s %v, want %s %v", i, prices[i].Timestamp, prices[i].SpotPrice, want[i].Timestamp, want[i].SpotPrice)
+		}
+	}
+
+	// nothing recorded is no error
+	if prices, err := store.Query("cn-hangzhou", "ecs.g6.large", window); err != nil || len(prices) != 0 {
+		t.Errorf("query of nothing recorded is %v, %v", prices, err)
+	}
+}
+
+func TestPriceStoreWatermark(t *testing.T) {
+	store := &PriceStore{Dir: t.TempDir()}
+
+	watermark, err := store.Watermark("cn-hangzhou", "ecs.c6.large")
+	if err != nil || !watermark.IsZero() {
+		t.Errorf("watermark of nothing recorded is %v, %v", watermark, err)
+	}
+
+	// the newest point without a watermark file
+	if _, err := store.Append("cn-hangzhou", "ecs.c6.large", []ecsService.SpotPriceType{
+		storePoint("cn-hangzhou-h", "2026-10-12T00:00:00Z", 0.05),
+		storePoint("cn-hangzhou-i", "2026-10-13T06:00:00Z", 0.06),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	watermark, err = store.Watermark("cn-hangzhou", "ecs.c6.large")
+	if err != nil || !watermark.Equal(time.Date(2026, 10, 13, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("watermark of the newest point is %v, %v", watermark, err)
+	}
+
+	// the watermark file wins over the points and round-trips in utc
+	end := time.Date(2026, 10, 18, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	if err := store.SetWatermark("cn-hangzhou", "ecs.c6.large", end); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(store.watermarkPath("cn-hangzhou", "ecs.c6.large"))
+	if err != nil || string(data) != "2026-10-18T00:00:00Z\n" {
+		t.Errorf("watermark file is %q, %v", data, err)
+	}
+	watermark, err = store.Watermark("cn-hangzhou", "ecs.c6.large")
+	if err != nil || !watermark.Equal(end) {
+		t.Errorf("watermark is %v, %v, want %v", watermark, err, end)
+	}
+}
+
+func TestPriceStoreInvalidPoint(t *testing.T) {
+	store := &PriceStore{Dir: t.TempDir()}
+	path := store.path("cn-hangzhou", "ecs.c6.large")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("{\"ZoneId\":\"cn-hangzhou-h\"}\n\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.Append("cn-hangzhou", "ecs.c6.large", nil)
+	if err == nil || !strings.Contains(err.Error(), "ecs.c6.large.jsonl:3") {
+		t.Errorf("error is %v, want the line of the invalid point", err)
+	}
+}
+
+func TestStoreClient(t *testing.T) {
+	dir := t.TempDir()
+	client := NewStoreClient(NewFakeClient("testdata", "cn-hangzhou"), dir, "cn-hangzhou")
+	if _, err := client.Store.Append("cn-hangzhou", "ecs.c6.large", []ecsService.SpotPriceType{
+		storePoint("cn-hangzhou-h", "2026-10-11T00:00:00Z", 0.04),
+		storePoint("cn-hangzhou-h", "2026-10-12T00:00:00Z", 0.05),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := priceHistoryRequest("ecs.c6.large", "2026-10-11T12:00:00Z", "2026-10-18T00:00:00Z")
+	resp, err := client.DescribeSpotPriceHistory(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(resp.SpotPrices.SpotPriceType); n != 2 || resp.NextOffset != 0 {
+		t.Errorf("first page has %d prices and next offset %d", n, resp.NextOffset)
+	}
+
+	// the whole history is the first page
+	request.Offset = requests.NewInteger(16)
+	if resp, err := client.DescribeSpotPriceHistory(request); err != nil || len(resp.SpotPrices.SpotPriceType) != 0 {
+		t.Errorf("later page is %v, %v", resp.SpotPrices.SpotPriceType, err)
+	}
+
+	// the other apis go to the wrapped client
+	if resp, err := client.DescribeInstanceTypes(ecsService.CreateDescribeInstanceTypesRequest()); err != nil || len(resp.InstanceTypes.InstanceType) == 0 {
+		t.Errorf("instanceTypes of the wrapped client: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// 365 days of 24 hours over 12 months
+	HoursPerMonth = 730
+
+	PayByTraffic   = "PayByTraffic"
+	PayByBandwidth = "PayByBandwidth"
+
+	// the item of DescribePrice priced by the spot price instead
+	instanceTypeResource = "instanceType"
+	bandwidthResource    = "bandwidth"
+)
+
+// The ecs api to price the resources of a node.
+// *ecsService.Client satisfies it, so does FakeClient.
+type PricingClient interface {
+	DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error)
+}
+
+// a data disk of the node
+type DataDisk struct {
+	Category string
+	Size     int
+}
+
+// the resources of a node besides its instance, priced by DescribePrice
+type NodeBill struct {
+	SystemDiskCategory string
+	SystemDiskSize     int
+	// at most 4 data disks
+	DataDisks          []DataDisk
+	InternetChargeType string
+	// InternetMaxBandwidthOut in Mbps, 0 means no public ip
+	Bandwidth int
+	// the license of a paid image is part of the bill
+	ImageId string
+	// outbound GB per month, PayByTraffic is billed by the GB instead of the hour
+	TrafficGB float64
+}
+
+// options of the total cost of ownership of every pool
+type TCOOptions struct {
+	Bill NodeBill
+	// currency of the costs, the one of the account when empty
+	Currency string
+	// value of one unit of every account currency in Currency
+	Rates map[string]float64
+}
+
+// the hourly cost of the resources of a node besides its instance, in the currency of the account
+type NodeCost struct {
+	Currency string
+	Hourly   float64
+}
+
+// the full cost of a node of the pool, in the currency of TCOOptions
+type PoolCost struct {
+	Currency string  `json:"Currency"`
+	Node     float64 `json:"Node"`
+	// spot price plus the node
+	Hourly  float64 `json:"Hourly"`
+	Monthly float64 `json:"Monthly"`
+}
+
+// Validate checks the bill.
+func (b NodeBill) Validate() error {
+	if len(b.DataDisks) > 4 {
+		return fmt.Errorf("%d data disks are given but a node takes at most 4", len(b.DataDisks))
+	}
+	switch b.InternetChargeType {
+	case PayByTraffic, PayByBandwidth:
+	default:
+		return fmt.Errorf("unknown internet charge type %s, use one of %s,%s", b.InternetChargeType, PayByTraffic, PayByBandwidth)
+	}
+	if b.Bandwidth < 0 || b.TrafficGB < 0 {
+		return fmt.Errorf("bandwidth %d and traffic %g can not be negative", b.Bandwidth, b.TrafficGB)
+	}
+	return nil
+}
+
+// Request prices the bill with the instanceType by the hour.
+func (b NodeBill) Request(region string, instanceType string) *ecsService.DescribePriceRequest {
+	req := ecsService.CreateDescribePriceRequest()
+	req.RegionId = region
+	req.ResourceType = "instance"
+	req.InstanceType = instanceType
+	req.InstanceNetworkType = "vpc"
+	req.IoOptimized = "optimized"
+	req.PriceUnit = "Hour"
+	req.ImageId = b.ImageId
+	req.SystemDiskCategory = b.SystemDiskCategory
+	if b.SystemDiskSize > 0 {
+		req.SystemDiskSize = requests.NewInteger(b.SystemDiskSize)
+	}
+	req.InternetChargeType = b.InternetChargeType
+	req.InternetMaxBandwidthOut = requests.NewInteger(b.Bandwidth)
+
+	for index, disk := range b.DataDisks {
+		size := requests.NewInteger(disk.Size)
+		switch index {
+		case 0:
+			req.DataDisk1Category, req.DataDisk1Size = disk.Category, size
+		case 1:
+			req.DataDisk2Category, req.DataDisk2Size = disk.Category, size
+		case 2:
+			req.DataDisk3Category, req.DataDisk3Size = disk.Category, size
+		case 3:
+			req.DataDisk4Category, req.DataDisk4Size = disk.Category, size
+		}
+	}
+	return req
+}
+
+// Cost sums every item of the price except the instance, which is paid by the spot price.
+// The bandwidth of PayByTraffic is priced by the GB and spread over the hours of a month.
+func (b NodeBill) Cost(price ecsService.Price) (NodeCost, error) {
+	items := price.DetailInfos.ResourcePriceModel
+	if len(items) == 0 {
+		return NodeCost{}, fmt.Errorf("price of %s has no detail of its resources", price.Currency)
+	}
+	cost := NodeCost{Currency: price.Currency}
+	for _, item := range items {
+		switch item.Resource {
+		case instanceTypeResource:
+		case bandwidthResource:
+			if b.InternetChargeType == PayByTraffic {
+				cost.Hourly += item.TradePrice * b.TrafficGB / HoursPerMonth
+			} else {
+				cost.Hourly += item.TradePrice
+			}
+		default:
+			cost.Hourly += item.TradePrice
+		}
+	}
+	return cost, nil
+}
+
+// Rate converts one unit of the currency to the currency of the costs.
+func (opts TCOOptions) Rate(currency string) (float64, error) {
+	if opts.Currency == "" || strings.EqualFold(opts.Currency, currency) {
+		return 1, nil
+	}
+	rate, ok := opts.Rates[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate of %s to %s", currency, opts.Currency)
+	}
+	return rate, nil
+}
+
+// PoolCost adds the node to the spot price and projects it over a month.
+func (opts TCOOptions) PoolCost(spotPrice float64, node NodeCost) (PoolCost, error) {
+	rate, err := opts.Rate(node.Currency)
+	if err != nil {
+		return PoolCost{}, err
+	}
+	currency := opts.Currency
+	if currency == "" {
+		currency = node.Currency
+	}
+	hourly := rate * (spotPrice + node.Hourly)
+	return PoolCost{
+		Currency: strings.ToUpper(currency),
+		Node:     rate * node.Hourly,
+		Hourly:   hourly,
+		Monthly:  hourly * HoursPerMonth,
+	}, nil
+}
+
+// NodeCost returns the fetched cost of the node of the instanceType.
+func (ms *MetaStore) NodeCost(instanceType string) (NodeCost, bool) {
+	ms.costLock.Lock()
+	defer ms.costLock.Unlock()
+	cost, ok := ms.NodeCosts[instanceType]
+	return cost, ok
+}
+
+// FetchNodeCosts prices the bill with every instanceType not priced yet, the failed ones are left out.
+// The lock is not held while DescribePrice is called, so NodeCost is not blocked by the fetch,
+// and concurrent analyses of the metastore may price the same instanceType twice.
+func (ms *MetaStore) FetchNodeCosts(instanceTypes []string) {
+	client, ok := ms.EcsClient.(PricingClient)
+	if !ok {
+		log.Warnf("Failed to fetch node costs in %s,because the client can not DescribePrice", ms.Region)
+		return
+	}
+
+	ms.costLock.Lock()
+	missing := make([]string, 0, len(instanceTypes))
+	for _, instanceType := range instanceTypes {
+		if _, ok := ms.NodeCosts[instanceType]; !ok {
+			missing = append(missing, instanceType)
+		}
+	}
+	ms.costLock.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	fetched := make(map[string]NodeCost)
+	lock := sync.Mutex{}
+	results := ms.FetchOptions.Run(missing, func(instanceType string, call Caller) error {
+		var resp *ecsService.DescribePriceResponse
+		err := call(func() (err error) {
+			resp, err = client.DescribePrice(ms.TCO.Bill.Request(ms.Region, instanceType))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		cost, err := ms.TCO.Bill.Cost(resp.PriceInfo.Price)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		fetched[instanceType] = cost
+		lock.Unlock()
+		return nil
+	})
+	ms.costLock.Lock()
+	for instanceType, cost := range fetched {
+		ms.NodeCosts[instanceType] = cost
+	}
+	ms.costLock.Unlock()
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			log.Warnf("Failed to fetch node cost of %s after %d attempts,because of %v", result.InstanceType, result.Attempts, result.Err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Fetch %d of %d kinds of InstanceTypes node costs in %s successfully, %d failed.\n", len(missing)-failed, len(missing), ms.Region, failed)
+}
+
+// ParseDataDisks reads "category:size" pairs separated by comma.
+func ParseDataDisks(value string) ([]DataDisk, error) {
+	disks := make([]DataDisk, 0)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid data disk %q, expect category:size", pair)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size of data disk %q", pair)
+		}
+		disks = append(disks, DataDisk{Category: strings.TrimSpace(kv[0]), Size: size})
+	}
+	return disks, nil
+}
+
+// ParseRates reads "currency=rate" pairs separated by comma.
+func ParseRates(value string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid exchange rate %q, expect currency=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid exchange rate %q", pair)
+		}
+		rates[strings.ToUpper(strings.TrimSpace(kv[0]))] = rate
+	}
+	return rates, nil
+}
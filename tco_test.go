@@ -0,0 +1,262 @@
+package main
+
+import (
+	ecsService "github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func (cc *countingClient) DescribePrice(request *ecsService.DescribePriceRequest) (*ecsService.DescribePriceResponse, error) {
+	cc.count("DescribePrice")
+	return cc.FakeClient.DescribePrice(request)
+}
+
+// replayedPrice is the recorded DescribePrice of the instanceType in cn-hangzhou:
+// 0.0548 for the system disk and 0.8 for the bandwidth besides the instance.
+func replayedPrice(t *testing.T, instanceType string) ecsService.Price {
+	t.Helper()
+	resp, err := NewFakeClient("testdata", "cn-hangzhou").DescribePrice(NodeBill{}.Request("cn-hangzhou", instanceType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.PriceInfo.Price
+}
+
+func TestNodeBillCost(t *testing.T) {
+	price := replayedPrice(t, "ecs.c6.large")
+	cases := []struct {
+		name string
+		bill NodeBill
+		want float64
+	}{
+		// the instanceType item of 0.39 is left to the spot price
+		{"bandwidth", NodeBill{InternetChargeType: PayByBandwidth, Bandwidth: 5}, 0.0548 + 0.8},
+		// 0.8 a GB, 146 GB a month
+		{"traffic", NodeBill{InternetChargeType: PayByTraffic, Bandwidth: 100, TrafficGB: 146}, 0.0548 + 0.8*146/HoursPerMonth},
+		{"no traffic", NodeBill{InternetChargeType: PayByTraffic, Bandwidth: 100}, 0.0548},
+	}
+	for _, c := range cases {
+		cost, err := c.bill.Cost(price)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if cost.Currency != "CNY" || !closeTo(cost.Hourly, c.want) {
+			t.Errorf("%s: cost %+v, want %g CNY", c.name, cost, c.want)
+		}
+	}
+
+	price.DetailInfos.ResourcePriceModel = nil
+	if _, err := (NodeBill{InternetChargeType: PayByTraffic}).Cost(price); err == nil || !strings.Contains(err.Error(), "has no detail of its resources") {
+		t.Errorf("error of a price without detail is %v", err)
+	}
+}
+
+func TestNodeBillValidate(t *testing.T) {
+	disk := DataDisk{Category: "cloud_essd", Size: 100}
+	cases := []struct {
+		bill NodeBill
+		err  string
+	}{
+		{NodeBill{InternetChargeType: PayByTraffic}, ""},
+		{NodeBill{InternetChargeType: PayByBandwidth, Bandwidth: 10, DataDisks: []DataDisk{disk, disk, disk, disk}}, ""},
+		{NodeBill{InternetChargeType: PayByTraffic, DataDisks: []DataDisk{disk, disk, disk, disk, disk}}, "5 data disks are given but a node takes at most 4"},
+		{NodeBill{InternetChargeType: "PayByHour"}, "unknown internet charge type PayByHour"},
+		{NodeBill{InternetChargeType: PayByTraffic, TrafficGB: -1}, "can not be negative"},
+	}
+	for _, c := range cases {
+		err := c.bill.Validate()
+		if c.err == "" && err != nil || c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("validate %+v: %v, want %q", c.bill, err, c.err)
+		}
+	}
+}
+
+func TestNodeBillRequest(t *testing.T) {
+	bill := NodeBill{
+		SystemDiskCategory: "cloud_essd",
+		SystemDiskSize:     40,
+		DataDisks:          []DataDisk{{Category: "cloud_efficiency", Size: 100}, {Category: "cloud_essd", Size: 200}},
+		InternetChargeType: PayByBandwidth,
+		Bandwidth:          5,
+	}
+	req := bill.Request("cn-hangzhou", "ecs.c6.large")
+	if req.RegionId != "cn-hangzhou" || req.InstanceType != "ecs.c6.large" || req.PriceUnit != "Hour" || req.ResourceType != "instance" {
+		t.Errorf("request of %s in %s by the %s", req.InstanceType, req.RegionId, req.PriceUnit)
+	}
+	if req.SystemDiskCategory != "cloud_essd" || req.SystemDiskSize != "40" || req.InternetMaxBandwidthOut != "5" {
+		t.Errorf("system disk %s of %s and bandwidth %s", req.SystemDiskCategory, req.SystemDiskSize, req.InternetMaxBandwidthOut)
+	}
+	if req.DataDisk1Category != "cloud_efficiency" || req.DataDisk1Size != "100" || req.DataDisk2Category != "cloud_essd" || req.DataDisk2Size != "200" || req.DataDisk3Category != "" {
+		t.Errorf("data disks %s:%s %s:%s %s", req.DataDisk1Category, req.DataDisk1Size, req.DataDisk2Category, req.DataDisk2Size, req.DataDisk3Category)
+	}
+}
+
+func TestTCOOptionsRate(t *testing.T) {
+	opts := TCOOptions{Currency: "USD", Rates: map[string]float64{"CNY": 0.14}}
+	cases := []struct {
+		opts     TCOOptions
+		currency string
+		want     float64
+		err      string
+	}{
+		{TCOOptions{}, "CNY", 1, ""},
+		{opts, "USD", 1, ""},
+		{opts, "usd", 1, ""},
+		{opts, "CNY", 0.14, ""},
+		{opts, "cny", 0.14, ""},
+		{opts, "EUR", 0, "no exchange rate of EUR to USD"},
+	}
+	for _, c := range cases {
+		rate, err := c.opts.Rate(c.currency)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("rate of %s to %q: %v, want %q", c.currency, c.opts.Currency, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !closeTo(rate, c.want) {
+			t.Errorf("rate of %s to %q is %g, %v, want %g", c.currency, c.opts.Currency, rate, err, c.want)
+		}
+	}
+}
+
+func TestTCOOptionsPoolCost(t *testing.T) {
+	bill := NodeBill{InternetChargeType: PayByBandwidth, Bandwidth: 5}
+	node, err := bill.Cost(replayedPrice(t, "ecs.c6.large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		opts TCOOptions
+		want PoolCost
+	}{
+		{"account currency", TCOOptions{Bill: bill}, PoolCost{Currency: "CNY", Node: 0.8548, Hourly: 0.9048, Monthly: 0.9048 * HoursPerMonth}},
+		{"converted", TCOOptions{Bill: bill, Currency: "usd", Rates: map[string]float64{"CNY": 0.14}},
+			PoolCost{Currency: "USD", Node: 0.14 * 0.8548, Hourly: 0.14 * 0.9048, Monthly: 0.14 * 0.9048 * HoursPerMonth}},
+	}
+	for _, c := range cases {
+		got, err := c.opts.PoolCost(0.05, node)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got.Currency != c.want.Currency || !closeTo(got.Node, c.want.Node) || !closeTo(got.Hourly, c.want.Hourly) || !closeTo(got.Monthly, c.want.Monthly) {
+			t.Errorf("%s: cost %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	if _, err := (TCOOptions{Bill: bill, Currency: "EUR"}).PoolCost(0.05, node); err == nil || !strings.Contains(err.Error(), "no exchange rate of CNY to EUR") {
+		t.Errorf("error of a missing rate is %v", err)
+	}
+}
+
+func TestFetchNodeCosts(t *testing.T) {
+	client := newCountingClient("cn-hangzhou")
+	ms := NewMetaStore(client)
+	ms.Region = "cn-hangzhou"
+	ms.FetchOptions.QPS = 0
+	ms.TCO = &TCOOptions{Bill: NodeBill{InternetChargeType: PayByBandwidth, Bandwidth: 5}}
+
+	// ecs.nosuch.large has no recorded price, so it is left out
+	ms.FetchNodeCosts([]string{"ecs.c6.large", "ecs.g6.large", "ecs.nosuch.large"})
+	for _, instanceType := range []string{"ecs.c6.large", "ecs.g6.large"} {
+		if cost, ok := ms.NodeCost(instanceType); !ok || cost.Currency != "CNY" || cost.Hourly <= 0 {
+			t.Errorf("node cost of %s is %+v, %v", instanceType, cost, ok)
+		}
+	}
+	if _, ok := ms.NodeCost("ecs.nosuch.large"); ok {
+		t.Errorf("node cost of a failed instanceType is kept")
+	}
+	if client.calls["DescribePrice"] != 3 {
+		t.Errorf("%d calls to price 3 instanceTypes", client.calls["DescribePrice"])
+	}
+
+	// the priced ones are not fetched again, while the costs are read
+	client.reset()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ms.FetchNodeCosts([]string{"ecs.c6.large", "ecs.g6.large"})
+		}()
+		go func() {
+			defer wg.Done()
+			ms.NodeCost("ecs.c6.large")
+		}()
+	}
+	wg.Wait()
+	if client.calls["DescribePrice"] != 0 {
+		t.Errorf("%d calls to price priced instanceTypes", client.calls["DescribePrice"])
+	}
+}
+
+func TestParseDataDisks(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []DataDisk
+		err   string
+	}{
+		{"", []DataDisk{}, ""},
+		{"cloud_essd:100", []DataDisk{{Category: "cloud_essd", Size: 100}}, ""},
+		{" cloud_essd : 100 ,cloud_efficiency:40,", []DataDisk{{Category: "cloud_essd", Size: 100}, {Category: "cloud_efficiency", Size: 40}}, ""},
+		{"cloud_essd", nil, `invalid data disk "cloud_essd", expect category:size`},
+		{"cloud_essd:big", nil, `invalid size of data disk "cloud_essd:big"`},
+		{"cloud_essd:0", nil, `invalid size of data disk "cloud_essd:0"`},
+	}
+	for _, c := range cases {
+		disks, err := ParseDataDisks(c.value)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("parse %q: %v, want %q", c.value, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(disks) != len(c.want) {
+			t.Errorf("parse %q: %v, %v, want %v", c.value, disks, err, c.want)
+			continue
+		}
+		for i := range disks {
+			if disks[i] != c.want[i] {
+				t.Errorf("parse %q: disk %d is %+v, want %+v", c.value, i, disks[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	cases := []struct {
+		value string
+		want  map[string]float64
+		err   string
+	}{
+		{"", map[string]float64{}, ""},
+		{"cny=0.14", map[string]float64{"CNY": 0.14}, ""},
+		{" CNY = 0.14 ,jpy=0.0067,", map[string]float64{"CNY": 0.14, "JPY": 0.0067}, ""},
+		{"CNY", nil, `invalid exchange rate "CNY", expect currency=rate`},
+		{"CNY=x", nil, `invalid exchange rate "CNY=x"`},
+		{"CNY=-1", nil, `invalid exchange rate "CNY=-1"`},
+	}
+	for _, c := range cases {
+		rates, err := ParseRates(c.value)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("parse %q: %v, want %q", c.value, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(rates) != len(c.want) {
+			t.Errorf("parse %q: %v, %v, want %v", c.value, rates, err, c.want)
+			continue
+		}
+		for currency, rate := range c.want {
+			if !closeTo(rates[currency], rate) {
+				t.Errorf("parse %q: rate of %s is %g, want %g", c.value, currency, rates[currency], rate)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// time range of the price history to analyze
+type PriceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// NewPriceWindow builds the window from optional start and end times (RFC3339).
+// A missing end means now, a missing start means resolution days before the end.
+func NewPriceWindow(start, end string, resolution int) (PriceWindow, error) {
+	window := PriceWindow{End: time.Now().UTC()}
+
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return window, fmt.Errorf("invalid end time %s: %v", end, err)
+		}
+		window.End = t.UTC()
+	}
+
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return window, fmt.Errorf("invalid start time %s: %v", start, err)
+		}
+		window.Start = t.UTC()
+	} else {
+		window.Start = window.End.Add(time.Duration(resolution*-24) * time.Hour)
+	}
+
+	if !window.Start.Before(window.End) {
+		return window, fmt.Errorf("start time %s is not before end time %s", window.StartTime(), window.EndTime())
+	}
+	return window, nil
+}
+
+// StartTime in the layout of the ecs api
+func (w PriceWindow) StartTime() string {
+	return w.Start.Format(TimeLayout)
+}
+
+// EndTime in the layout of the ecs api
+func (w PriceWindow) EndTime() string {
+	return w.End.Format(TimeLayout)
+}
+
+// Contains reports whether the timestamp falls in the window, bounds included.
+func (w PriceWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && !t.After(w.End)
+}
+
+// Duration of the window
+func (w PriceWindow) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPriceWindow(t *testing.T) {
+	cases := []struct {
+		name       string
+		start, end string
+		resolution int
+		want       PriceWindow
+		err        string
+	}{
+		{"start and end", "2026-10-11T00:00:00Z", "2026-10-18T00:00:00Z", 3,
+			PriceWindow{time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}, ""},
+		{"resolution before the end", "", "2026-10-18T00:00:00Z", 3,
+			PriceWindow{time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}, ""},
+		{"offset", "2026-10-11T08:00:00+08:00", "2026-10-12T00:00:00Z", 0,
+			PriceWindow{time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)}, ""},
+		{"invalid start", "2026-10-11", "2026-10-18T00:00:00Z", 3, PriceWindow{}, "invalid start time 2026-10-11"},
+		{"invalid end", "", "yesterday", 3, PriceWindow{}, "invalid end time yesterday"},
+		{"start after end", "2026-10-19T00:00:00Z", "2026-10-18T00:00:00Z", 3, PriceWindow{},
+			"start time 2026-10-19T00:00:00Z is not before end time 2026-10-18T00:00:00Z"},
+		{"empty", "2026-10-18T00:00:00Z", "2026-10-18T00:00:00Z", 3, PriceWindow{}, "is not before end time"},
+		{"no resolution", "", "2026-10-18T00:00:00Z", 0, PriceWindow{}, "is not before end time"},
+	}
+	for _, c := range cases {
+		window, err := NewPriceWindow(c.start, c.end, c.resolution)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error is %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !window.Start.Equal(c.want.Start) || !window.End.Equal(c.want.End) {
+			t.Errorf("%s: window is %s to %s, want %s to %s", c.name, window.StartTime(), window.EndTime(), c.want.StartTime(), c.want.EndTime())
+		}
+	}
+}
+
+func TestNewPriceWindowEndsNow(t *testing.T) {
+	before := time.Now().UTC()
+	window, err := NewPriceWindow("", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if window.End.Before(before) || window.End.After(time.Now().UTC()) {
+		t.Errorf("end %s is not now", window.EndTime())
+	}
+	if window.Duration() != 24*time.Hour {
+		t.Errorf("window lasts %s, want a day", window.Duration())
+	}
+}
+
+func TestPriceWindowContains(t *testing.T) {
+	window := PriceWindow{time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{window.Start, true},
+		{window.End, true},
+		{window.Start.Add(-time.Second), false},
+		{window.End.Add(time.Second), false},
+	}
+	for _, c := range cases {
+		if got := window.Contains(c.t); got != c.want {
+			t.Errorf("contains %s is %v, want %v", c.t, got, c.want)
+		}
+	}
+}